@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+
+/* Returns the names of the character variables other than the serial, the verbatims of open-ended questions. */
+func TextVariables(d *Variables) []string {
+	var text []string
+	for _, v := range d.Variable {
+		if v.Type == "character" && v.Use != "serial" {
+			text = append(text, v.Name)
+		}
+	}
+	return text
+}
+
+/* Returns a serial replaced by a keyed hash of it that fits the columns of the serial: digits for
+a numeric serial and hexadecimal for a character one. */
+func HashSerial(id string, key string, serial *Variable) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(id))
+	sum := mac.Sum(nil)
+	width := serial.Width()
+	var hashed string
+	if serial.Format() == "" {
+		hashed = hex.EncodeToString(sum)
+	} else {
+		hashed = new(big.Int).SetBytes(sum).String()
+	}
+	if len(hashed) > width {
+		hashed = hashed[:width]
+	}
+	return hashed
+}
+
+/* Writes a copy of the data file with the serial replaced by HashSerial when hash is set and the columns
+of the named variables blanked. Two serials hashing to the same value are an error, since the cases could
+no longer be told apart; a wider serial makes that less likely. */
+func AnonymizeData(asc string, out string, hash bool, key string, blank []string, d *Variables) error {
+	serial := Serial(d)
+	if hash && serial == nil {
+		return fmt.Errorf("no variable is marked use=\"serial\", there is no serial to hash")
+	}
+	var cols []Column
+	blanked := make(map[string]bool)
+	for _, n := range blank {
+		blanked[n] = true
+	}
+	for _, v := range d.Variable {
+		if blanked[v.Name] {
+			cols = append(cols, v.Columns()...)
+		}
+	}
+	if hash {
+		cols = append(cols, Column{Start: serial.Position.Start, Finish: serial.Position.Finish})
+	}
+
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	hashes := make(map[string]string)
+	err = ReadRecords(asc, func(n int, rec string) error {
+		id := ""
+		if hash {
+			id = strings.TrimSpace(Field(rec, serial.Position.Start, serial.Position.Finish))
+		}
+		b := []byte(rec)
+		for _, c := range cols {
+			for i := c.Start - 1; i < c.Finish && i < len(b); i++ {
+				b[i] = ' '
+			}
+		}
+		if id != "" {
+			hashed := HashSerial(id, key, serial)
+			if other, ok := hashes[hashed]; ok && other != id {
+				return fmt.Errorf("record %d: serials %s and %s hash to the same value, the serial is too narrow to hash", n, other, id)
+			}
+			hashes[hashed] = id
+			copy(b[serial.Position.Start-1:], hashed)
+		}
+		_, err := w.Write(append(b, '\n'))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+
+/* Extensions of the data file that comes with a Triple-S file of the same name, in the order tried */
+var dataExtensions = []string{".asc", ".dat", ".txt"}
+
+/* Returns the files matching pattern. Besides the patterns of filepath.Glob a ** directory matches
+any number of directories, so a pattern starting with incoming/** finds files anywhere below incoming. */
+func GlobFiles(pattern string) ([]string, error) {
+	i := strings.Index(pattern, "**")
+	if i < 0 {
+		return filepath.Glob(pattern)
+	}
+	root, rest := filepath.Clean(pattern[:i]+"."), strings.TrimLeft(pattern[i+2:], "/")
+	depth := strings.Count(rest, "/") + 1
+	var files []string
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(p), "/")
+		if len(parts) < depth {
+			return nil
+		}
+		matched, err := filepath.Match(rest, strings.Join(parts[len(parts)-depth:], "/"))
+		if matched {
+			files = append(files, p)
+		}
+		return err
+	})
+	return files, err
+}
+
+/* Returns the data file delivered with a Triple-S file by naming convention: the same name with
+one of dataExtensions in lower or upper case. Returns an empty string when there is none. */
+func DataFileFor(metadata string) string {
+	base := strings.TrimSuffix(metadata, filepath.Ext(metadata))
+	for _, ext := range dataExtensions {
+		for _, e := range []string{ext, strings.ToUpper(ext)} {
+			if info, err := os.Stat(base + e); err == nil && !info.IsDir() {
+				return base + e
+			}
+		}
+	}
+	return ""
+}
+
+/* Flags of the runs over many surveys themselves, not passed on to the conversion of each. The summary
+of each conversion is written to a file of its own and gathered in to that of the run, and its messages
+are added to the log file of the run as the run prints them. */
+var batchFlags = map[string]bool{"glob": true, "jobs": true, "cache": true, "interval": true, "done-dir": true, "error-dir": true,
+	"summary": true, "log-file": true}
+
+/* Returns a usage error for the flags naming a single file, which the conversions of many surveys
+would all write at the same time: -o naming a file rather than a folder without -name-template,
+-report and the profiles. */
+func CheckBatchFlags() error {
+	for _, f := range []string{"report", "cpuprofile", "memprofile", "pprof-addr"} {
+		if value := flag.Lookup(f).Value.String(); value != "" {
+			return UsageError(fmt.Sprintf("-%s %s would be written by the conversion of every survey at once, leave it out with -glob and watch", f, value))
+		}
+	}
+	info, err := os.Stat(*output)
+	folder := *output == "" || err == nil && info.IsDir() || strings.HasSuffix(*output, "/") || path.Ext(*output) == ""
+	if *output == "-" || !folder && *nameTemplate == "" {
+		return UsageError(fmt.Sprintf("-o %s names a single file for every survey, give a folder or -name-template with -glob and watch", *output))
+	}
+	return nil
+}
+
+/* Returns the flags set for this run to pass on to the conversion of each survey. */
+func conversionFlags() []string {
+	var flags []string
+	flag.Visit(func(f *flag.Flag) {
+		if !batchFlags[f.Name] {
+			flags = append(flags, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+		}
+	})
+	return flags
+}
+
+/* Converts a Triple-S file with its data file by running this program on them with flags, so a failing
+survey does not stop the others. What the run prints goes to stdout and stderr. Returns the data file
+and the last message of a failed run as error, or ErrUnchanged without running when the cache holds the
+same files converted with the same flags. */
+func convertSurvey(metadata string, flags []string, stdout io.Writer, stderr io.Writer) (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	args := append([]string{"convert"}, flags...)
+	args = append(args, metadata)
+	data := DataFileFor(metadata)
+	read := data
+	if data != "" {
+		args = append(args, data)
+	} else if d, err := ReadMetadata(metadata); *to != "sss" && (err != nil || d.Href == "") {
+		return "", fmt.Errorf("no data file of the same name")
+	} else if err == nil && d.Href != "" {
+		read, _ = DataPath(metadata, d.Href)
+	} // A data file named by the metadata is read but not moved along
+	var key, hash string
+	if surveyCache != nil {
+		key, hash, err = surveyCache.Hash(metadata, read, flags)
+		if err != nil {
+			return data, err
+		} else if surveyCache.Unchanged(key, hash) {
+			return data, ErrUnchanged
+		}
+	}
+	var summarized string
+	if *summary != "" && !*dryRun {
+		dir, err := tempFolder()
+		if err != nil {
+			return data, err
+		}
+		file, err := os.CreateTemp(dir, "summary*.json")
+		if err != nil {
+			return data, err
+		}
+		file.Close()
+		summarized = file.Name()
+		args = append([]string{"convert", "-summary=" + summarized}, args[1:]...)
+	}
+	var messages bytes.Buffer
+	cmd := exec.Command(self, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = io.MultiWriter(stderr, &messages)
+	err = cmd.Run()
+	if summarized != "" {
+		if e := addSurveySummary(summarized); e != nil {
+			slog.Warn(fmt.Sprintf("No summary of %s: %v", metadata, e))
+		}
+		os.Remove(summarized)
+	} // Gathered in to the summary of the run
+	if err != nil {
+		return data, fmt.Errorf("%s", lastMessage(messages.String()))
+	} else if *dryRun {
+		return data, nil
+	} // A dry run converted nothing for the cache to remember
+	return data, surveyCache.Add(key, hash)
+}
+
+/* Returns the last message a run logged, without its time and level. */
+func lastMessage(log string) string {
+	lines := strings.Split(strings.TrimSpace(log), "\n")
+	last := lines[len(lines)-1]
+	if stamp := len("2006/01/02 15:04:05 "); len(last) > stamp && last[4] == '/' {
+		last = last[stamp:]
+	}
+	return strings.TrimPrefix(last, "ERROR ")
+}
+
+/* Converts every Triple-S file matching pattern with its data file with the flags set for the run,
+jobs of them at a time. The messages of each conversion are printed together once it is done when more
+than one runs at a time. Prints a summary of the conversions and returns an error when any failed. */
+func Batch(pattern string, jobs int) error {
+	files, err := GlobFiles(pattern)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no Triple-S files match %s", pattern)
+	}
+	if jobs < 1 {
+		return UsageError("-jobs must be at least 1")
+	}
+	flags := conversionFlags()
+	errs := make([]error, len(files))
+	next := make(chan int)
+	var printing sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < len(files); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if jobs == 1 {
+					_, errs[i] = convertSurvey(files[i], flags, os.Stdout, conversionLog())
+					continue
+				}
+				var stdout, stderr bytes.Buffer
+				_, errs[i] = convertSurvey(files[i], flags, &stdout, &stderr)
+				printing.Lock()
+				os.Stdout.Write(stdout.Bytes())
+				conversionLog().Write(stderr.Bytes())
+				printing.Unlock()
+			}
+		}()
+	}
+	for i := range files {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var failed []string
+	unchanged := 0
+	for i, err := range errs {
+		if errors.Is(err, ErrUnchanged) {
+			unchanged++
+		} else if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", files[i], err))
+		}
+	}
+	if unchanged > 0 {
+		slog.Info(fmt.Sprintf("%d surveys were skipped as unchanged since their last conversion", unchanged))
+	}
+	slog.Info(fmt.Sprintf("%d of %d surveys were converted", len(files)-len(failed)-unchanged, len(files)))
+	for _, f := range failed {
+		slog.Error(fmt.Sprintf("Failed: %s", f))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d surveys failed to convert", len(failed))
+	}
+	return nil
+}
+
+/* Size and modification time of a Triple-S file and its data file at a poll of Watch */
+type snapshot struct {
+	size		int64
+	mod		time.Time
+	dataSize	int64
+	dataMod		time.Time
+}
+
+/* Returns the snapshot of the Triple-S file and of its data file, the one of the same name or else
+the one its href names, which are left alone while either is still being copied in. */
+func takeSnapshot(metadata string) (snapshot, error) {
+	info, err := os.Stat(metadata)
+	if err != nil {
+		return snapshot{}, err
+	}
+	now := snapshot{size: info.Size(), mod: info.ModTime()}
+	data := DataFileFor(metadata)
+	if href := deliveryHref(metadata); data == "" && href != "" {
+		data, _ = DataPath(metadata, href)
+	}
+	if info, err := os.Stat(data); data != "" && err == nil {
+		now.dataSize, now.dataMod = info.Size(), info.ModTime()
+	}
+	return now, nil
+}
+
+/* Moves the files to dir, creating it when missing. */
+func moveTo(dir string, files ...string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		err = os.Rename(f, filepath.Join(dir, filepath.Base(f)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Polls dir every interval for Triple-S files and converts each once neither it nor its data file has
+changed between two polls, so files still being copied in are left alone. Polling rather than
+notifications of the file system works the same on every system and on network shares, where
+notifications are often not sent. The Triple-S file and its data file are moved to done after a
+conversion and to failed when it fails; files that cannot be moved are logged and not converted
+again until they change. Runs until the program is stopped. */
+func Watch(dir string, interval time.Duration, done string, failed string) error {
+	flags := conversionFlags()
+	seen := make(map[string]snapshot)
+	stuck := make(map[string]snapshot)
+	slog.Info(fmt.Sprintf("Watching %s for Triple-S files every %v", dir, interval))
+	for {
+		files, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+		if err != nil {
+			return err
+		}
+		current := make(map[string]snapshot)
+		for _, metadata := range files {
+			now, err := takeSnapshot(metadata)
+			if err != nil {
+				continue
+			}
+			current[metadata] = now
+			if before, ok := seen[metadata]; !ok || before != now || stuck[metadata] == now {
+				continue
+			}
+			data, err := convertSurvey(metadata, flags, os.Stdout, conversionLog())
+			target := done
+			if errors.Is(err, ErrUnchanged) {
+				slog.Info(fmt.Sprintf("Skipped %s, %v", metadata, err))
+			} else if err != nil {
+				slog.Error(fmt.Sprintf("Failed: %s: %v", metadata, err))
+				target = failed
+			} else {
+				slog.Info(fmt.Sprintf("Converted %s", metadata))
+			}
+			if *summary != "" && !*dryRun {
+				err := WriteSummary(*summary, nil)
+				if err != nil {
+					slog.Error(fmt.Sprintf("Cannot write the summary %s: %v", *summary, err))
+				}
+			} // Kept up to date, as the watch runs until it is stopped
+			err = moveTo(target, metadata, data)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Cannot move %s to %s: %v", metadata, target, err))
+				stuck[metadata] = now
+				continue
+			}
+			delete(current, metadata)
+		}
+		for metadata := range stuck {
+			if _, ok := current[metadata]; !ok {
+				delete(stuck, metadata)
+			}
+		}
+		seen = current
+		time.Sleep(interval)
+	}
+}
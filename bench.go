@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+
+/* Times the steps of a conversion and watches its memory for -bench */
+type Bench struct {
+	start	time.Time
+	last	time.Time
+	steps	[]benchStep
+	mu	sync.Mutex
+	peak	uint64
+	stop	chan bool
+}
+
+type benchStep struct {
+	name	string
+	took	time.Duration
+}
+
+/* Benchmark of the run, nil without -bench */
+var benchmark *Bench
+
+/* Metrics adding up to the heap in use, which runtime/metrics reads without stopping the world */
+var heapMetrics = []string{"/memory/classes/heap/objects:bytes", "/memory/classes/heap/unused:bytes"}
+
+/* Starts timing the run, sampling the heap in use every few milliseconds for its peak. */
+func NewBench() *Bench {
+	b := &Bench{start: time.Now(), stop: make(chan bool)}
+	b.last = b.start
+	go func() {
+		tick := time.NewTicker(5 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			b.sample()
+			select {
+			case <-tick.C:
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+	return b
+}
+
+func (b *Bench) sample() {
+	samples := make([]metrics.Sample, len(heapMetrics))
+	for i, name := range heapMetrics {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+	var inuse uint64
+	for _, s := range samples {
+		if s.Value.Kind() == metrics.KindUint64 {
+			inuse += s.Value.Uint64()
+		}
+	}
+	b.mu.Lock()
+	if inuse > b.peak {
+		b.peak = inuse
+	}
+	b.mu.Unlock()
+}
+
+/* Ends the step name, timed from the end of the one before. */
+func (b *Bench) Step(name string) {
+	if b == nil {
+		return
+	}
+	now := time.Now()
+	b.steps = append(b.steps, benchStep{name, now.Sub(b.last)})
+	b.last = now
+}
+
+/* Writes the time of every step, the peak memory and the throughput over the read bytes and the
+variables of the run to w. */
+func (b *Bench) Report(w io.Writer, read int64, variables int) error {
+	close(b.stop)
+	b.sample()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	total := time.Since(b.start)
+	seconds := total.Seconds()
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, s := range b.steps {
+		fmt.Fprintf(tw, "%s\t%.3fs\t%.1f%%\n", s.name, s.took.Seconds(), 100*s.took.Seconds()/seconds)
+	}
+	fmt.Fprintf(tw, "total\t%.3fs\n", seconds)
+	fmt.Fprintf(tw, "peak heap\t%s\n", Size(int64(b.peak)))
+	fmt.Fprintf(tw, "memory from the system\t%s\n", Size(int64(m.Sys)))
+	fmt.Fprintf(tw, "allocated\t%s\t%d allocations\n", Size(int64(m.TotalAlloc)), m.Mallocs)
+	fmt.Fprintf(tw, "read\t%s\t%s/s\n", Size(read), Size(int64(float64(read)/seconds)))
+	fmt.Fprintf(tw, "variables\t%d\t%.0f/s\n", variables, float64(variables)/seconds)
+	return tw.Flush()
+}
+
+/* Returns the bytes of the files of the run read so far, standard input counted as read. */
+func runBytes() int64 {
+	n := int64(len(stdinCopy))
+	for _, f := range append([]string{runMetadata}, runData...) {
+		if info, err := os.Stat(f); err == nil && f != "-" {
+			n += info.Size()
+		}
+	}
+	return n
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+
+/* Hashes of the surveys converted by earlier runs of -glob and watch, kept in the file of -cache */
+type Cache struct {
+	file	string
+	mu	sync.Mutex
+	Hashes	map[string]string	`json:"hashes"`	// hash of the last good conversion by Triple-S file
+}
+
+/* Cache of the run, nil without -cache */
+var surveyCache *Cache
+
+/* Returned for a survey converted before from the same files with the same flags */
+var ErrUnchanged = errors.New("unchanged since its last conversion")
+
+/* Reads the cache file, which is created by the first conversion when missing. */
+func LoadCache(file string) (*Cache, error) {
+	c := &Cache{file: file, Hashes: make(map[string]string)}
+	b, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Hashes == nil {
+		c.Hashes = make(map[string]string)
+	}
+	return c, nil
+}
+
+/* Returns the hash of a conversion of the Triple-S file and its data file with flags, which changes
+when either file or any flag does. Also returns the key of the survey in the cache. */
+func (c *Cache) Hash(metadata string, data string, flags []string) (string, string, error) {
+	key, err := filepath.Abs(metadata)
+	if err != nil {
+		return "", "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(strings.Join(flags, "\x00") + "\x00"))
+	for _, f := range []string{metadata, data} {
+		if f == "" {
+			continue
+		}
+		sum, err := Checksum(f)
+		if err != nil {
+			return "", "", err
+		}
+		h.Write([]byte(sum.Sha256))
+	}
+	return key, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+/* Reports whether the survey of key was converted last with the same hash. */
+func (c *Cache) Unchanged(key string, hash string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Hashes[key] == hash
+}
+
+/* Records a good conversion of the survey of key and writes the cache file, replacing it only once
+written in full so a run stopped halfway leaves the old one. */
+func (c *Cache) Add(key string, hash string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Hashes[key] = hash
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.file + ".tmp"
+	err = os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+
+/* Types of the columns of a record batch, named after the Arrow types they are laid out as */
+const (
+	columnUtf8	= iota
+	columnInt64
+	columnFloat64
+)
+
+/* The values of one column for the records of a batch, laid out as Apache Arrow lays out arrays: a
+bitmap with a bit set for every value that is not null, least significant bit first, and either 8-byte
+little-endian values with a zero in the place of a null or the bytes of all text values with the offset
+each value starts at. Text values are never null, a blank field is an empty string. */
+type BatchColumn struct {
+	Col	Column
+	Type	int
+	Valid	[]byte
+	Values	[]byte
+	Offsets	[]int32
+	Nulls	int
+}
+
+/* Records of the data file read in to columns, for the outputs written column by column, which is
+Parquet. Validation, delimited text and frequencies read the fields of each record instead, as they need
+the text as it is written: a value that is no number is a problem to report or a value to count there,
+where a batch holds a null. */
+type RecordBatch struct {
+	Columns	[]*BatchColumn
+	Rows	int
+}
+
+/* Returns the type of batch column c is read in to: text for strings, whole numbers for the codes of
+categories and logicals and doubles for quantities, which may hold a fraction whatever their range. */
+func ColumnType(c Column) int {
+	if c.Print == "" {
+		return columnUtf8
+	} else if c.Type != "quantity" && strings.HasSuffix(c.Print, ".0") {
+		return columnInt64
+	}
+	return columnFloat64
+}
+
+/* Returns an empty batch with a column for every SPSS column of the variables of d. */
+func NewRecordBatch(d *Variables) *RecordBatch {
+	b := new(RecordBatch)
+	for _, v := range d.Variable {
+		for _, c := range v.Columns() {
+			b.Columns = append(b.Columns, &BatchColumn{Col: c, Type: ColumnType(c), Offsets: []int32{0}})
+		}
+	}
+	return b
+}
+
+/* Adds the values of a record to the columns. Fails on a code that is not a whole number, which has
+no place in a column of whole numbers. */
+func (b *RecordBatch) Add(rec string) error {
+	if b.Rows%8 == 0 {
+		for _, bc := range b.Columns {
+			bc.Valid = append(bc.Valid, 0)
+		}
+	}
+	for _, bc := range b.Columns {
+		err := bc.add(b.Rows, CleanField(bc.Col, rec))
+		if err != nil {
+			return err
+		}
+	}
+	b.Rows++
+	return nil
+}
+
+/* Empties the batch, keeping the memory of its columns for the next records. */
+func (b *RecordBatch) Reset() {
+	for _, bc := range b.Columns {
+		bc.Valid, bc.Values, bc.Offsets, bc.Nulls = bc.Valid[:0], bc.Values[:0], bc.Offsets[:1], 0
+	}
+	b.Rows = 0
+}
+
+func (bc *BatchColumn) add(row int, value string) error {
+	if bc.Type == columnUtf8 {
+		bc.Valid[row/8] |= 1 << uint(row%8)
+		bc.Values = append(bc.Values, value...)
+		bc.Offsets = append(bc.Offsets, int32(len(bc.Values)))
+		return nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		bc.Values = binary.LittleEndian.AppendUint64(bc.Values, 0)
+		bc.Nulls++
+		return nil
+	}
+	if bc.Type == columnInt64 && f != math.Trunc(f) {
+		return fmt.Errorf("the code %s of %s is not a whole number: %w", value, bc.Col.Name, ErrInvalid)
+	}
+	bc.Valid[row/8] |= 1 << uint(row%8)
+	if bc.Type == columnInt64 {
+		bc.Values = binary.LittleEndian.AppendUint64(bc.Values, uint64(int64(f)))
+	} else {
+		bc.Values = binary.LittleEndian.AppendUint64(bc.Values, math.Float64bits(f))
+	}
+	return nil
+}
+
+/* Reports whether the value of row is not null. */
+func (bc *BatchColumn) IsValid(row int) bool {
+	return bc.Valid[row/8]&(1<<uint(row%8)) != 0
+}
+
+/* Returns the text value of row of a text column. */
+func (bc *BatchColumn) Text(row int) string {
+	return string(bc.Values[bc.Offsets[row]:bc.Offsets[row+1]])
+}
+
+/* Returns the 8 bytes of the value of row of a number column. */
+func (bc *BatchColumn) Number(row int) []byte {
+	return bc.Values[8*row : 8*row+8]
+}
+
+/* Calls fn with the records of the data file read in to batches of at most rows records. The batch
+passed is reused for the next records once fn returns. */
+func ReadBatches(asc string, rows int, d *Variables, fn func(b *RecordBatch) error) error {
+	b := NewRecordBatch(d)
+	err := ReadRecords(asc, func(n int, rec string) error {
+		err := b.Add(rec)
+		if err != nil {
+			return fmt.Errorf("record %d: %w", n, err)
+		}
+		if b.Rows < rows {
+			return nil
+		}
+		err = fn(b)
+		b.Reset()
+		return err
+	})
+	if err != nil || b.Rows == 0 {
+		return err
+	}
+	return fn(b)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+
+/* Widest cell of a preview table, in bytes */
+const previewCell = 24
+
+/* Parses the flags of a command wherever they are given among the file paths and returns the paths. */
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var paths []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return paths
+		}
+		paths = append(paths, args[0])
+		args = args[1:]
+	}
+}
+
+
+/* Runs a data command: convert writes the fixed-width data file as delimited text, repack writes
+delimited data as a fixed-width data file at the positions of the metadata. */
+func DataCommand(args []string) error {
+	if len(args) == 0 {
+		return UsageError("Usage: XMLtoSPS data convert|repack [-delimiter d] [-sample n] <XML:filepath> <ASC:filepath>")
+	}
+	switch args[0] {
+	case "convert":
+		fs := flag.NewFlagSet("data convert", flag.ExitOnError)
+		delimiter := fs.String("delimiter", ",", "field delimiter, \\t for tabs")
+		fs.IntVar(sample, "sample", 0, "convert only the first n records")
+		paths := parseArgs(fs, args[1:])
+		if len(paths) < 2 {
+			return UsageError("Usage: XMLtoSPS data convert [-delimiter d] [-sample n] <XML:filepath> <ASC:filepath>")
+		}
+		d, err := ReadMetadata(paths[0])
+		if err != nil {
+			return err
+		}
+		comma, ext, err := parseDelimiter(*delimiter)
+		if err != nil {
+			return err
+		}
+		out := fmt.Sprintf("%s/%s.%s", path.Dir(paths[0]), strings.TrimSuffix(path.Base(paths[0]), path.Ext(paths[0])), ext)
+		return WriteDelimited(paths[1], out, comma, d)
+	case "repack":
+		fs := flag.NewFlagSet("data repack", flag.ExitOnError)
+		delimiter := fs.String("delimiter", ",", "field delimiter, \\t for tabs")
+		paths := parseArgs(fs, args[1:])
+		if len(paths) < 2 {
+			return UsageError("Usage: XMLtoSPS data repack [-delimiter d] <XML:filepath> <CSV:filepath>")
+		}
+		d, err := ReadMetadata(paths[0])
+		if err != nil {
+			return err
+		}
+		comma, _, err := parseDelimiter(*delimiter)
+		if err != nil {
+			return err
+		}
+		out := fmt.Sprintf("%s/%s.asc", path.Dir(paths[0]), strings.TrimSuffix(path.Base(paths[0]), path.Ext(paths[0])))
+		if path.Clean(out) == path.Clean(paths[1]) {
+			return fmt.Errorf("%s would be overwritten by the fixed-width data", paths[1])
+		}
+		n, err := RepackDelimited(paths[1], out, comma, d)
+		if err != nil {
+			return err
+		}
+		slog.Info(fmt.Sprintf("%d records were written to %s", n, out))
+		return nil
+	}
+	return UsageError(fmt.Sprintf("Unknown data command %s, use convert or repack", args[0]))
+}
+
+/* Returns the rune of a delimiter given on the command line and the extension of files using it. */
+func parseDelimiter(delimiter string) (rune, string, error) {
+	if delimiter == "\\t" || delimiter == "\t" {
+		return '\t', "tsv", nil
+	}
+	r, n := utf8.DecodeRuneInString(delimiter)
+	if n == 0 || n != len(delimiter) {
+		return 0, "", UsageError(fmt.Sprintf("The delimiter must be a single character, not %q", delimiter))
+	} else if r == ',' {
+		return r, "csv", nil
+	}
+	return r, "txt", nil
+}
+
+/* Writes rows cases of the data file from record first on as a table with the column names as headers
+and value labels in place of the codes they label. */
+func Preview(asc string, first int, rows int, w io.Writer, d *Variables) error {
+	var cols []Column
+	for _, v := range d.Variable {
+		cols = append(cols, v.Columns()...)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	cells := make([]string, len(cols))
+	for i, c := range cols {
+		cells[i], _ = Truncate(c.Name, previewCell, true)
+	}
+	fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	done := errors.New("done")
+	err := ReadRecordsFrom(asc, first, func(n int, rec string) error {
+		if n >= first+rows {
+			return done
+		}
+		for i, c := range cols {
+			value := CleanField(c, rec)
+			for _, l := range c.Labels {
+				if value == fmt.Sprint(l.Value) {
+					value = l.Name
+				}
+			}
+			cells[i], _ = Truncate(strings.Join(strings.Fields(value), " "), previewCell, true)
+		}
+		_, err := fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		return err
+	})
+	if err != nil && err != done {
+		return err
+	}
+	return tw.Flush()
+}
+
+/* Runs the preview command printing the first cases of the data file. */
+func PreviewCommand(args []string) error {
+	fs := flag.NewFlagSet("preview", flag.ExitOnError)
+	rows := fs.Int("rows", 10, "number of cases shown")
+	from := fs.Int("from", 1, "number of the first record shown")
+	fs.IntVar(sample, "sample", 0, "read only the first n records")
+	paths := parseArgs(fs, args)
+	if len(paths) < 2 {
+		return UsageError("Usage: XMLtoSPS preview [-rows n] [-from n] [-sample n] <XML:filepath> <ASC:filepath>")
+	}
+	d, err := ReadMetadata(paths[0])
+	if err != nil {
+		return err
+	}
+	return Preview(paths[1], *from, *rows, os.Stdout, d)
+}
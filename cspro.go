@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+
+/* Longest name CSPro accepts */
+const csproName = 32
+
+/* Returns name as a CSPro name: upper case letters, digits and underscores starting with a letter. */
+func csproIdent(name string) string {
+	name = strings.ToUpper(Identifier(name, csproName))
+	if strings.HasPrefix(name, "_") {
+		name = cutBytes("V"+name, csproName)
+	}
+	return name
+}
+
+/* Returns s on one line for a dictionary entry. */
+func csproLabel(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+/* Writes the [Item] of a column with the given name, followed by its value set if it has labels. */
+func csproItem(w *bufio.Writer, c Column, name string) {
+	fmt.Fprintf(w, "[Item]\nLabel=%s\nName=%s\nStart=%d\nLen=%d\n", csproLabel(c.Label), name, c.Start,
+		c.Finish-c.Start+1)
+	if c.Print == "" {
+		fmt.Fprint(w, "DataType=Alpha\n")
+	} else if i := strings.Index(c.Print, "."); c.Print[i+1:] != "0" {
+		fmt.Fprintf(w, "Decimal=%s\nDecimalChar=Yes\n", c.Print[i+1:])
+	}
+	fmt.Fprint(w, "\n")
+	if len(c.Labels) > 0 {
+		fmt.Fprintf(w, "[ValueSet]\nLabel=%s\nName=%s_VS1\n", csproLabel(c.Label), name)
+		for _, l := range c.Labels {
+			fmt.Fprintf(w, "Value=%d;%s\n", l.Value, csproLabel(l.Name))
+		}
+		fmt.Fprint(w, "\n")
+	}
+}
+
+/* Writes a CSPro data dictionary with absolute positions, so CSPro reads and writes the data file in
+the layout of the Triple-S file. The serial variable, or the first one without it, is the id item.
+Every SPSS column becomes an item and labelled columns get the value set of their SPSS value labels. */
+func WriteCspro(out string, d *Variables) error {
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	length := 0
+	for _, v := range d.Variable {
+		if v.Position.Finish > length {
+			length = v.Position.Finish
+		}
+	}
+	id := Serial(d)
+	if id == nil && len(d.Variable) > 0 {
+		id = &d.Variable[0]
+	}
+	name := csproIdent(d.Name)
+	if d.Name == "" {
+		name = "SURVEY"
+	}
+	title := d.Title
+	if title == "" {
+		title = d.Name
+	}
+
+	fmt.Fprintf(w, "[Dictionary]\nVersion=CSPro 7.7\nLabel=%s\nName=%s_DICT\n", csproLabel(title), name)
+	fmt.Fprint(w, "RecordTypeStart=0\nRecordTypeLen=0\nPositions=Absolute\nZeroFill=No\nDecimalChar=Yes\n\n")
+	fmt.Fprintf(w, "[Level]\nLabel=%s Level\nName=%s_LEVEL\n\n", csproLabel(title), name)
+	var ids, cols []Column
+	if id != nil {
+		ids = id.Columns()
+	}
+	for _, v := range d.Variable {
+		if id == nil || v.Name != id.Name {
+			cols = append(cols, v.Columns()...)
+		}
+	}
+	names := make([]string, len(ids)+len(cols))
+	for i, c := range append(ids, cols...) {
+		names[i] = csproIdent(c.Name)
+	}
+	names = uniqueNames(names, csproName)
+
+	fmt.Fprint(w, "[IdItems]\n\n")
+	for i, c := range ids {
+		csproItem(w, c, names[i])
+	}
+	fmt.Fprintf(w, "[Record]\nLabel=%s Record\nName=%s_REC\nRecordTypeValue=''\nRecordLen=%d\n\n",
+		csproLabel(title), name, length)
+	for i, c := range cols {
+		csproItem(w, c, names[len(ids)+i])
+	}
+	return w.Flush()
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+
+/* Structures of the JSON codebook */
+type Codebook struct {
+	Name		string			`json:"name"`
+	Version		string			`json:"version"`
+	Title		string			`json:"title"`
+	Variables	[]CodebookVariable	`json:"variables"`
+}
+
+type CodebookVariable struct {
+	Name		string			`json:"name"`
+	Ident		string			`json:"ident"`
+	Type		string			`json:"type"`
+	Label		string			`json:"label"`
+	Start		int			`json:"start"`
+	Finish		int			`json:"finish"`
+	Columns		[]string		`json:"columns"`
+	Values		[]CodebookValue		`json:"values,omitempty"`
+}
+
+type CodebookValue struct {
+	Code		int			`json:"code"`
+	Label		string			`json:"label"`
+}
+
+/* Returns the codebook of the Triple-S metadata. */
+func NewCodebook(d *Variables) Codebook {
+	cb := Codebook{Name: d.Name, Version: d.Version, Title: d.Title, Variables: []CodebookVariable{}}
+	for _, v := range d.Variable {
+		cv := CodebookVariable{Name: v.Name, Ident: v.Ident, Type: v.Type, Label: v.Label,
+			Start: v.Position.Start, Finish: v.Position.Finish, Columns: v.SpssNames()}
+		for _, val := range v.Vals {
+			cv.Values = append(cv.Values, CodebookValue{val.Value, val.Name})
+		}
+		cb.Variables = append(cb.Variables, cv)
+	}
+	return cb
+}
+
+/* Writes the codebook of the Triple-S metadata as indented JSON. */
+func WriteCodebook(out string, d *Variables) error {
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	return enc.Encode(NewCodebook(d))
+}
+
+/* Returns a number whose last digit carries its sign as a COBOL overpunch, { and A to I for positive
+and } and J to R for negative digits, as a signed number. Anything else is returned as it is. */
+func Unpunch(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return value
+	}
+	last, sign := value[len(value)-1], ""
+	switch {
+	case last == '{':
+		last = '0'
+	case last >= 'A' && last <= 'I':
+		last = '1' + last - 'A'
+	case last == '}':
+		last, sign = '0', "-"
+	case last >= 'J' && last <= 'R':
+		last, sign = '1'+last-'J', "-"
+	default:
+		return value
+	}
+	return sign + value[:len(value)-1] + string(last)
+}
+
+/* Returns a number written without a decimal point with its last dec digits after one,
+so 1234 with 2 implied decimals is 12.34. Anything else is returned as it is. */
+func ImplyDecimals(value string, dec int) string {
+	value = strings.TrimSpace(value)
+	if dec <= 0 || strings.Contains(value, ".") || !IsNumber(value) {
+		return value
+	}
+	sign := ""
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		sign, value = value[:1], value[1:]
+	}
+	if len(value) <= dec {
+		value = strings.Repeat("0", dec-len(value)+1) + value
+	}
+	return sign + value[:len(value)-dec] + "." + value[len(value)-dec:]
+}
+
+/* Returns the value of a column in a record, trimmed. Numbers also lose leading zeros and a trailing
+decimal point so they read the same in any tool. */
+func CleanField(c Column, rec string) string {
+	value := strings.TrimSpace(Field(rec, c.Start, c.Finish))
+	if c.Print == "" || value == "" {
+		return value
+	}
+	if c.Overpunch {
+		value = Unpunch(value)
+	}
+	value = ImplyDecimals(value, c.Implied)
+	neg := strings.HasPrefix(value, "-")
+	value = strings.TrimLeft(strings.TrimPrefix(value, "-"), "0")
+	if strings.Contains(value, ".") {
+		value = strings.TrimSuffix(strings.TrimRight(value, "0"), ".")
+	}
+	if value == "" || strings.HasPrefix(value, ".") {
+		value = "0" + value
+	}
+	if neg && value != "0" {
+		value = "-" + value
+	}
+	return value
+}
+
+/* Writes the fixed-width data file as CSV with a header row of the column names. */
+func WriteCsv(asc string, out string, d *Variables) error {
+	return WriteDelimited(asc, out, ',', d)
+}
+
+/* Writes the fixed-width data file as delimited text with a header row of the column names,
+quoting fields the way CSV does. */
+func WriteDelimited(asc string, out string, delimiter rune, d *Variables) error {
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	b := bufio.NewWriter(file)
+	w := csv.NewWriter(b)
+	w.Comma = delimiter
+
+	var cols []Column
+	for _, v := range d.Variable {
+		cols = append(cols, v.Columns()...)
+	}
+	row := make([]string, len(cols))
+	for i, c := range cols {
+		row[i] = c.Name
+	}
+	err = w.Write(row)
+	if err != nil {
+		return err
+	}
+	err = ReadRecords(asc, func(n int, rec string) error {
+		for i, c := range cols {
+			row[i] = CleanField(c, rec)
+		}
+		return w.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+	w.Flush()
+	if w.Error() != nil {
+		return w.Error()
+	}
+	return b.Flush()
+}
+
+/* Writes delimited data, such as a supplier export or the output of WriteDelimited, as a fixed-width
+data file with every value at the position the metadata declares. Numbers are right aligned and text
+left aligned in their columns. A first row naming every column is read as a header and gives the order
+of the fields, otherwise they come in the order of the columns. Returns the number of records written. */
+func RepackDelimited(in string, out string, delimiter rune, d *Variables) (int, error) {
+	var cols []Column
+	length := 0
+	for _, v := range d.Variable {
+		cols = append(cols, v.Columns()...)
+		if v.Position.Finish > length {
+			length = v.Position.Finish
+		}
+	}
+	index := make(map[string]int)
+	for i, c := range cols {
+		index[strings.ToLower(c.Name)] = i
+	}
+
+	src, err := os.Open(in)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	counted, p := ProgressReader(in, src)
+	defer p.Finish()
+	r := csv.NewReader(bufio.NewReader(counted))
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	file, err := Create(out)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	order := make([]int, len(cols))
+	for i := range order {
+		order[i] = i
+	}
+	n := 0
+	for line := 1; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return n, err
+		}
+		if line == 1 && len(row) == len(cols) {
+			header := make([]int, len(row))
+			for i, name := range row {
+				j, ok := index[strings.ToLower(strings.TrimSpace(name))]
+				if !ok {
+					header = nil
+					break
+				}
+				header[i] = j
+			}
+			if header != nil {
+				order = header
+				continue
+			}
+		}
+		if len(row) != len(cols) {
+			return n, fmt.Errorf("line %d has %d fields, the metadata has %d columns", line, len(row), len(cols))
+		}
+		rec := []byte(strings.Repeat(" ", length))
+		for i, field := range row {
+			c := cols[order[i]]
+			value := strings.TrimSpace(field)
+			width := c.Finish - c.Start + 1
+			if len(value) > width {
+				return n, fmt.Errorf("line %d: %s is %q, wider than its %d columns", line, c.Name, value, width)
+			}
+			if c.Print != "" {
+				value = strings.Repeat(" ", width-len(value)) + value
+			}
+			copy(rec[c.Start-1:], value)
+		}
+		_, err = w.Write(append(rec, '\n'))
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, w.Flush()
+}
+
+/* Writes the character variables to a CSV file for coding teams, one row per case with any text,
+keyed by the serial or, without a serial, the record number. Returns the number of rows written. */
+func ExportVerbatims(asc string, out string, d *Variables) (int, error) {
+	file, err := Create(out)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	b := bufio.NewWriter(file)
+	w := csv.NewWriter(b)
+
+	serial := Serial(d)
+	var cols []Column
+	row := []string{"record"}
+	if serial != nil {
+		row[0] = serial.Name
+	}
+	for _, v := range d.Variable {
+		if v.Type == "character" && v.Use != "serial" {
+			cols = append(cols, v.Columns()...)
+			row = append(row, v.Name)
+		}
+	}
+	err = w.Write(row)
+	if err != nil {
+		return 0, err
+	}
+	rows := 0
+	err = ReadRecords(asc, func(n int, rec string) error {
+		row[0] = fmt.Sprint(n)
+		if serial != nil {
+			row[0] = strings.TrimSpace(Field(rec, serial.Position.Start, serial.Position.Finish))
+		}
+		text := false
+		for i, c := range cols {
+			row[i+1] = strings.TrimSpace(Field(rec, c.Start, c.Finish))
+			text = text || row[i+1] != ""
+		}
+		if !text {
+			return nil
+		}
+		rows++
+		return w.Write(row)
+	})
+	if err != nil {
+		return rows, err
+	}
+	w.Flush()
+	if w.Error() != nil {
+		return rows, w.Error()
+	}
+	return rows, b.Flush()
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+
+/* A data file mapped in to memory, so records can be found without reading them one by one. Where
+the system cannot map it, it is read from the file instead. */
+type MappedFile struct {
+	file	*os.File
+	data	[]byte		// nil when not mapped
+	size	int64
+}
+
+func MapFile(name string) (*MappedFile, error) {
+	file, err := OpenData(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	m := &MappedFile{file: file, size: info.Size()}
+	if info.Mode().IsRegular() && m.size > 0 && int64(int(m.size)) == m.size {
+		m.data, err = mapFile(file, int(m.size))
+		if err != nil {
+			slog.Debug(fmt.Sprintf("Reading %s as it cannot be mapped: %v", name, err))
+			m.data = nil
+		}
+	}
+	return m, nil
+}
+
+func (m *MappedFile) Close() error {
+	if m.data != nil {
+		unmapFile(m.data)
+		m.data = nil
+	}
+	return m.file.Close()
+}
+
+/* Calls fn with every record of the fixed-width data file and its 1-based record number, or only
+the first -sample records when it is given. Records are read whole, so lines of any length are supported. */
+func ReadRecords(asc string, fn func(n int, rec string) error) error {
+	records := 0
+	err := ReadRecordsFrom(asc, 1, func(n int, rec string) error {
+		records = n
+		return fn(n, rec)
+	})
+	if err == nil {
+		countRecords(asc, records)
+	} // Counted for the summary
+	return err
+}
+
+/* Calls fn with the records of the data file from record first on, like ReadRecords. The records before
+are passed over by their line breaks, in the mapped file without being read in to strings. */
+func ReadRecordsFrom(asc string, first int, fn func(n int, rec string) error) error {
+	m, err := MapFile(asc)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	slog.Debug("Reading " + asc)
+	if m.data == nil {
+		return readRecords(m.file, asc, first, fn)
+	}
+
+	var p *Progress
+	if *progress {
+		p = NewProgress(asc, m.size)
+	}
+	defer p.Finish()
+	data := m.data
+	for n := 1; len(data) > 0 && (*sample <= 0 || n <= *sample); n++ {
+		end := bytes.IndexByte(data, '\n')
+		next := end + 1
+		if end < 0 {
+			end, next = len(data), len(data)
+		}
+		if n >= first {
+			err = fn(n, strings.TrimRight(string(data[:end]), "\r"))
+			if err != nil {
+				return err
+			}
+		}
+		p.Add(int64(next))
+		data = data[next:]
+	}
+	return nil
+}
+
+/* Reads the records of the open data file for ReadRecordsFrom when it is not mapped. */
+func readRecords(file *os.File, asc string, first int, fn func(n int, rec string) error) error {
+	src, p := ProgressReader(asc, file)
+	defer p.Finish()
+	r := bufio.NewReader(src)
+	for n := 1; *sample <= 0 || n <= *sample; n++ {
+		if n < first {
+			_, err := r.ReadSlice('\n')
+			for err == bufio.ErrBufferFull {
+				_, err = r.ReadSlice('\n')
+			}
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			continue
+		}
+		rec, err := r.ReadString('\n')
+		if err == io.EOF && rec == "" {
+			return nil
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+		err = fn(n, strings.TrimRight(rec, "\r\n"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Reads the records of a fixed-width data file one at a time, for reading it along with another. */
+type RecordReader struct {
+	file	*os.File
+	r	*bufio.Reader
+}
+
+func OpenRecords(asc string) (*RecordReader, error) {
+	file, err := OpenData(asc)
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("Reading " + asc)
+	return &RecordReader{file: file, r: bufio.NewReader(file)}, nil
+}
+
+/* Returns the next record, or io.EOF after the last. */
+func (rr *RecordReader) Next() (string, error) {
+	rec, err := rr.r.ReadString('\n')
+	if err == io.EOF && rec != "" {
+		err = nil
+	}
+	return strings.TrimRight(rec, "\r\n"), err
+}
+
+func (rr *RecordReader) Close() error {
+	return rr.file.Close()
+}
+
+/* Calls fn with every record of a data file without line breaks, cut in to records of length bytes,
+or only the first -sample records when it is given. A short last record is passed as it is. */
+func ReadFixedRecords(asc string, length int, fn func(n int, rec string) error) error {
+	if length <= 0 {
+		return fmt.Errorf("no record length to read %s with", asc)
+	}
+	file, err := OpenData(asc)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	src, p := ProgressReader(asc, file)
+	defer p.Finish()
+	r := bufio.NewReader(src)
+	buf := make([]byte, length)
+	for n := 1; *sample <= 0 || n <= *sample; n++ {
+		k, err := io.ReadFull(r, buf)
+		if k == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			return nil
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		err = fn(n, string(buf[:k]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Returns the content of the columns start to finish of a record, or less when the record is shorter.
+A start before the first column reads from the first, and a finish before the start reads nothing. */
+func Field(rec string, start int, finish int) string {
+	if start < 1 {
+		start = 1
+	}
+	if finish > len(rec) {
+		finish = len(rec)
+	}
+	if start > finish {
+		return ""
+	}
+	return rec[start-1 : finish]
+}
+
+/* Returns the variable marked use="serial", or nil when there is none. */
+func Serial(d *Variables) *Variable {
+	for i, v := range d.Variable {
+		if v.Use == "serial" {
+			return &d.Variable[i]
+		}
+	}
+	return nil
+}
+
+/* Returns the variables without the ones named. */
+func DropVariables(vars []Variable, names []string) []Variable {
+	drop := make(map[string]bool)
+	for _, n := range names {
+		drop[n] = true
+	}
+	var kept []Variable
+	for _, v := range vars {
+		if !drop[v.Name] {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+/* Writes the character fields too wide for SPSS to a tab separated text file with one line per
+non-empty field, keyed by the serial or, without a serial, the record number. */
+func ExportLongText(asc string, out string, d *Variables) error {
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	serial := Serial(d)
+	key := "record"
+	if serial != nil {
+		key = serial.Name
+	}
+	_, err = w.WriteString(fmt.Sprintf("%s\tvariable\ttext\n", key))
+	if err != nil {
+		return err
+	}
+	err = ReadRecords(asc, func(n int, rec string) error {
+		id := fmt.Sprint(n)
+		if serial != nil {
+			id = strings.TrimSpace(Field(rec, serial.Position.Start, serial.Position.Finish))
+		}
+		for _, v := range d.Variable {
+			if v.Format() != "" || v.Width() <= MaxString {
+				continue
+			}
+			text := strings.TrimRight(Field(rec, v.Position.Start, v.Position.Finish), " ")
+			if text == "" {
+				continue
+			}
+			text = strings.NewReplacer("\t", " ", "\r", " ").Replace(text)
+			_, err := w.WriteString(fmt.Sprintf("%s\t%s\t%s\n", id, v.Name, text))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+/* Writes a copy of the data file keeping only the first record of every serial. Records with a blank
+serial are all kept. Returns the number of records dropped. */
+func DropDuplicates(asc string, out string, d *Variables) (int, error) {
+	serial := Serial(d)
+	if serial == nil {
+		return 0, fmt.Errorf("no variable is marked use=\"serial\", cannot drop duplicates")
+	}
+	file, err := Create(out)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	seen := make(map[string]bool)
+	dropped := 0
+	err = ReadRecords(asc, func(n int, rec string) error {
+		id := strings.TrimSpace(Field(rec, serial.Position.Start, serial.Position.Finish))
+		if id != "" && seen[id] {
+			dropped++
+			return nil
+		}
+		seen[id] = true
+		_, err := w.WriteString(rec + "\n")
+		return err
+	})
+	if err != nil {
+		return dropped, err
+	}
+	return dropped, w.Flush()
+}
+
+/* Writes the records of several data files, such as one per fieldwork batch, one after the other to out
+so they convert as a single data file. Returns the number of records of every part. */
+func StackData(parts []string, out string) ([]int, error) {
+	file, err := Create(out)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	counts := make([]int, len(parts))
+	for i, part := range parts {
+		err = ReadRecords(part, func(n int, rec string) error {
+			counts[i] = n
+			_, err := w.WriteString(rec + "\n")
+			return err
+		})
+		if err != nil {
+			return counts, err
+		}
+	}
+	return counts, w.Flush()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+
+/* Layouts of date and time fields as Triple-S declares them and as -iso-dates writes them, in Go notation */
+const (
+	SssDate = "20060102"
+	SssTime = "150405"
+	IsoDate = "2006-01-02"
+	IsoTime = "15:04:05"
+)
+
+/* Returns the Go layout of a date or time layout such as ddmmyyyy, dd.mm.yy or hhmm. Dates are made of
+dd, mm and yyyy or yy, times of hh, mm and ss; any other character stands for itself. */
+func ParseLayout(layout string, date bool) (string, error) {
+	parts := map[string]string{"dd": "02", "mm": "01", "yyyy": "2006", "yy": "06"}
+	if !date {
+		parts = map[string]string{"hh": "15", "mm": "04", "ss": "05"}
+	}
+	var b strings.Builder
+	s := strings.ToLower(layout)
+	for s != "" {
+		found := false
+		for _, p := range []string{"yyyy", "yy", "dd", "mm", "hh", "ss"} {
+			if g, ok := parts[p]; ok && strings.HasPrefix(s, p) {
+				b.WriteString(g)
+				s, found = s[len(p):], true
+				break
+			}
+		}
+		if !found {
+			if strings.ContainsAny(s[:1], "0123456789dmyhs") {
+				return "", fmt.Errorf("layout %s is not made of dd, mm and yyyy or hh, mm and ss", layout)
+			}
+			b.WriteByte(s[0])
+			s = s[1:]
+		}
+	}
+	return b.String(), nil
+}
+
+/* Returns the Go layout of every date and time variable. The spec is a comma separated list of a layout
+for all dates and NAME=layout pairs for single variables; variables it leaves out have the Triple-S layout. */
+func DateLayouts(spec string, d *Variables) (map[string]string, error) {
+	layouts := make(map[string]string)
+	types := make(map[string]string)
+	for _, v := range d.Variable {
+		switch v.Type {
+		case "date":
+			layouts[v.Name] = SssDate
+		case "time":
+			layouts[v.Name] = SssTime
+		default:
+			continue
+		}
+		types[v.Name] = v.Type
+	}
+	var named []string
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		} else if i := strings.Index(item, "="); i >= 0 {
+			named = append(named, item)
+			continue
+		}
+		layout, err := ParseLayout(item, true)
+		if err != nil {
+			return nil, err
+		}
+		for name, t := range types {
+			if t == "date" {
+				layouts[name] = layout
+			}
+		}
+	}
+	for _, item := range named {
+		i := strings.Index(item, "=")
+		name := strings.TrimSpace(item[:i])
+		t, ok := types[name]
+		if !ok {
+			return nil, fmt.Errorf("%s is no date or time variable", name)
+		}
+		layout, err := ParseLayout(strings.TrimSpace(item[i+1:]), t == "date")
+		if err != nil {
+			return nil, err
+		}
+		layouts[name] = layout
+	}
+	return layouts, nil
+}
+
+/* Writes a copy of the data file with the dates and times read in the layouts of DateLayouts and written
+in the Triple-S layouts YYYYMMDD and HHMMSS, or with iso as YYYY-MM-DD and HH:MM:SS. Fields too narrow
+for the new layout are widened and the variables behind them move along. Values that do not fit their
+layout are kept as they are. Returns the names of the widened fields and the number of values kept. */
+func NormalizeDates(asc string, out string, spec string, iso bool, d *Variables) ([]string, int, error) {
+	layouts, err := DateLayouts(spec, d)
+	if err != nil {
+		return nil, 0, err
+	}
+	var dates []*Variable
+	for i := range d.Variable {
+		if _, ok := layouts[d.Variable[i].Name]; ok {
+			dates = append(dates, &d.Variable[i])
+		}
+	}
+	sort.SliceStable(dates, func(i, j int) bool { return dates[i].Position.Start < dates[j].Position.Start })
+	targets := make([]string, len(dates))
+	widths := make([]int, len(dates))
+	for i, v := range dates {
+		switch {
+		case v.Type == "date" && iso:
+			targets[i] = IsoDate
+		case v.Type == "date":
+			targets[i] = SssDate
+		case iso:
+			targets[i] = IsoTime
+		default:
+			targets[i] = SssTime
+		}
+		widths[i] = v.Width()
+		if len(targets[i]) > widths[i] {
+			widths[i] = len(targets[i])
+		}
+	}
+
+	file, err := Create(out)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	kept := 0
+	err = ReadRecords(asc, func(n int, rec string) error {
+		var b strings.Builder
+		pos := 1
+		for i, v := range dates {
+			if v.Position.Start < pos {
+				continue
+			}
+			b.WriteString(Field(rec, pos, v.Position.Start-1))
+			text := strings.TrimSpace(Field(rec, v.Position.Start, v.Position.Finish))
+			if t, err := time.Parse(layouts[v.Name], text); err == nil {
+				text = t.Format(targets[i])
+			} else if text != "" {
+				kept++
+			}
+			if len(rec) >= v.Position.Start {
+				b.WriteString(text + strings.Repeat(" ", widths[i]-len(text)))
+			}
+			pos = v.Position.Finish + 1
+		}
+		if pos <= len(rec) {
+			b.WriteString(rec[pos-1:])
+		}
+		_, err := w.WriteString(b.String() + "\n")
+		return err
+	})
+	if err != nil {
+		return nil, kept, err
+	}
+	return WidenVariables(dates, widths, d), kept, w.Flush()
+}
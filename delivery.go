@@ -0,0 +1,198 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+
+/* The first bytes of a zip archive */
+var zipMagic = []byte("PK\x03\x04")
+
+/* Reports whether the file name is a zip archive. */
+func IsZip(name string) bool {
+	file, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	head := make([]byte, len(zipMagic))
+	_, err = io.ReadFull(file, head)
+	return err == nil && bytes.Equal(head, zipMagic)
+}
+
+/* Returns the arguments with a zip archive given as the Triple-S file replaced by the files of the
+delivery in it: the Triple-S file, found by its sss root element, and the data file when none is given
+and the Triple-S file names none in the archive, found by one of dataExtensions, also gzip compressed,
+or by having the name of the Triple-S file when there are several. The archive is unpacked in to the
+temporary folder of the run. */
+func UnpackDelivery(args []string) ([]string, error) {
+	if len(args) == 0 || !IsZip(args[0]) {
+		return args, nil
+	}
+	archive := args[0]
+	members, err := unzip(archive)
+	if err != nil {
+		return nil, err
+	}
+	var metadata, data []string
+	for _, m := range members {
+		ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(strings.ToLower(m), ".gz")))
+		if (ext == ".xml" || ext == ".sss") && sssRoot(m) {
+			metadata = append(metadata, m)
+		} else if contains(dataExtensions, ext) {
+			data = append(data, m)
+		}
+	}
+	if len(metadata) == 0 {
+		return nil, fmt.Errorf("no Triple-S file in the delivery %s", archive)
+	} else if len(metadata) > 1 {
+		return nil, fmt.Errorf("the delivery %s holds %d Triple-S files, %s, give one at a time", archive,
+			len(metadata), strings.Join(deliveryNames(metadata), ", "))
+	}
+	unpacked := append([]string{metadata[0]}, args[1:]...)
+	if len(args) > 1 {
+		return unpacked, nil
+	}
+	if href := deliveryHref(metadata[0]); href != "" {
+		if asc, err := DataPath(metadata[0], href); err == nil && fileExists(asc) {
+			slog.Info(fmt.Sprintf("Reading %s from the delivery %s", deliveryNames(metadata)[0], archive))
+			return unpacked, nil
+		}
+	}
+	if len(data) > 1 && DataFileFor(metadata[0]) != "" {
+		data = []string{DataFileFor(metadata[0])}
+	}
+	if len(data) > 1 {
+		return nil, fmt.Errorf("the delivery %s holds %d data files, %s, and the Triple-S file names none of them",
+			archive, len(data), strings.Join(deliveryNames(data), ", "))
+	} else if len(data) == 1 {
+		unpacked = append(unpacked, data[0])
+	}
+	slog.Info(fmt.Sprintf("Reading %s from the delivery %s", strings.Join(deliveryNames(unpacked), " and "), archive))
+	return unpacked, nil
+}
+
+/* Unpacks the zip archive in to a folder of its own in the temporary folder of the run, keeping the
+folders of its members, and returns the paths of the files. Folders of macOS resource forks are left out. */
+func unzip(archive string) ([]string, error) {
+	z, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", archive, err)
+	}
+	defer z.Close()
+	tmp, err := tempFolder()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(tmp, "zip")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, f := range z.File {
+		name := filepath.FromSlash(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(filepath.Base(name), "._") {
+			continue
+		}
+		if !filepath.IsLocal(name) {
+			return nil, fmt.Errorf("the member %s of %s is outside the archive", f.Name, archive)
+		}
+		out := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(out), 0755)
+		if err != nil {
+			return nil, err
+		}
+		err = unzipFile(f, out)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", archive, err)
+		}
+		files = append(files, out)
+	}
+	deliveryRoots[dir] = true
+	return files, nil
+}
+
+func unzipFile(f *zip.File, out string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+/* Folders deliveries were unpacked to, for naming their members as they are in the archive */
+var deliveryRoots = make(map[string]bool)
+
+/* Returns the names of the unpacked files as they are in their archive. */
+func deliveryNames(files []string) []string {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f
+		for root := range deliveryRoots {
+			if rel, err := filepath.Rel(root, f); err == nil && filepath.IsLocal(rel) {
+				names[i] = filepath.ToSlash(rel)
+			}
+		}
+	}
+	return names
+}
+
+/* Reports whether the XML file name has sss as its root element. */
+func sssRoot(name string) bool {
+	file, err := OpenInput(name)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	dec := xml.NewDecoder(file)
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return false
+		}
+		if e, ok := t.(xml.StartElement); ok {
+			return e.Name.Local == "sss"
+		}
+	}
+}
+
+/* Returns the href of the record of the Triple-S file name, or "" when it has none. */
+func deliveryHref(name string) string {
+	file, err := OpenInput(name)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	dec := xml.NewDecoder(file)
+	for {
+		t, err := dec.RawToken()
+		if err != nil {
+			return ""
+		}
+		if e, ok := t.(xml.StartElement); ok && e.Name.Local == "record" {
+			return attr(e, "href")
+		} else if ok && e.Name.Local == "variable" {
+			return ""
+		}
+	}
+}
+
+func fileExists(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.Mode().IsRegular()
+}
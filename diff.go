@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+
+/* Returns the positions of a variable as start-finish. */
+func positions(v Variable) string {
+	return fmt.Sprintf("%d-%d", v.Position.Start, v.Position.Finish)
+}
+
+/* Returns the differences between the value lists of two variables. */
+func diffValues(a Variable, b Variable) []string {
+	var changes []string
+	old := make(map[int]string)
+	for _, val := range a.Vals {
+		old[val.Value] = val.Name
+	}
+	kept := make(map[int]bool)
+	for _, val := range b.Vals {
+		label, ok := old[val.Value]
+		kept[val.Value] = true
+		if !ok {
+			changes = append(changes, fmt.Sprintf("added %d %q", val.Value, val.Name))
+		} else if label != val.Name {
+			changes = append(changes, fmt.Sprintf("changed %d %q to %q", val.Value, label, val.Name))
+		}
+	}
+	for _, val := range a.Vals {
+		if !kept[val.Value] {
+			changes = append(changes, fmt.Sprintf("removed %d %q", val.Value, val.Name))
+		}
+	}
+	return changes
+}
+
+/* Returns the differences between the variables of two Triple-S files, one per line: variables
+added and removed, renamed ones found by their ident or otherwise by an unchanged type and label, and
+of the variables in both any change of type, positions, spread, label or value list. */
+func Diff(a *Variables, b *Variables) []string {
+	var diffs []string
+	byName := make(map[string]int)
+	for i, v := range a.Variable {
+		byName[v.Name] = i
+	}
+	matched := make(map[int]int)	// variables of b to those of a
+	used := make(map[int]bool)
+	for j, v := range b.Variable {
+		if i, ok := byName[v.Name]; ok {
+			matched[j], used[i] = i, true
+		}
+	}
+	renamed := func(same func(x Variable, y Variable) bool) {
+		for j, v := range b.Variable {
+			if _, ok := matched[j]; ok {
+				continue
+			}
+			for i, w := range a.Variable {
+				if !used[i] && same(w, v) {
+					matched[j], used[i] = i, true
+					break
+				}
+			}
+		}
+	}
+	renamed(func(x Variable, y Variable) bool { return x.Ident != "" && x.Ident == y.Ident })
+	renamed(func(x Variable, y Variable) bool { return x.Type == y.Type && x.Label != "" && x.Label == y.Label })
+
+	for i, v := range a.Variable {
+		if !used[i] {
+			diffs = append(diffs, fmt.Sprintf("removed   %s %s %s", v.Name, v.Type, positions(v)))
+		}
+	}
+	for j, v := range b.Variable {
+		i, ok := matched[j]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("added     %s %s %s", v.Name, v.Type, positions(v)))
+			continue
+		}
+		w := a.Variable[i]
+		if w.Name != v.Name {
+			diffs = append(diffs, fmt.Sprintf("renamed   %s to %s", w.Name, v.Name))
+		}
+		if w.Type != v.Type {
+			diffs = append(diffs, fmt.Sprintf("type      %s %s to %s", v.Name, w.Type, v.Type))
+		}
+		if positions(w) != positions(v) {
+			diffs = append(diffs, fmt.Sprintf("position  %s %s to %s", v.Name, positions(w), positions(v)))
+		}
+		if w.Spread.Subfields != v.Spread.Subfields || w.Spread.Width != v.Spread.Width {
+			diffs = append(diffs, fmt.Sprintf("spread    %s %dx%d to %dx%d", v.Name, w.Spread.Subfields, w.Spread.Width, v.Spread.Subfields, v.Spread.Width))
+		}
+		if w.Label != v.Label {
+			diffs = append(diffs, fmt.Sprintf("label     %s %q to %q", v.Name, w.Label, v.Label))
+		}
+		if changes := diffValues(w, v); len(changes) > 0 {
+			diffs = append(diffs, fmt.Sprintf("values    %s %s", v.Name, strings.Join(changes, "; ")))
+		}
+	}
+	return diffs
+}
+
+/* Writes the differences between two Triple-S files. */
+func WriteDiff(out io.Writer, a *Variables, b *Variables) (int, error) {
+	diffs := Diff(a, b)
+	for _, d := range diffs {
+		_, err := fmt.Fprintln(out, d)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(diffs), nil
+}
+
+/* Runs the diff command printing how the second Triple-S file differs from the first. Like diff it
+fails when there are differences, so a tracker can refuse to append a wave that changed. */
+func DiffCommand(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	paths := parseArgs(fs, args)
+	if len(paths) != 2 {
+		return UsageError("Usage: XMLtoSPS diff <XML:filepath> <XML:filepath>")
+	}
+	a, err := ReadMetadata(paths[0])
+	if err != nil {
+		return err
+	}
+	b, err := ReadMetadata(paths[1])
+	if err != nil {
+		return err
+	}
+	n, err := WriteDiff(os.Stdout, a, b)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%d differences between %s and %s", n, paths[0], paths[1])
+	}
+	return nil
+}
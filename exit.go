@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+)
+
+
+/* Exit codes of the classes of failure, so wrapper scripts can branch on them */
+const (
+	ExitFailure	= 1	// any other failure
+	ExitUsage	= 2	// the arguments or options are wrong
+	ExitParse	= 3	// the Triple-S file is not well-formed XML or breaks the standard
+	ExitIO		= 4	// a file could not be read or written
+	ExitInvalid	= 5	// the data does not fit the metadata
+	ExitWarnings	= 6	// the conversion logged warnings, with -exit-warnings or -fail-on-warning
+)
+
+/* An error in the arguments or options of the run */
+type UsageError string
+
+func (e UsageError) Error() string {
+	return string(e)
+}
+
+/* A warning ending the run with -fail-on-warning */
+type WarningError string
+
+func (e WarningError) Error() string {
+	return "-fail-on-warning: " + string(e)
+}
+
+/* An error reading the XML of a Triple-S file, at Line and Column in the variable named Variable when
+they are known */
+type ParseError struct {
+	File		string
+	Line		int
+	Column		int
+	Variable	string
+	Err		error
+}
+
+func (e *ParseError) Error() string {
+	msg := e.Err.Error()
+	var syntax *xml.SyntaxError
+	if errors.As(e.Err, &syntax) {
+		msg = "XML syntax error: " + syntax.Msg
+	} // The line is given in front
+	where := e.File
+	if e.Line > 0 {
+		where += fmt.Sprintf(":%d:%d", e.Line, e.Column)
+	}
+	if e.Variable != "" {
+		where += " in variable " + e.Variable
+	}
+	return where + ": " + msg
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+/* Returned by the validate command when the data does not fit the metadata */
+var ErrInvalid = errors.New("the data does not fit the metadata")
+
+/* Returns the exit code of the class of err. */
+func ExitCode(err error) int {
+	var usage UsageError
+	var warning WarningError
+	var parse *ParseError
+	var path *fs.PathError
+	var syntax *xml.SyntaxError
+	switch {
+	case errors.As(err, &usage):
+		return ExitUsage
+	case errors.As(err, &warning):
+		return ExitWarnings
+	case errors.As(err, &parse), errors.As(err, &syntax), errors.Is(err, ErrSchema):
+		return ExitParse
+	case errors.As(err, &path):
+		return ExitIO
+	case errors.Is(err, ErrInvalid):
+		return ExitInvalid
+	}
+	return ExitFailure
+}
+
+/* Run by Exit before the program ends */
+var atExit []func(err error)
+
+/* Logs err and ends the program with the exit code of its class. */
+func Exit(err error) {
+	slog.Error(err.Error())
+	for _, fn := range atExit {
+		fn(err)
+	}
+	os.Exit(ExitCode(err))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+
+/* The first bytes of every gzip file, by which compressed inputs are found whatever their name */
+var gzipMagic = []byte{0x1f, 0x8b}
+
+/* Decompressed copies of the gzip data files read in the run, by the path of the compressed file */
+var gunzipped = struct {
+	sync.Mutex
+	copies	map[string]string
+}{copies: make(map[string]string)}
+
+/* Reports whether the file name starts as gzip files do. */
+func IsGzip(name string) bool {
+	file, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	head := make([]byte, len(gzipMagic))
+	_, err = io.ReadFull(file, head)
+	return err == nil && bytes.Equal(head, gzipMagic)
+}
+
+/* Returns the open Triple-S file, or a pipe reading it decompressed when it is gzip compressed. */
+func gunzipInput(file *os.File) (*os.File, error) {
+	head := make([]byte, len(gzipMagic))
+	n, _ := file.ReadAt(head, 0)
+	if n < len(head) || !bytes.Equal(head, gzipMagic) {
+		return file, nil
+	}
+	return gunzipPipe(file)
+}
+
+/* Returns a pipe the gzip stream read from file is written to decompressed. The file is closed once
+it is read to its end or the pipe is closed. */
+func gunzipPipe(file *os.File) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	go func() {
+		defer file.Close()
+		defer w.Close()
+		z, err := gzip.NewReader(bufio.NewReader(file))
+		if err == nil {
+			_, err = io.Copy(w, z)
+		}
+		if err != nil && !errors.Is(err, syscall.EPIPE) {
+			slog.Error(fmt.Sprintf("Decompressing %s: %v", file.Name(), err))
+		}
+	}()
+	return r, nil
+}
+
+/* Decompresses the gzip file src in to the file dst, made with create. */
+func gunzip(src string, dst string, create func(string) (*os.File, error)) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r, p := ProgressReader(src, in)
+	defer p.Finish()
+	z, err := gzip.NewReader(bufio.NewReaderSize(r, 1<<16))
+	if err != nil {
+		return &fs.PathError{Op: "gunzip", Path: src, Err: err}
+	}
+	out, err := create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriterSize(out, 1<<16)
+	n, err := io.Copy(w, z)
+	if err != nil {
+		return &fs.PathError{Op: "gunzip", Path: src, Err: err}
+	}
+	slog.Debug(fmt.Sprintf("Decompressed %s to %s of %s", src, Size(n), dst))
+	return w.Flush()
+}
+
+/* Returns the data file name, or a decompressed copy of it in the temporary folder of the run when it
+is gzip compressed. The copy is made once and read by every output. */
+func Gunzipped(name string) (string, error) {
+	if !IsGzip(name) {
+		return name, nil
+	}
+	gunzipped.Lock()
+	defer gunzipped.Unlock()
+	if plain, ok := gunzipped.copies[name]; ok {
+		return plain, nil
+	}
+	dir, err := tempFolder()
+	if err != nil {
+		return "", err
+	}
+	sub, err := os.MkdirTemp(dir, "gunzip")
+	if err != nil {
+		return "", err
+	}
+	plain := filepath.Join(sub, gunzipName(name))
+	err = gunzip(name, plain, os.Create)
+	if err != nil {
+		return "", err
+	}
+	gunzipped.copies[name] = plain
+	return plain, nil
+}
+
+/* Returns the name of the decompressed file, the name without .gz or with .asc added to it. */
+func gunzipName(name string) string {
+	base := filepath.Base(name)
+	if ext := filepath.Ext(base); ext == ".gz" || ext == ".GZ" {
+		return base[:len(base)-len(ext)]
+	}
+	return base + ".asc"
+}
+
+/* Opens the data file name like os.Open, decompressing it first when it is gzip compressed. */
+func OpenData(name string) (*os.File, error) {
+	name, err := Gunzipped(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+/* Returns the data files with every gzip compressed one decompressed, and every one unpacked or
+downloaded moved out of the temporary folder of the run, in to the output folder dir as fn.asc, or fn_1.asc
+and so on for several, for the syntax to read. A file there is only replaced with -force, as it may be
+a delivered one. */
+func SyntaxData(parts []string, dir string, fn string) ([]string, error) {
+	plain := make([]string, len(parts))
+	for i, p := range parts {
+		plain[i] = p
+		gz := IsGzip(p)
+		if !gz && !inTempFolder(p) {
+			continue
+		}
+		out := fmt.Sprintf("%s/%s.asc", dir, fn)
+		if len(parts) > 1 {
+			out = fmt.Sprintf("%s/%s_%d.asc", dir, fn, i+1)
+		}
+		if same(p, out) {
+			return nil, fmt.Errorf("%s is gzip compressed and would be overwritten by its decompressed copy, give -o another folder", p)
+		} else if _, err := os.Stat(out); err == nil && !*force {
+			return nil, &fs.PathError{Op: "create", Path: out, Err: ErrExists}
+		}
+		var err error
+		if gz {
+			err = gunzip(p, out, Create)
+		} else {
+			err = moveFile(p, out)
+		}
+		if err != nil {
+			return nil, err
+		}
+		slog.Info(fmt.Sprintf("Wrote the data of %s to %s for the syntax to read", deliveryNames([]string{p})[0], out))
+		plain[i] = out
+	}
+	return plain, nil
+}
+
+/* Moves the file src to dst, copying it when they are on different devices, and keeps dst as an output. */
+func moveFile(src string, dst string) error {
+	if os.Rename(src, dst) == nil {
+		outputs = append(outputs, dst)
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+/* Reports whether the file name is in the temporary folder of the run. */
+func inTempFolder(name string) bool {
+	if tempDir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(tempDir, name)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+/* Reports whether the paths a and b name the same existing file. */
+func same(a string, b string) bool {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	ib, err := os.Stat(b)
+	return err == nil && os.SameFile(ia, ib)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+
+/* Writes a summary of the metadata: the survey, the number of variables of every type and the length
+of the records, followed by a table of the variables with their positions, number of categories and label
+and a line of totals. */
+func Inspect(out io.Writer, d *Variables) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	counts := make(map[string]int)
+	length, categories := 0, 0
+	for _, v := range d.Variable {
+		counts[v.Type]++
+		categories += len(v.Vals)
+		if v.Position.Finish > length {
+			length = v.Position.Finish
+		}
+	}
+	fmt.Fprintf(w, "survey\t%s\nversion\t%s\ntitle\t%s\ntriple-s\t%s\n", d.Name, d.Version, d.Title, d.SssVersion)
+	fmt.Fprintf(w, "variables\t%d\nrecord length\t%d\n", len(d.Variable), length)
+	for _, t := range []string{"single", "multiple", "quantity", "character", "logical", "date", "time"} {
+		if counts[t] > 0 {
+			fmt.Fprintf(w, "%s\t%d\n", t, counts[t])
+		}
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	w = tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "\nname\ttype\tpositions\tcategories\tlabel\n")
+	for _, v := range d.Variable {
+		positions := fmt.Sprintf("%d-%d", v.Position.Start, v.Position.Finish)
+		if v.Spread.Subfields > 0 {
+			positions += fmt.Sprintf(" (%dx%d)", v.Spread.Subfields, v.Spread.Width)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", v.Name, v.Type, positions, len(v.Vals), ShortLabel(v.Label, 60))
+	}
+	fmt.Fprintf(w, "total\t%d variables\t1-%d\t%d\t\n", len(d.Variable), length, categories)
+	return w.Flush()
+}
+
+/* Returns the label on one line, cut to n characters with an ellipsis when it is longer. */
+func ShortLabel(label string, n int) string {
+	r := []rune(strings.Join(strings.Fields(label), " "))
+	if len(r) > n {
+		return string(r[:n-3]) + "..."
+	}
+	return string(r)
+}
+
+/* Runs the inspect command printing the summary of the metadata. */
+func InspectCommand(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	paths := parseArgs(fs, args)
+	if len(paths) < 1 {
+		return UsageError("Usage: XMLtoSPS inspect <XML:filepath>")
+	}
+	d, err := ReadMetadata(paths[0])
+	if err != nil {
+		return err
+	}
+	return Inspect(os.Stdout, d)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+
+/* Returns warnings about the labels missing in d: variables without a label, categories without label
+text and multiples without categories, which have no variables in the syntax at all. With placeholders
+a variable without a label is labelled with its name and a category with its code. */
+func MissingLabels(d *Variables, placeholders bool) []string {
+	var unlabelled, categories, empty []string
+	for i := range d.Variable {
+		v := &d.Variable[i]
+		if strings.TrimSpace(v.Label) == "" {
+			unlabelled = append(unlabelled, v.Name)
+			if placeholders {
+				v.Label = v.Name
+			}
+		}
+		for j := range v.Vals {
+			if strings.TrimSpace(v.Vals[j].Name) == "" {
+				categories = append(categories, fmt.Sprintf("%s code %d", v.Name, v.Vals[j].Value))
+				if placeholders {
+					v.Vals[j].Name = strconv.Itoa(v.Vals[j].Value)
+				}
+			}
+		}
+		if v.Type == "multiple" && len(v.Vals) == 0 {
+			empty = append(empty, v.Name)
+		}
+	}
+	var warnings []string
+	filled := ""
+	if placeholders {
+		filled = ", labelled with the name"
+	}
+	if len(unlabelled) > 0 {
+		warnings = append(warnings, fmt.Sprintf("Variables without a label%s: %s", filled, strings.Join(unlabelled, ", ")))
+	}
+	if placeholders {
+		filled = ", labelled with the code"
+	}
+	if len(categories) > 0 {
+		warnings = append(warnings, fmt.Sprintf("Categories without a label%s: %s", filled, strings.Join(categories, ", ")))
+	}
+	if len(empty) > 0 {
+		warnings = append(warnings, fmt.Sprintf("Multiples without categories: %s", strings.Join(empty, ", ")))
+	}
+	return warnings
+}
+
+/* Returns the variables whose values give a code more than once, of which SPSS keeps only the last
+label, and with sameLabels also those giving different codes the same label. */
+func DuplicateCodes(d *Variables, sameLabels bool) []string {
+	var duplicates []string
+	for _, v := range d.Variable {
+		codes := make(map[int]string)
+		labels := make(map[string]int)
+		for _, val := range v.Vals {
+			if label, ok := codes[val.Value]; ok {
+				duplicates = append(duplicates, fmt.Sprintf("%s: code %d is given twice, as %q and %q", v.Name, val.Value, label, val.Name))
+			} else {
+				codes[val.Value] = val.Name
+			}
+			key := strings.ToLower(strings.TrimSpace(val.Name))
+			if code, ok := labels[key]; sameLabels && ok && code != val.Value && key != "" {
+				duplicates = append(duplicates, fmt.Sprintf("%s: codes %d and %d are both labelled %q", v.Name, code, val.Value, val.Name))
+			} else if !ok {
+				labels[key] = val.Value
+			}
+		}
+	}
+	return duplicates
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"strings"
+)
+
+
+/* Columns of the LimeSurvey tab separated survey structure the writer fills in */
+var limeHeader = []string{"class", "type/scale", "name", "relevance", "text", "help", "language", "validation",
+	"mandatory", "other", "default", "same_default"}
+
+/* Language of the written survey */
+const limeLanguage = "en"
+
+/* LimeSurvey question types of the Triple-S types */
+var limeTypes = map[string]string{"single": "L", "multiple": "M", "logical": "Y", "quantity": "N",
+	"character": "S", "date": "D", "time": "S"}
+
+/* Returns name as a LimeSurvey question code, letters and digits starting with a letter. */
+func limeCode(name string) string {
+	code := strings.Replace(Identifier(name, len(name)+1), "_", "", -1)
+	if code == "" || code[0] >= '0' && code[0] <= '9' {
+		code = "Q" + code
+	}
+	return code
+}
+
+/* Returns s on one line without the tabs that separate the columns. */
+func limeText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+/* Writes a row of the structure, leaving the columns that are not given empty. */
+func limeRow(w *bufio.Writer, class string, kind string, name string, text string) {
+	row := make([]string, len(limeHeader))
+	row[0], row[1], row[2], row[4], row[6] = class, kind, name, limeText(text), limeLanguage
+	if class == "G" || class == "Q" {
+		row[3] = "1"
+	}
+	if class == "Q" {
+		row[8] = "N"
+	}
+	w.WriteString(strings.Join(row, "\t") + "\n")
+}
+
+/* Writes the Triple-S metadata as a LimeSurvey tab separated survey structure, which LimeSurvey imports
+as a new survey with a question for every variable in a single group. Singles become list questions with
+their codes as answers, multiples multiple choice questions with a subquestion per code. */
+func WriteLimeSurvey(out string, d *Variables) error {
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	title := d.Title
+	if title == "" {
+		title = d.Name
+	}
+	w.WriteString(strings.Join(limeHeader, "\t") + "\n")
+	limeRow(w, "S", "", "language", limeLanguage)
+	limeRow(w, "SL", "", "surveyls_title", title)
+	limeRow(w, "G", "", title, "")
+	codes := make([]string, len(d.Variable))
+	for i, v := range d.Variable {
+		codes[i] = limeCode(v.Name)
+	}
+	codes = uniqueNames(codes, math.MaxInt32)
+	for i, v := range d.Variable {
+		kind, ok := limeTypes[v.Type]
+		if !ok {
+			kind = "S"
+		}
+		if v.Type == "character" && v.Width() > 255 {
+			kind = "T"
+		}
+		limeRow(w, "Q", kind, codes[i], v.Label)
+		for _, val := range v.Vals {
+			if v.Type == "multiple" {
+				limeRow(w, "SQ", "0", fmt.Sprintf("SQ%d", val.Value), val.Name)
+			} else if v.Type == "single" {
+				limeRow(w, "A", "0", fmt.Sprint(val.Value), val.Name)
+			}
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+
+/* Longest physical line SPSS accepts in a syntax file */
+const MaxLine = 251
+
+
+/* Breaks the syntax written through it in to physical lines of at most max bytes.
+Lines are broken at white space outside string literals. A literal that does not fit on a line
+by itself is split in to two literals joined with +, which SPSS concatenates again. */
+type LineWriter struct {
+	w	io.Writer
+	max	int
+	line	[]byte
+}
+
+func NewLineWriter(w io.Writer, max int) *LineWriter {
+	return &LineWriter{w: w, max: max}
+}
+
+func (l *LineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			l.line = append(l.line, p...)
+			return n, nil
+		}
+		l.line = append(l.line, p[:i]...)
+		err := l.wrap(true)
+		if err != nil {
+			return 0, err
+		}
+		p = p[i+1:]
+	}
+}
+
+func (l *LineWriter) WriteString(s string) (int, error) {
+	n := len(s)
+	for {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			l.line = append(l.line, s...)
+			return n, nil
+		}
+		l.line = append(l.line, s[:i]...)
+		err := l.wrap(true)
+		if err != nil {
+			return 0, err
+		}
+		s = s[i+1:]
+	}
+}
+
+/* Writes out what is left of the last line when it was not ended with a newline. */
+func (l *LineWriter) Flush() error {
+	if len(l.line) == 0 {
+		return nil
+	}
+	return l.wrap(false)
+}
+
+/* Writes the buffered line, breaking it as often as needed to stay within the limit. */
+func (l *LineWriter) wrap(newline bool) error {
+	line := l.line
+	for len(line) > l.max {
+		space, cut, quote := breakPoints(line, l.max)
+		var head, rest []byte
+		if space > 0 {
+			head = bytes.TrimRight(line[:space], " \t")
+			rest = append([]byte{'\t'}, bytes.TrimLeft(line[space:], " \t")...)
+		} else if cut > 0 {
+			head = append(line[:cut:cut], quote, ' ', '+')
+			rest = append([]byte{'\t', quote}, line[cut:]...)
+		} else {
+			break
+		}
+		if len(head) > 0 {
+			_, err := l.w.Write(append(head, '\n'))
+			if err != nil {
+				return err
+			}
+		}
+		line = rest
+	}
+	if newline {
+		line = append(line, '\n')
+	}
+	_, err := l.w.Write(line)
+	l.line = l.line[:0]
+	return err
+}
+
+/* Finds the last white space outside a string literal and the last position a literal can be split at
+so that the first part fits in max bytes. Splits never separate a doubled quote or a UTF-8 sequence. */
+func breakPoints(line []byte, max int) (space int, cut int, quote byte) {
+	var q byte
+	open := 0
+	for i := 0; i < len(line) && i <= max; i++ {
+		c := line[i]
+		if q == 0 {
+			if (c == ' ' || c == '\t') && i > 0 {
+				space = i
+			} else if c == '"' || c == '\'' {
+				q, open = c, i
+			}
+			continue
+		}
+		if c == q {
+			if i+1 < len(line) && line[i+1] == q {
+				i++
+				continue
+			}
+			q = 0
+			continue
+		}
+		if i > open+1 && i+3 <= max && utf8.RuneStart(c) {
+			cut, quote = i, q
+		}
+	}
+	return space, cut, quote
+}
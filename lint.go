@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+
+/* Checks SPS syntax for what this tool takes care of when it writes syntax, so hand-edited syntax can
+be checked before it is run: lines longer than MaxLine bytes, string literals not closed on their line,
+variable and value labels longer than the dialect allows, counting literals joined with +, and commands
+without a period at their end before the next command starts in the first column. Returns the problems
+with their line numbers. */
+func Lint(r io.Reader, dl Dialect) ([]string, error) {
+	type problem struct {
+		line	int
+		message	string
+	}
+	var found []problem
+	report := func(n int, format string, a ...interface{}) {
+		found = append(found, problem{n, fmt.Sprintf(format, a...)})
+	}
+	open, comment := false, false
+	command, start, limit := "", 0, 0
+	label, labelLine, joining := -1, 0, false	// length of the label being read, -1 outside one
+	endLabel := func() {
+		if label > limit && limit > 0 {
+			report(labelLine, "%s label of %d bytes, longer than %d", strings.ToLower(command), label, limit)
+		}
+		label, joining = -1, false
+	}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), "\r")
+		if len(line) > MaxLine {
+			report(n, "%d bytes, longer than the %d SPSS reads", len(line), MaxLine)
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		first := rune(line[0])
+		if open && (unicode.IsLetter(first) || first == '*') {
+			report(start, "%s has no period at its end before line %d", command, n)
+			endLabel()
+			open = false
+		} // A command starts in the first column
+		if !open {
+			words := strings.Fields(strings.ToUpper(trimmed))
+			command, start, open = words[0], n, true
+			comment = command == "COMMENT" || strings.HasPrefix(command, "*")
+			for _, w := range words[1:] {
+				if w == "LABELS" || command == "VARIABLE" || command == "ADD" && w == "VALUE" {
+					command += " " + w
+				}
+				if w == "LABELS" {
+					break
+				}
+			}
+			command = strings.TrimRight(command, ".")
+			switch command {
+			case "VARIABLE LABELS":
+				limit = dl.MaxVariableLabel
+			case "VALUE LABELS", "ADD VALUE LABELS":
+				limit = dl.MaxValueLabel
+			default:
+				limit = 0
+			}
+		}
+		if comment {
+			if strings.HasSuffix(trimmed, ".") {
+				open = false
+			}
+			continue
+		} // Comments may hold quotes of any kind
+
+		last := ' '
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case c == '"' || c == '\'':
+				j, length := i+1, 0
+				for ; j < len(line); j++ {
+					if line[j] == c && j+1 < len(line) && line[j+1] == c {
+						j++
+					} else if line[j] == c {
+						break
+					}
+					length++
+				}
+				if j >= len(line) {
+					report(n, "string from column %d is not closed on its line", i+1)
+					i = len(line)
+					continue
+				}
+				if joining {
+					label += length
+					joining = false
+				} else {
+					endLabel()
+					label, labelLine = length, n
+				}
+				i, last = j, rune(c)
+			case c == '+' && label >= 0:
+				joining, last = true, '+'
+			case c == ' ' || c == '\t':
+			default:
+				endLabel()
+				last = rune(c)
+			}
+		}
+		if last == '.' {
+			endLabel()
+			open = false
+		}
+	}
+	if open && !comment {
+		report(start, "%s has no period at its end", command)
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].line < found[j].line
+	})
+	problems := make([]string, len(found))
+	for i, p := range found {
+		problems[i] = fmt.Sprintf("line %d: %s", p.line, p.message)
+	}
+	return problems, s.Err()
+}
+
+/* Runs the lint command checking SPS files. */
+func LintCommand(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.StringVar(dialect, "dialect", "spss", "check the label limits of spss or pspp")
+	paths := parseArgs(fs, args)
+	if len(paths) < 1 {
+		return UsageError("Usage: XMLtoSPS lint [-dialect spss|pspp] <SPS:filepath>...")
+	}
+	dl, ok := Dialects[*dialect]
+	if !ok {
+		return UsageError(fmt.Sprintf("Unknown dialect %s, use spss or pspp", *dialect))
+	}
+	count := 0
+	for _, p := range paths {
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		problems, err := Lint(file, dl)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", p, problem)
+		}
+		count += len(problems)
+	}
+	if count > 0 {
+		return fmt.Errorf("%d problems found", count)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"strings"
+)
+
+
+/* Sets up the messages of the run: -quiet leaves only warnings and errors, -verbose adds the files read
+and written, and -log-format json writes every message as a JSON object for a scheduler to ingest.
+The text format keeps the lines of the standard logger. With a logFile every message of any level is
+also added to the end of that file. */
+func SetupLogging(verbose bool, quiet bool, format string, logFile string) error {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	} else if quiet {
+		level = slog.LevelWarn
+	}
+	if format != "text" && format != "json" {
+		return UsageError(fmt.Sprintf("Unknown -log-format %s, use text or json", format))
+	}
+	if logFile == "" {
+		if format == "text" {
+			slog.SetLogLoggerLevel(level)
+		} else {
+			slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+		}
+		return nil
+	}
+
+	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	} // Left open until the program ends
+	logCopy = file
+	var console, all slog.Handler
+	if format == "text" {
+		console, all = &lineHandler{w: os.Stderr, level: level}, &lineHandler{w: file, level: slog.LevelDebug}
+	} else {
+		console = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+		all = slog.NewJSONHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug})
+	}
+	slog.SetDefault(slog.New(teeHandler{console, all}))
+	slog.Debug("Running " + strings.Join(os.Args, " "))
+	return nil
+}
+
+/* The log file of the run, where -glob and watch add the messages of the conversion of each survey */
+var logCopy io.Writer
+
+/* Returns where the messages of the conversion of a survey go: standard error and the log file. */
+func conversionLog() io.Writer {
+	if logCopy == nil {
+		return os.Stderr
+	}
+	return io.MultiWriter(os.Stderr, logCopy)
+}
+
+/* Writes messages as the standard logger does, the time followed by the level and the message, with
+the attributes after it. */
+type lineHandler struct {
+	w		io.Writer
+	level		slog.Level
+	attrs		[]slog.Attr
+}
+
+func (h *lineHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *lineHandler) Handle(_ context.Context, r slog.Record) error {
+	line := r.Time.Format("2006/01/02 15:04:05") + " " + r.Level.String() + " " + r.Message
+	add := func(a slog.Attr) bool {
+		line += " " + a.String()
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+	_, err := io.WriteString(h.w, line+"\n")
+	return err
+}
+
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &lineHandler{h.w, h.level, append(append([]slog.Attr(nil), h.attrs...), attrs...)}
+}
+
+func (h *lineHandler) WithGroup(name string) slog.Handler {
+	return h
+}
+
+/* Passes every message to each of its handlers that takes its level */
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			err := h.Handle(ctx, r.Clone())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	hs := make(teeHandler, len(t))
+	for i, h := range t {
+		hs[i] = h.WithAttrs(attrs)
+	}
+	return hs
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	hs := make(teeHandler, len(t))
+	for i, h := range t {
+		hs[i] = h.WithGroup(name)
+	}
+	return hs
+}
+
+/* The warnings logged in the run */
+var Warnings []string
+
+/* Guards Warnings against the surveys -jobs reads at the same time */
+var warnings sync.Mutex
+
+/* Set by -glob and watch, which read the Triple-S files only to find their data files; the warnings
+about a survey are those of the run converting it, which alone fails on them */
+var muteWarnings bool
+
+/* Logs a warning and keeps it for -exit-warnings and the summary. With -fail-on-warning the run ends
+with the warning as its error instead. */
+func Warn(msg string) {
+	if muteWarnings {
+		slog.Debug(msg)
+		return
+	}
+	warnings.Lock()
+	Warnings = append(Warnings, msg)
+	warnings.Unlock()
+	if *failOnWarning {
+		Exit(WarningError(msg))
+	}
+	slog.Warn(msg)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+
+/* Files created by this run in the order they were created, listed by -manifest */
+var outputs []string
+
+/* Outputs someone may have edited or saved by hand, which are only overwritten with -force */
+var protected = []string{".sps", ".sav"}
+
+/* Returned for a protected output that exists */
+var ErrExists = errors.New("file exists, give -force to overwrite it")
+
+/* Returns an error when name is a protected output that exists and -force is not given. */
+func CheckOverwrite(name string) error {
+	if *force || !contains(protected, strings.ToLower(path.Ext(name))) {
+		return nil
+	}
+	if _, err := os.Stat(name); err == nil {
+		return &fs.PathError{Op: "create", Path: name, Err: ErrExists}
+	}
+	return nil
+}
+
+/* Creates the file like os.Create and remembers it as an output of the run. */
+func Create(name string) (*os.File, error) {
+	err := CheckOverwrite(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Create(name)
+	if err == nil {
+		outputs = append(outputs, name)
+		slog.Debug("Writing " + name)
+	}
+	return file, err
+}
+
+/* Structures of the JSON manifest */
+type Manifest struct {
+	Created		string			`json:"created,omitempty"`
+	Metadata	ManifestFile		`json:"metadata"`
+	Data		[]ManifestFile		`json:"data"`
+	Files		[]ManifestFile		`json:"files"`
+}
+
+type ManifestFile struct {
+	Path		string			`json:"path"`
+	Size		int64			`json:"size"`
+	Sha256		string			`json:"sha256"`
+}
+
+/* Returns the size and SHA-256 checksum of a file. */
+func Checksum(name string) (ManifestFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	defer file.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	return ManifestFile{Path: name, Size: size, Sha256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+/* Writes a JSON manifest listing the metadata and data files read and every file the run created with
+their sizes and SHA-256 checksums, so a delivery can be verified. Created is left out unless -timestamp
+is given, which keeps the manifest of the same input byte-identical. */
+func WriteManifest(out string, metadata string, data []string) error {
+	var m Manifest
+	var err error
+	if *timestamp {
+		m.Created = time.Now().UTC().Format(time.RFC3339)
+	}
+	m.Metadata, err = Checksum(metadata)
+	if err != nil {
+		return err
+	}
+	m.Data, m.Files = []ManifestFile{}, []ManifestFile{}
+	for _, name := range data {
+		f, err := Checksum(name)
+		if err != nil {
+			return err
+		}
+		m.Data = append(m.Data, f)
+	}
+	for _, name := range outputs {
+		f, err := Checksum(name)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, f)
+	}
+	file, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+/* Logs the files a dry run wrote to the temporary folder tmp as the files it would have written, moved
+says where the files and folders written to tmp would have gone, followed by the log file and summary
+it left out. Removes tmp. */
+func ReportDryRun(tmp string, moved map[string]string, d *Variables) error {
+	columns := 0
+	for _, v := range d.Variable {
+		columns += len(v.Columns())
+	}
+	slog.Info(fmt.Sprintf("Dry run: %d variables in %d columns", len(d.Variable), columns))
+	for _, name := range outputs {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if real, ok := moved[name]; ok {
+			name = real
+		} else if real, ok := moved[filepath.Dir(name)]; ok {
+			name = path.Join(real, filepath.Base(name))
+		}
+		slog.Info(fmt.Sprintf("Dry run: would write %s, %d bytes", name, info.Size()))
+	}
+	for _, name := range []string{*logFile, *summary} {
+		if name != "" {
+			slog.Info(fmt.Sprintf("Dry run: would write %s", name))
+		}
+	}
+	return os.RemoveAll(tmp)
+}
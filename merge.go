@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+
+/* A Triple-S file to merge with the data file its records are in, or none */
+type MergePart struct {
+	Metadata	string
+	Data		string
+	d		*Variables
+	serial		*Variable	// the serial the records are joined on, left out of the merged metadata
+	offset		int		// columns before its records in the merged record
+	length		int
+}
+
+/* Merges the variables of the parts in to the metadata of the first. Without keep the variables of every
+part are moved behind those of the parts before, so their records can be put side by side, and the serial
+of a part named as the serial of the first is left out to join its records on. With keep the parts describe
+the same records and their positions are kept. Fails when names clash or, with keep, positions overlap.
+Idents used before get the number of the part in front. */
+func MergeMetadata(parts []*MergePart, keep bool) (*Variables, error) {
+	merged := *parts[0].d
+	merged.Variable = nil
+	main := Serial(parts[0].d)
+	names := make(map[string]string)
+	idents := make(map[string]bool)
+	owner := make(map[int]string)	// columns to the variable using them, with keep
+	var clashes []string
+	offset := 0
+	for k, p := range parts {
+		p.length = 0
+		for _, v := range p.d.Variable {
+			if v.Position.Finish > p.length {
+				p.length = v.Position.Finish
+			}
+		}
+		if !keep {
+			p.offset = offset
+			offset += p.length
+		}
+		if s := Serial(p.d); k > 0 && !keep && main != nil && s != nil && strings.EqualFold(s.Name, main.Name) {
+			p.serial = s
+		}
+		for _, v := range p.d.Variable {
+			if p.serial != nil && v.Name == p.serial.Name {
+				continue
+			}
+			if first, ok := names[strings.ToLower(v.Name)]; ok {
+				clashes = append(clashes, fmt.Sprintf("%s in %s and %s", v.Name, first, p.Metadata))
+				continue
+			}
+			names[strings.ToLower(v.Name)] = p.Metadata
+			if idents[v.Ident] {
+				v.Ident = fmt.Sprintf("%d_%s", k+1, v.Ident)
+			}
+			idents[v.Ident] = true
+			if k > 0 && v.Use != "" {
+				v.Use = ""
+			} // The serial and weight are those of the first part
+			v.Position.Start += p.offset
+			v.Position.Finish += p.offset
+			if keep {
+				for col := v.Position.Start; col <= v.Position.Finish; col++ {
+					if o, ok := owner[col]; ok {
+						clashes = append(clashes, fmt.Sprintf("%s overlaps %s in column %d", v.Name, o, col))
+						break
+					}
+					owner[col] = v.Name
+				}
+			}
+			merged.Variable = append(merged.Variable, v)
+		}
+	}
+	if len(clashes) > 0 {
+		return nil, fmt.Errorf("cannot merge: %s", strings.Join(clashes, ", "))
+	}
+	return &merged, nil
+}
+
+/* Writes the records of the parts side by side to out, each padded to the length of its part. The
+records of a part with a serial to join on are matched by serial and have it blanked, the others are
+matched by their order. Only the records of parts joined by serial are held in memory, the others are
+read along with the first part. Returns the number of records written. */
+func MergeData(parts []*MergePart, out string) (int, error) {
+	main := Serial(parts[0].d)
+	bySerial := make([]map[string]string, len(parts))
+	inOrder := make([]*RecordReader, len(parts))
+	for k, p := range parts[1:] {
+		k++
+		if p.serial == nil {
+			r, err := OpenRecords(p.Data)
+			if err != nil {
+				return 0, err
+			}
+			defer r.Close()
+			inOrder[k] = r
+			continue
+		}
+		bySerial[k] = make(map[string]string)
+		err := ReadRecords(p.Data, func(n int, rec string) error {
+			id := strings.TrimSpace(Field(rec, p.serial.Position.Start, p.serial.Position.Finish))
+			s := p.serial.Position
+			bySerial[k][strings.Clone(id)] = pad(rec, s.Start-1) + strings.Repeat(" ", s.Finish-s.Start+1) + Field(rec, s.Finish+1, len(rec))
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	file, err := Create(out)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	unmatched := make([]int, len(parts))
+	written := 0
+	err = ReadRecords(parts[0].Data, func(n int, rec string) error {
+		line := pad(rec, parts[0].length)
+		id := ""
+		if main != nil {
+			id = strings.TrimSpace(Field(rec, main.Position.Start, main.Position.Finish))
+		}
+		for k := 1; k < len(parts); k++ {
+			var part string
+			var ok bool
+			if parts[k].serial != nil {
+				part, ok = bySerial[k][id]
+			} else {
+				var err error
+				part, err = inOrder[k].Next()
+				if err != nil && err != io.EOF {
+					return err
+				}
+				ok = err == nil
+			}
+			if !ok {
+				unmatched[k]++
+			}
+			line += pad(part, parts[k].length)
+		}
+		written = n
+		_, err := w.WriteString(strings.TrimRight(line, " ") + "\n")
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	for k, n := range unmatched {
+		if n > 0 {
+			Warn(fmt.Sprintf("%d records have no record in %s and are left blank there", n, parts[k].Data))
+		}
+	}
+	return written, w.Flush()
+}
+
+/* Returns rec cut or padded with spaces to length bytes. */
+func pad(rec string, length int) string {
+	if len(rec) >= length {
+		return rec[:length]
+	}
+	return rec + strings.Repeat(" ", length-len(rec))
+}
+
+/* Runs the merge command writing the Triple-S files given as one, with their data side by side unless
+-keep-positions is given. */
+func MergeCommand(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	out := fs.String("o", "merged.xml", "Triple-S file to write, with its data file of the same name ending in .asc")
+	keep := fs.Bool("keep-positions", false, "the files describe the same records, keep their positions")
+	version := fs.String("sss-version", "2.0", "Triple-S version of the merged file")
+	paths := parseArgs(fs, args)
+	if len(paths) < 2 {
+		return UsageError("Usage: XMLtoSPS merge [-o merged.xml] [-keep-positions] [-sss-version v] <XML:filepath> <XML:filepath>...")
+	}
+	var parts []*MergePart
+	for _, m := range paths {
+		d, err := ReadMetadata(m)
+		if err != nil {
+			return err
+		}
+		p := &MergePart{Metadata: m, d: d, Data: DataFileFor(m)}
+		if d.Href != "" {
+			p.Data, err = DataPath(m, d.Href)
+			if err != nil {
+				return err
+			}
+		}
+		parts = append(parts, p)
+	}
+	merged, err := MergeMetadata(parts, *keep)
+	if err != nil {
+		return err
+	}
+
+	asc := strings.TrimSuffix(*out, path.Ext(*out)) + ".asc"
+	merged.Href = ""
+	if *keep {
+		merged.Href = parts[0].d.Href
+	} else {
+		var missing []string
+		for _, p := range parts {
+			if p.Data == "" {
+				missing = append(missing, p.Metadata)
+			}
+		}
+		if len(missing) > 0 {
+			Warn(fmt.Sprintf("No data file for %s, only the metadata is merged", strings.Join(missing, ", ")))
+		} else {
+			n, err := MergeData(parts, asc)
+			if err != nil {
+				return err
+			}
+			merged.Href = path.Base(asc)
+			slog.Info(fmt.Sprintf("Merged %d records in to %s", n, asc))
+		}
+	}
+	err = WriteSss(*out, *version, merged)
+	if err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Merged %d variables of %d files in to %s", len(merged.Variable), len(parts), *out))
+	return nil
+}
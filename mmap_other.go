@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+
+/* Files are read instead where memory mapping is not supported. */
+func mapFile(file *os.File, size int) ([]byte, error) {
+	return nil, errors.New("memory mapping is not supported")
+}
+
+func unmapFile(b []byte) error {
+	return nil
+}
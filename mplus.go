@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+
+/* Limits of Mplus names and input lines */
+const (
+	mplusName = 8
+	mplusLine = 80
+)
+
+/* Returns unique Mplus names of the columns, cut to mplusName bytes and numbered when that makes them clash. */
+func mplusNames(cols []Column) []string {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = strings.ToUpper(Identifier(c.Name, mplusName))
+	}
+	return uniqueNames(names, mplusName)
+}
+
+/* Writes a list option, wrapping the names over lines Mplus accepts. */
+func mplusList(w *bufio.Writer, option string, names []string) {
+	line := "  " + option + " ="
+	for _, n := range names {
+		if len(line)+1+len(n) > mplusLine {
+			fmt.Fprintln(w, line)
+			line = "   "
+		}
+		line += " " + n
+	}
+	fmt.Fprintln(w, line+";")
+}
+
+/* Writes an Mplus input skeleton base.inp and the numeric columns of the data file as the free format
+base.dat it reads, with blanks and other values that are not numbers written as the missing value flag ".".
+Mplus reads numbers only, so character, date and time variables are left out. Labelled columns are
+listed as CATEGORICAL. */
+func WriteMplus(asc string, base string, d *Variables) error {
+	var cols []Column
+	for _, v := range d.Variable {
+		for _, c := range v.Columns() {
+			if c.Print != "" {
+				cols = append(cols, c)
+			}
+		}
+	}
+	names := mplusNames(cols)
+	var categorical []string
+	weight := ""
+	for i, c := range cols {
+		if len(c.Labels) > 0 {
+			categorical = append(categorical, names[i])
+		}
+		for _, v := range d.Variable {
+			if v.Use == "weight" && v.Name == c.Name {
+				weight = names[i]
+			}
+		}
+	}
+
+	file, err := Create(base + ".inp")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	title := d.Title
+	if title == "" {
+		title = d.Name
+	}
+	title, _ = Truncate(strings.Join(strings.Fields(title), " "), mplusLine-9, false)
+	fmt.Fprintf(w, "TITLE:    %s\n\n", title)
+	fmt.Fprintf(w, "DATA:     FILE = %s;\n\n", path.Base(base)+".dat")
+	fmt.Fprint(w, "VARIABLE:\n")
+	for i, c := range cols {
+		label, _ := Truncate(strings.Join(strings.Fields(c.Label), " "), mplusLine-mplusName-5, false)
+		fmt.Fprintf(w, "  ! %-*s %s\n", mplusName, names[i], label)
+	}
+	mplusList(w, "NAMES", names)
+	fmt.Fprint(w, "  MISSING = .;\n")
+	if len(categorical) > 0 {
+		mplusList(w, "CATEGORICAL", categorical)
+	}
+	if weight != "" {
+		fmt.Fprintf(w, "  WEIGHT = %s;\n", weight)
+	}
+	fmt.Fprint(w, "  ! USEVARIABLES = ;\n\nANALYSIS: TYPE = BASIC;\n")
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	dat, err := Create(base + ".dat")
+	if err != nil {
+		return err
+	}
+	defer dat.Close()
+	b := bufio.NewWriter(dat)
+	row := make([]string, len(cols))
+	err = ReadRecords(asc, func(n int, rec string) error {
+		for i, c := range cols {
+			row[i] = CleanField(c, rec)
+			if _, err := strconv.ParseFloat(row[i], 64); err != nil {
+				row[i] = "."
+			}
+		}
+		_, err := b.WriteString(strings.Join(row, " ") + "\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return b.Flush()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+
+/* A variable given another name in the syntax, and why: sanitized, truncated or deduplicated */
+type Rename struct {
+	Ident		string
+	From		string
+	To		string
+	Reason		string
+}
+
+/* Longest variable name SPSS accepts, in bytes */
+const MaxName = 64
+
+/* Words SPSS reserves, which cannot name a variable */
+var spssReserved = []string{"ALL", "AND", "BY", "EQ", "GE", "GT", "LE", "LT", "NE", "NOT", "OR", "TO", "WITH"}
+
+/* Returns how many bytes the longest name of the variable in the syntax adds to its name. */
+func expansion(v Variable) int {
+	longest := 0
+	for _, n := range v.SpssNames() {
+		if len(n)-len(v.Name) > longest {
+			longest = len(n) - len(v.Name)
+		}
+	}
+	return longest
+}
+
+/* Gives every variable whose name SPSS does not accept one it does: characters other than letters,
+digits and _ . @ # $ become _, a name not starting with a letter or @ gets V in front, a period at the
+end is dropped, a reserved word gets _ added and a name is cut so its longest name in the syntax, such
+as Q2#10 of a multiple, has at most MaxName bytes. Returns the variables renamed. */
+func SanitizeNames(d *Variables) []Rename {
+	var renames []Rename
+	for i := range d.Variable {
+		v := &d.Variable[i]
+		name := strings.Map(func(c rune) rune {
+			if unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("_.@#$", c) {
+				return c
+			}
+			return '_'
+		}, v.Name)
+		if first, _ := utf8.DecodeRuneInString(name); !unicode.IsLetter(first) && first != '@' {
+			name = "V" + name
+		}
+		name = strings.TrimRight(name, ".")
+		if contains(spssReserved, strings.ToUpper(name)) {
+			name += "_"
+		}
+		reason := "sanitized"
+		if name == v.Name {
+			reason = ""
+		}
+		if cut := cutBytes(name, MaxName-expansion(*v)); cut != name {
+			name, reason = cut, strings.TrimPrefix(reason+" and truncated", " and ")
+		}
+		if reason != "" {
+			renames = append(renames, Rename{v.Ident, v.Name, name, reason})
+			v.OriginalName, v.Name = v.SssName(), name
+		}
+	}
+	return renames
+}
+
+/* Gives every variable whose name, or a name of its expansion in to one variable per category or
+subfield like Q2#1, is taken by a variable before it the first free name with _2, _3 and so on added.
+SPSS does not tell upper and lower case apart, so neither does this. Returns the variables renamed. */
+func RenameDuplicates(d *Variables) []Rename {
+	var renames []Rename
+	taken := make(map[string]bool)
+	free := func(v Variable) bool {
+		for _, n := range v.SpssNames() {
+			if taken[strings.ToLower(n)] {
+				return false
+			}
+		}
+		return true
+	}
+	for i := range d.Variable {
+		v := &d.Variable[i]
+		if !free(*v) {
+			from := v.Name
+			for k := 2; !free(*v); k++ {
+				suffix := fmt.Sprintf("_%d", k)
+				v.Name = cutBytes(from, MaxName-expansion(*v)-len(suffix)) + suffix
+			}
+			renames = append(renames, Rename{v.Ident, from, v.Name, "deduplicated"})
+			if v.OriginalName == "" {
+				v.OriginalName = from
+			}
+		}
+		for _, n := range v.SpssNames() {
+			taken[strings.ToLower(n)] = true
+		}
+	}
+	return renames
+}
+
+/* Returns the names with a number added to each that clashes with one before it, upper and lower case
+not told apart, and cut so it keeps at most max bytes. The targets other than SPSS name their columns with it. */
+func uniqueNames(names []string, max int) []string {
+	unique := make([]string, len(names))
+	used := make(map[string]bool)
+	for i, base := range names {
+		name := base
+		for n := 1; used[strings.ToUpper(name)]; n++ {
+			suffix := strconv.Itoa(n)
+			name = cutBytes(base, max-len(suffix)) + suffix
+		}
+		used[strings.ToUpper(name)] = true
+		unique[i] = name
+	}
+	return unique
+}
+
+/* Returns the renames as the lines of a table. */
+func RenameTable(renames []Rename) []string {
+	width := len("triple-s name")
+	for _, r := range renames {
+		if len(r.From) > width {
+			width = len(r.From)
+		}
+	}
+	lines := []string{fmt.Sprintf("%-*s  %-6s  %s", width, "triple-s name", "ident", "spss name")}
+	for _, r := range renames {
+		lines = append(lines, fmt.Sprintf("%-*s  %-6s  %s", width, r.From, r.Ident, r.To))
+	}
+	return lines
+}
+
+/* Writes the renames as a CSV file mapping the Triple-S names to the SPSS names and the idents, so
+specifications written against the Triple-S names can be translated. A variable renamed twice is
+listed once with its first and last name. */
+func WriteNameMap(out string, renames []Rename) error {
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	w.Write([]string{"triple-s name", "spss name", "ident", "reason"})
+	var order []string
+	merged := make(map[string]Rename)
+	for _, r := range renames {
+		if m, ok := merged[r.Ident]; ok {
+			m.To, m.Reason = r.To, m.Reason+" and "+r.Reason
+			merged[r.Ident] = m
+		} else {
+			merged[r.Ident] = r
+			order = append(order, r.Ident)
+		}
+	}
+	for _, ident := range order {
+		r := merged[ident]
+		w.Write([]string{r.From, r.To, r.Ident, r.Reason})
+	}
+	w.Flush()
+	return w.Error()
+}
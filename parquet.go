@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+)
+
+
+/* Rows collected in a record batch before it is written as a row group, which bounds the memory used
+for big data files */
+const parquetRowGroup = 100000
+
+/* Parquet physical types, encodings and thrift compact protocol types used by the writer */
+const (
+	parquetInt64		= 2
+	parquetDouble		= 5
+	parquetByteArray	= 6
+
+	parquetPlain	= 0
+	parquetRLE	= 3
+
+	thriftI32	= 5
+	thriftI64	= 6
+	thriftBinary	= 8
+	thriftStruct	= 12
+)
+
+
+/* Encodes structures with the thrift compact protocol the Parquet metadata is written in. */
+type thrift struct {
+	buf	[]byte
+	last	int16
+	stack	[]int16
+}
+
+func (t *thrift) varint(v uint64) {
+	for v >= 0x80 {
+		t.buf = append(t.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	t.buf = append(t.buf, byte(v))
+}
+
+func (t *thrift) zigzag(v int64) {
+	t.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (t *thrift) field(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.zigzag(int64(id))
+	}
+	t.last = id
+}
+
+func (t *thrift) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thrift) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thrift) binary(s string) {
+	t.varint(uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+func (t *thrift) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.binary(s)
+}
+
+func (t *thrift) list(id int16, typ byte, n int) {
+	t.field(id, 9)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|typ)
+	} else {
+		t.buf = append(t.buf, 0xf0|typ)
+		t.varint(uint64(n))
+	}
+}
+
+/* Starts a struct, a field when id is positive or a list element or the outer struct otherwise. */
+func (t *thrift) begin(id int16) {
+	if id > 0 {
+		t.field(id, thriftStruct)
+	}
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thrift) end() {
+	t.buf = append(t.buf, 0)
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *thrift) keyValues(id int16, kv [][2]string) {
+	t.list(id, thriftStruct, len(kv))
+	for _, p := range kv {
+		t.begin(0)
+		t.str(1, p[0])
+		t.str(2, p[1])
+		t.end()
+	}
+}
+
+
+/* A column of the Parquet file with the chunks written of it */
+type parquetColumn struct {
+	col	Column
+	kind	int32
+	chunks	[]parquetChunk
+}
+
+/* Where a written column chunk is and how large it is */
+type parquetChunk struct {
+	offset	int64
+	size	int64
+	rows	int64
+}
+
+/* Returns the data page of a column of the batch: the definition levels as one bit-packed run, which
+is the bitmap of the batch as it is, followed by the plain encoded values that are not null. */
+func parquetPage(bc *BatchColumn, rows int) []byte {
+	var levels thrift
+	groups := (rows + 7) / 8
+	levels.varint(uint64(groups)<<1 | 1)
+	levels.buf = append(levels.buf, bc.Valid[:groups]...)
+	page := binary.LittleEndian.AppendUint32(nil, uint32(len(levels.buf)))
+	page = append(page, levels.buf...)
+	if bc.Type == columnUtf8 {
+		for i := 0; i < rows; i++ {
+			value := bc.Values[bc.Offsets[i]:bc.Offsets[i+1]]
+			page = binary.LittleEndian.AppendUint32(page, uint32(len(value)))
+			page = append(page, value...)
+		}
+		return page
+	} else if bc.Nulls == 0 {
+		return append(page, bc.Values...)
+	}
+	for i := 0; i < rows; i++ {
+		if bc.IsValid(i) {
+			page = append(page, bc.Number(i)...)
+		}
+	}
+	return page
+}
+
+/* Returns the label metadata stored with every chunk of the column. */
+func (pc *parquetColumn) metadata() [][2]string {
+	kv := [][2]string{{"label", pc.col.Label}, {"sss.type", pc.col.Type}}
+	if len(pc.col.Labels) > 0 {
+		labels := make(map[string]string)
+		for _, l := range pc.col.Labels {
+			labels[strconv.Itoa(l.Value)] = l.Name
+		}
+		b, _ := json.Marshal(labels)
+		kv = append(kv, [2]string{"value_labels", string(b)})
+	}
+	return kv
+}
+
+
+/* Writes the fixed-width data file as an uncompressed Parquet file. Every column carries its variable label
+and value labels in its column chunk metadata and the file metadata holds the JSON codebook, so the
+survey can be used without an SPSS step. */
+func WriteParquet(asc string, out string, d *Variables) error {
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	var cols []*parquetColumn
+	for _, v := range d.Variable {
+		for _, c := range v.Columns() {
+			pc := &parquetColumn{col: c, kind: parquetDouble}
+			if ColumnType(c) == columnUtf8 {
+				pc.kind = parquetByteArray
+			} else if ColumnType(c) == columnInt64 {
+				pc.kind = parquetInt64
+			}
+			cols = append(cols, pc)
+		}
+	}
+
+	offset := int64(4)
+	_, err = w.WriteString("PAR1")
+	if err != nil {
+		return err
+	}
+	var groups []int64
+	err = ReadBatches(asc, parquetRowGroup, d, func(b *RecordBatch) error {
+		rows := int64(b.Rows)
+		for i, pc := range cols {
+			body := parquetPage(b.Columns[i], b.Rows)
+			var h thrift
+			h.begin(0)
+			h.i32(1, 0)
+			h.i32(2, int32(len(body)))
+			h.i32(3, int32(len(body)))
+			h.begin(5)
+			h.i32(1, int32(rows))
+			h.i32(2, parquetPlain)
+			h.i32(3, parquetRLE)
+			h.i32(4, parquetRLE)
+			h.end()
+			h.end()
+			_, err := w.Write(h.buf)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(body)
+			if err != nil {
+				return err
+			}
+			size := int64(len(h.buf) + len(body))
+			pc.chunks = append(pc.chunks, parquetChunk{offset, size, rows})
+			offset += size
+		}
+		groups = append(groups, rows)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var m thrift
+	total := int64(0)
+	for _, n := range groups {
+		total += n
+	}
+	m.begin(0)
+	m.i32(1, 1)
+	m.list(2, thriftStruct, len(cols)+1)
+	m.begin(0)
+	m.str(4, "schema")
+	m.i32(5, int32(len(cols)))
+	m.end()
+	for _, pc := range cols {
+		m.begin(0)
+		m.i32(1, pc.kind)
+		m.i32(3, 1)
+		m.str(4, pc.col.Name)
+		if pc.kind == parquetByteArray {
+			m.i32(6, 0)
+		}
+		m.end()
+	}
+	m.i64(3, total)
+	m.list(4, thriftStruct, len(groups))
+	for g, n := range groups {
+		m.begin(0)
+		m.list(1, thriftStruct, len(cols))
+		size := int64(0)
+		for _, pc := range cols {
+			chunk := pc.chunks[g]
+			size += chunk.size
+			m.begin(0)
+			m.i64(2, chunk.offset)
+			m.begin(3)
+			m.i32(1, pc.kind)
+			m.list(2, thriftI32, 2)
+			m.zigzag(parquetPlain)
+			m.zigzag(parquetRLE)
+			m.list(3, thriftBinary, 1)
+			m.binary(pc.col.Name)
+			m.i32(4, 0)
+			m.i64(5, chunk.rows)
+			m.i64(6, chunk.size)
+			m.i64(7, chunk.size)
+			m.keyValues(8, pc.metadata())
+			m.i64(9, chunk.offset)
+			m.end()
+			m.end()
+		}
+		m.i64(2, size)
+		m.i64(3, n)
+		m.end()
+	}
+	codebook, _ := json.Marshal(NewCodebook(d))
+	m.keyValues(5, [][2]string{{"triple-s.codebook", string(codebook)}})
+	m.str(6, "xmltosps")
+	m.end()
+
+	_, err = w.Write(m.buf)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(m.buf))))
+	if err != nil {
+		return err
+	}
+	_, err = w.WriteString("PAR1")
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
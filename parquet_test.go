@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+
+/* Decodes the thrift compact protocol, for reading back the metadata the writer encodes. Structures
+come out as maps by field id, lists as slices, integers as int64 and binaries as strings. */
+type thriftReader struct {
+	b	[]byte
+	pos	int
+}
+
+func (r *thriftReader) varint() uint64 {
+	var v uint64
+	for shift := 0; ; shift += 7 {
+		c := r.b[r.pos]
+		r.pos++
+		v |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return v
+		}
+	}
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case 1, 2:
+		r.pos++
+		return r.b[r.pos-1] == 1
+	case 3:
+		r.pos++
+		return int64(r.b[r.pos-1])
+	case 4, 5, 6:
+		return r.zigzag()
+	case 7:
+		r.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.pos-8:]))
+	case 8:
+		n := int(r.varint())
+		r.pos += n
+		return string(r.b[r.pos-n : r.pos])
+	case 9, 10:
+		head := r.b[r.pos]
+		r.pos++
+		n := int(head >> 4)
+		if n == 15 {
+			n = int(r.varint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.value(head & 0x0f)
+		}
+		return list
+	case 12:
+		return r.structure()
+	}
+	panic("unsupported thrift type")
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	s := make(map[int16]interface{})
+	var id int16
+	for {
+		head := r.b[r.pos]
+		r.pos++
+		if head == 0 {
+			return s
+		}
+		if delta := int16(head >> 4); delta == 0 {
+			id = int16(r.zigzag())
+		} else {
+			id += delta
+		}
+		switch typ := head & 0x0f; typ {
+		case 1, 2:
+			s[id] = typ == 1
+		default:
+			s[id] = r.value(typ)
+		}
+	}
+}
+
+/* Returns the values of the data page at offset, nil where the definition levels mark a null. */
+func parquetValues(t *testing.T, b []byte, offset int64, kind int64) []interface{} {
+	t.Helper()
+	r := &thriftReader{b: b, pos: int(offset)}
+	header := r.structure()
+	data := header[5].(map[int16]interface{})
+	rows := int(data[1].(int64))
+	if header[1].(int64) != 0 || data[2].(int64) != parquetPlain || data[3].(int64) != parquetRLE {
+		t.Fatalf("page header %v is no plain data page", header)
+	}
+	page := b[r.pos : r.pos+int(header[3].(int64))]
+	levels := &thriftReader{b: page[4 : 4+binary.LittleEndian.Uint32(page)]}
+	if run := levels.varint(); run&1 != 1 || int(run>>1) != (rows+7)/8 {
+		t.Fatalf("definition levels are no bit-packed run of %d rows", rows)
+	}
+	valid := levels.b[levels.pos:]
+	pos := 4 + len(levels.b)
+	values := make([]interface{}, rows)
+	for i := range values {
+		if valid[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		switch kind {
+		case parquetInt64:
+			values[i] = int64(binary.LittleEndian.Uint64(page[pos:]))
+			pos += 8
+		case parquetDouble:
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(page[pos:]))
+			pos += 8
+		case parquetByteArray:
+			n := int(binary.LittleEndian.Uint32(page[pos:]))
+			values[i] = string(page[pos+4 : pos+4+n])
+			pos += 4 + n
+		}
+	}
+	if pos != len(page) {
+		t.Errorf("%d bytes left over in the page", len(page)-pos)
+	}
+	return values
+}
+
+/* The footer describes the schema and the column chunks, and the pages decode to the values of the data. */
+func TestWriteParquet(t *testing.T) {
+	d, asc := testData(t)
+	out := filepath.Join(t.TempDir(), "test.parquet")
+	err := WriteParquet(asc, out, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:4]) != "PAR1" || string(b[len(b)-4:]) != "PAR1" {
+		t.Fatalf("file starts with %q and ends with %q", b[:4], b[len(b)-4:])
+	}
+	size := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	footer := &thriftReader{b: b[len(b)-8-size : len(b)-8]}
+	meta := footer.structure()
+	if footer.pos != size {
+		t.Fatalf("footer of %d bytes decodes to %d", size, footer.pos)
+	}
+	if rows := meta[3].(int64); rows != int64(len(testRecords)) {
+		t.Errorf("%d rows, want %d", rows, len(testRecords))
+	}
+
+	schema := meta[2].([]interface{})
+	root := schema[0].(map[int16]interface{})
+	columns := testColumns(d)
+	if root[4] != "schema" || root[5].(int64) != int64(len(columns)) {
+		t.Fatalf("schema root is %v", root)
+	}
+	var names []string
+	kinds := make(map[string]int64)
+	for _, e := range schema[1:] {
+		e := e.(map[int16]interface{})
+		names = append(names, e[4].(string))
+		kinds[e[4].(string)] = e[1].(int64)
+	}
+	if !reflect.DeepEqual(names, columns) {
+		t.Fatalf("columns are %v, want %v", names, columns)
+	}
+
+	want := map[string][]interface{}{
+		"ID":	{"0001", "0002", "0003"},
+		"Q1":	{int64(1), int64(2), nil},
+		"Q2#2":	{int64(0), int64(1), int64(0)},
+		"WT":	{1.5, 2.25, nil},
+		"OE":	{"hello", "", ""},
+	}
+	groups := meta[4].([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("%d row groups, want 1", len(groups))
+	}
+	for i, c := range groups[0].(map[int16]interface{})[1].([]interface{}) {
+		cm := c.(map[int16]interface{})[3].(map[int16]interface{})
+		name := columns[i]
+		if cm[1].(int64) != kinds[name] || cm[5].(int64) != int64(len(testRecords)) {
+			t.Errorf("column chunk of %s is %v", name, cm)
+		}
+		values := parquetValues(t, b, cm[9].(int64), kinds[name])
+		if w, ok := want[name]; ok && !reflect.DeepEqual(values, w) {
+			t.Errorf("%s holds %v, want %v", name, values, w)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"path"
+	"strings"
+)
+
+
+/* Longest identifier PostgreSQL keeps, longer ones are cut */
+const pgName = 63
+
+/* Quotes an identifier for PostgreSQL. */
+func pgIdent(name string) string {
+	return Quote(cutBytes(name, pgName))
+}
+
+/* Quotes a string literal for PostgreSQL. */
+func pgQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+/* Returns the PostgreSQL type of a column, the narrowest one holding every value its width allows. */
+func pgType(c Column) string {
+	width := c.Finish - c.Start + 1
+	switch {
+	case c.Type == "date":
+		return "date"
+	case c.Type == "time":
+		return "time"
+	case c.Type == "logical":
+		return "boolean"
+	case c.Print == "":
+		return fmt.Sprintf("varchar(%d)", width)
+	case !strings.HasSuffix(c.Print, ".0"):
+		var w, dec int
+		fmt.Sscanf(c.Print, "F%d.%d", &w, &dec)
+		return fmt.Sprintf("numeric(%d,%d)", w, dec)
+	case width <= 4:
+		return "smallint"
+	case width <= 9:
+		return "integer"
+	case width <= 18:
+		return "bigint"
+	}
+	return fmt.Sprintf("numeric(%d)", width)
+}
+
+/* Escapes a value for the text format of COPY. */
+var pgCopyEscape = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+/* Writes a PostgreSQL script creating a table typed from the Triple-S metadata, with the labels as comments,
+and the data file as a tab-delimited base.tsv the script loads with psql's \copy. Blank numbers, dates and times
+become NULL, blank character fields empty strings. */
+func WritePostgres(asc string, base string, d *Variables) error {
+	var cols []Column
+	for _, v := range d.Variable {
+		cols = append(cols, v.Columns()...)
+	}
+	table := pgIdent(path.Base(base))
+
+	file, err := Create(base + ".sql")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	fmt.Fprintf(w, "CREATE TABLE %s (\n", table)
+	for i, c := range cols {
+		sep := ","
+		if i == len(cols)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(w, "\t%s %s%s\n", pgIdent(c.Name), pgType(c), sep)
+	}
+	fmt.Fprint(w, ");\n\n")
+	if d.Title != "" {
+		fmt.Fprintf(w, "COMMENT ON TABLE %s IS %s;\n", table, pgQuote(d.Title))
+	}
+	for _, c := range cols {
+		fmt.Fprintf(w, "COMMENT ON COLUMN %s.%s IS %s;\n", table, pgIdent(c.Name), pgQuote(c.Label))
+	}
+	fmt.Fprintf(w, "\n\\copy %s FROM %s\n", table, pgQuote(path.Base(base)+".tsv"))
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	data, err := Create(base + ".tsv")
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	b := bufio.NewWriter(data)
+	row := make([]string, len(cols))
+	err = ReadRecords(asc, func(n int, rec string) error {
+		for i, c := range cols {
+			value := CleanField(c, rec)
+			if value == "" && c.Type != "character" {
+				value = "\\N"
+			} else {
+				value = pgCopyEscape.Replace(value)
+			}
+			row[i] = value
+		}
+		_, err := b.WriteString(strings.Join(row, "\t") + "\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return b.Flush()
+}
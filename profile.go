@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+
+/* Starts the profiling asked for: a pprof server on addr, a CPU profile written to cpu and a heap
+profile written to mem when the run ends. Returns the function ending the profiles, which is also
+run when the run fails. */
+func StartProfiling(addr string, cpu string, mem string) (func(), error) {
+	if addr != "" {
+		go func() {
+			err := http.ListenAndServe(addr, nil)
+			if err != nil {
+				Warn(fmt.Sprintf("No profiling server on %s: %v", addr, err))
+			}
+		}()
+		slog.Info(fmt.Sprintf("Serving profiles on http://%s/debug/pprof/", addr))
+	}
+	var cpuFile *os.File
+	if cpu != "" {
+		var err error
+		cpuFile, err = os.Create(cpu)
+		if err != nil {
+			return nil, err
+		}
+		err = pprof.StartCPUProfile(cpuFile)
+		if err != nil {
+			cpuFile.Close()
+			return nil, err
+		}
+	}
+	stopped := false
+	stop := func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			cpuFile.Close()
+		}
+		if mem == "" {
+			return
+		}
+		file, err := os.Create(mem)
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		defer file.Close()
+		runtime.GC() // Up to date figures of what is still in use
+		err = pprof.WriteHeapProfile(file)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+	}
+	atExit = append(atExit, func(err error) {
+		stop()
+	})
+	return stop, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+
+/* Reports how far a long read has come with -progress: a bar redrawn every second when standard
+error is a terminal, and a status message every ten seconds otherwise, so logs stay readable. */
+type Progress struct {
+	Name		string
+	Total		int64
+	Done		int64
+	bar		bool
+	shown		time.Time
+}
+
+/* Returns the progress of reading total bytes of the file name. */
+func NewProgress(name string, total int64) *Progress {
+	p := &Progress{Name: name, Total: total, shown: time.Now()}
+	info, err := os.Stderr.Stat()
+	p.bar = err == nil && info.Mode()&os.ModeCharDevice != 0 && *logFormat == "text"
+	return p
+}
+
+/* Counts n more bytes read and reports them when it is time to. */
+func (p *Progress) Add(n int64) {
+	if p == nil {
+		return
+	}
+	p.Done += n
+	every := 10 * time.Second
+	if p.bar {
+		every = time.Second
+	}
+	if !*progress || time.Since(p.shown) < every {
+		return
+	}
+	p.shown = time.Now()
+	p.show()
+}
+
+/* Reports the end of the read. */
+func (p *Progress) Finish() {
+	if p == nil || !*progress {
+		return
+	}
+	p.show()
+	if p.bar {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func (p *Progress) show() {
+	if p.Total <= 0 {
+		if p.bar {
+			fmt.Fprintf(os.Stderr, "\r%s %s ", p.Name, Size(p.Done))
+		} else {
+			slog.Info(fmt.Sprintf("Read %s of %s", Size(p.Done), p.Name))
+		}
+		return
+	} // The size of a pipe is not known
+	percent := int(p.Done * 100 / p.Total)
+	if !p.bar {
+		slog.Info(fmt.Sprintf("Read %d%% of %s, %s of %s", percent, p.Name, Size(p.Done), Size(p.Total)))
+		return
+	}
+	const width = 30
+	filled := percent * width / 100
+	if filled > width {
+		filled = width
+	}
+	bar := make([]byte, width)
+	for i := range bar {
+		bar[i] = '.'
+		if i < filled {
+			bar[i] = '#'
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\r%s [%s] %3d%% %s of %s ", p.Name, bar, percent, Size(p.Done), Size(p.Total))
+}
+
+/* Counts the bytes read through it */
+type progressReader struct {
+	r		io.Reader
+	p		*Progress
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.Add(int64(n))
+	return n, err
+}
+
+/* Returns a reader of the open file name counting its bytes in p, which is nil without -progress. */
+func ProgressReader(name string, file *os.File) (io.Reader, *Progress) {
+	if !*progress {
+		return file, nil
+	}
+	var total int64
+	info, err := file.Stat()
+	if err == nil && info.Mode().IsRegular() {
+		total = info.Size()
+	}
+	p := NewProgress(name, total)
+	return progressReader{file, p}, p
+}
+
+/* Returns n bytes in the largest unit that keeps a whole number in front. */
+func Size(n int64) string {
+	units := []string{"bytes", "KB", "MB", "GB", "TB"}
+	f, i := float64(n), 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	return fmt.Sprintf("%.1f %s", f, units[i])
+}
+
+/* Reports with -progress that a step of the syntax has been written for the variables of d. */
+func ProgressStep(step string, d *Variables) {
+	if *progress {
+		slog.Info(fmt.Sprintf("Wrote the %s of %d variables", step, len(d.Variable)))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+
+/* Longest column name used in the generated Python */
+const pyName = 255
+
+/* Returns the pandas dtype able to hold the column; integers use the nullable Int64 so blanks stay missing. */
+func pandasType(c Column) string {
+	if c.Print == "" {
+		return "string"
+	} else if strings.HasSuffix(c.Print, ".0") {
+		return "Int64"
+	}
+	return "float64"
+}
+
+/* Writes a Python script with the colspecs, dtypes and labels of the Triple-S metadata.
+Run on its own it reads the data file with pandas.read_fwf; given a path it also writes a .sav with pyreadstat.
+Numbers with an overpunch or implied decimals are read as text and turned in to numbers the way the SPSS syntax reads them. */
+func WritePython(asc string, out string, d *Variables) error {
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	var cols []Column
+	for _, v := range d.Variable {
+		cols = append(cols, v.Columns()...)
+	}
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = Identifier(c.Name, pyName)
+	}
+	names = uniqueNames(names, pyName)
+	for i := range names {
+		names[i] = strconv.Quote(names[i])
+	}
+
+	fmt.Fprint(w, "import sys\n\nimport pandas as pd\n\n")
+	fmt.Fprintf(w, "DATA = %s\n\n", strconv.Quote(asc))
+	fmt.Fprint(w, "COLSPECS = [\n")
+	for _, c := range cols {
+		fmt.Fprintf(w, "    (%d, %d),\n", c.Start-1, c.Finish)
+	}
+	fmt.Fprint(w, "]\n\nNAMES = [\n")
+	for _, n := range names {
+		fmt.Fprintf(w, "    %s,\n", n)
+	}
+	fmt.Fprint(w, "]\n\nDTYPES = {\n")
+	for i, c := range cols {
+		fmt.Fprintf(w, "    %s: %s,\n", names[i], strconv.Quote(pandasType(c)))
+	}
+	fmt.Fprint(w, "}\n\nVARIABLE_LABELS = {\n")
+	for i, c := range cols {
+		fmt.Fprintf(w, "    %s: %s,\n", names[i], strconv.Quote(c.Label))
+	}
+	fmt.Fprint(w, "}\n\nVALUE_LABELS = {\n")
+	for i, c := range cols {
+		if len(c.Labels) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "    %s: {", names[i])
+		for j, l := range c.Labels {
+			if j > 0 {
+				fmt.Fprint(w, ", ")
+			}
+			fmt.Fprintf(w, "%d: %s", l.Value, strconv.Quote(l.Name))
+		}
+		fmt.Fprint(w, "},\n")
+	}
+	fmt.Fprint(w, "}\n\nOVERPUNCH = [\n")
+	for i, c := range cols {
+		if c.Overpunch {
+			fmt.Fprintf(w, "    %s,\n", names[i])
+		}
+	}
+	fmt.Fprint(w, "]\n\nIMPLIED = {\n")
+	for i, c := range cols {
+		if c.Implied > 0 {
+			fmt.Fprintf(w, "    %s: %d,\n", names[i], c.Implied)
+		}
+	}
+	fmt.Fprint(w, "}\n\nPUNCHES = \"{ABCDEFGHI}JKLMNOPQR\"\n\n\n")
+
+	fmt.Fprint(w, `def unpunch(value):
+    """Returns a number whose last digit carries the sign as a COBOL overpunch as a signed number."""
+    i = PUNCHES.find(value[-1:])
+    if i < 0:
+        return value
+    return ("-" if i >= 10 else "") + value[:-1] + str(i % 10)
+
+
+def implied(value, dec):
+    """Returns a number written without a decimal point with its last dec digits after one."""
+    if "." in value or not value.lstrip("+-").isdigit():
+        return value
+    return str(int(value) / 10 ** dec)
+
+
+def read(path=DATA):
+    recoded = set(OVERPUNCH) | set(IMPLIED)
+    df = pd.read_fwf(path, colspecs=COLSPECS, names=NAMES, header=None,
+                     dtype={n: "string" if n in recoded else t for n, t in DTYPES.items()})
+    for name in OVERPUNCH:
+        df[name] = df[name].map(unpunch, na_action="ignore")
+    for name, dec in IMPLIED.items():
+        df[name] = df[name].map(lambda v: implied(v, dec), na_action="ignore")
+    for name in recoded:
+        df[name] = pd.to_numeric(df[name]).astype(DTYPES[name])
+    return df
+
+
+def write_sav(df, path):
+    import pyreadstat
+    pyreadstat.write_sav(df, path,
+                         column_labels=[VARIABLE_LABELS[n] for n in df.columns],
+                         variable_value_labels=VALUE_LABELS)
+
+
+if __name__ == "__main__":
+    df = read()
+    print(df.head())
+    if len(sys.argv) > 1:
+        write_sav(df, sys.argv[1])
+`)
+	return w.Flush()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+
+/* Longest name R accepts */
+const rName = 10000
+
+/* R functions turning the columns read as text for -overpunch and -implied-decimals in to numbers */
+const rRecode = `# Returns numbers whose last digit carries the sign as a COBOL overpunch as signed numbers
+unpunch <- function(x) {
+	n <- nchar(x)
+	last <- substr(x, n, n)
+	substr(x, n, n) <- chartr("{ABCDEFGHI}JKLMNOPQR", "01234567890123456789", last)
+	ifelse(last %in% c("}", LETTERS[10:18]), paste0("-", x), x)
+}
+
+# Returns numbers written without a decimal point with their last dec digits after one
+implied <- function(x, dec) {
+	ifelse(grepl(".", x, fixed = TRUE), as.numeric(x), as.numeric(x) / 10^dec)
+}
+
+`
+
+/* Writes an R script reading the fixed-width data file with readr::read_fwf and setting the variable and
+value labels with the labelled package, so R users get the same one-shot import SPSS users do.
+Blank fields become NA, the only missing values Triple-S knows. Numbers with an overpunch or implied
+decimals are read as text and turned in to numbers the way the SPSS syntax reads them. */
+func WriteR(asc string, out string, d *Variables) error {
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	var cols []Column
+	for _, v := range d.Variable {
+		cols = append(cols, v.Columns()...)
+	}
+	starts, ends, names := make([]string, len(cols)), make([]string, len(cols)), make([]string, len(cols))
+	types := make([]byte, len(cols))
+	var recode []int
+	for i, c := range cols {
+		starts[i], ends[i] = strconv.Itoa(c.Start), strconv.Itoa(c.Finish)
+		names[i] = Identifier(c.Name, rName)
+		types[i] = 'd'
+		if c.Print == "" {
+			types[i] = 'c'
+		} else if c.Overpunch || c.Implied > 0 {
+			types[i] = 'c'
+			recode = append(recode, i)
+		}
+	}
+	names = uniqueNames(names, rName)
+	for i := range names {
+		names[i] = strconv.Quote(names[i])
+	}
+
+	fmt.Fprint(w, "library(readr)\nlibrary(labelled)\n\n")
+	if len(recode) > 0 {
+		fmt.Fprint(w, rRecode)
+	}
+	fmt.Fprintf(w, "data <- read_fwf(\n\t%s,\n\tfwf_positions(\n", strconv.Quote(asc))
+	fmt.Fprintf(w, "\t\tstart = c(%s),\n", strings.Join(starts, ", "))
+	fmt.Fprintf(w, "\t\tend = c(%s),\n", strings.Join(ends, ", "))
+	fmt.Fprintf(w, "\t\tcol_names = c(%s)\n\t),\n", strings.Join(names, ", "))
+	fmt.Fprintf(w, "\tcol_types = %s,\n\tna = c(\"\")\n)\n\n", strconv.Quote(string(types)))
+	for _, i := range recode {
+		value := fmt.Sprintf("data[[%s]]", names[i])
+		if cols[i].Overpunch {
+			value = fmt.Sprintf("unpunch(%s)", value)
+		}
+		if cols[i].Implied > 0 {
+			value = fmt.Sprintf("implied(%s, %d)", value, cols[i].Implied)
+		} else {
+			value = fmt.Sprintf("as.numeric(%s)", value)
+		}
+		fmt.Fprintf(w, "data[[%s]] <- %s\n", names[i], value)
+	}
+	if len(recode) > 0 {
+		fmt.Fprint(w, "\n")
+	}
+
+	fmt.Fprint(w, "var_label(data) <- list(\n")
+	for i, c := range cols {
+		sep := ","
+		if i == len(cols)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(w, "\t%s = %s%s\n", names[i], strconv.Quote(c.Label), sep)
+	}
+	fmt.Fprint(w, ")\n\n")
+
+	var labelled []string
+	for i, c := range cols {
+		if len(c.Labels) == 0 {
+			continue
+		}
+		pairs := make([]string, len(c.Labels))
+		for j, l := range c.Labels {
+			pairs[j] = fmt.Sprintf("%s = %d", strconv.Quote(l.Name), l.Value)
+		}
+		labelled = append(labelled, fmt.Sprintf("\t%s = c(%s)", names[i], strings.Join(pairs, ", ")))
+	}
+	if len(labelled) > 0 {
+		fmt.Fprintf(w, "val_labels(data) <- list(\n%s\n)\n", strings.Join(labelled, ",\n"))
+	}
+	return w.Flush()
+}
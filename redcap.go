@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+
+/* Longest field name REDCap accepts */
+const redcapName = 100
+
+/* Columns of a REDCap data dictionary */
+var redcapHeader = []string{"Variable / Field Name", "Form Name", "Section Header", "Field Type", "Field Label",
+	"Choices, Calculations, OR Slider Labels", "Field Note", "Text Validation Type OR Show Slider Number",
+	"Text Validation Min", "Text Validation Max", "Identifier?", "Branching Logic (Show field only if...)",
+	"Required Field?", "Custom Alignment", "Question Number (surveys only)", "Matrix Group Name",
+	"Matrix Ranking?", "Field Annotation"}
+
+/* Returns name as a REDCap field name: lower case letters, digits and underscores starting with a letter. */
+func redcapIdent(name string) string {
+	name = strings.ToLower(Identifier(name, redcapName))
+	if strings.HasPrefix(name, "_") {
+		name = cutBytes("v"+name, redcapName)
+	}
+	return name
+}
+
+/* Returns the choices of a radio or checkbox field, | being the separator REDCap splits them on. */
+func redcapChoices(vals []Val) string {
+	choices := make([]string, len(vals))
+	for i, val := range vals {
+		choices[i] = fmt.Sprintf("%d, %s", val.Value, strings.Replace(val.Name, "|", "/", -1))
+	}
+	return strings.Join(choices, " | ")
+}
+
+/* Returns the dictionary row of a variable with the given field name. Multiples become one checkbox field,
+since REDCap expands those to a column per code itself. */
+func redcapField(form string, v Variable, name string) []string {
+	row := make([]string, len(redcapHeader))
+	row[0], row[1], row[2], row[3], row[4] = name, form, "", "text", v.Label
+	switch v.Type {
+	case "single":
+		row[3], row[5] = "radio", redcapChoices(v.Vals)
+	case "multiple":
+		row[3], row[5] = "checkbox", redcapChoices(v.Vals)
+	case "logical":
+		row[3] = "yesno"
+	case "quantity":
+		row[7] = "integer"
+		if dec := v.Decimals(); dec > 0 && dec <= 4 {
+			row[7] = fmt.Sprintf("number_%ddp", dec)
+		} else if dec > 4 {
+			row[7] = "number"
+		}
+		if len(v.Ranges) > 0 {
+			row[8], row[9] = v.Ranges[0].From, v.Ranges[0].To
+		}
+	case "date":
+		row[7] = "date_ymd"
+	case "time":
+		row[7] = "time"
+	case "character":
+		if v.Width() > 255 {
+			row[3] = "notes"
+		}
+	}
+	return row
+}
+
+/* Writes a REDCap data dictionary with a field for every Triple-S variable on a single form.
+REDCap wants the record id first, so the serial variable leads, or a record_id field is added without one. */
+func WriteRedcap(out string, d *Variables) error {
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+
+	form := "survey"
+	if d.Name != "" {
+		form = redcapIdent(d.Name)
+	}
+	rows := [][]string{redcapHeader}
+	serial := Serial(d)
+	id := make([]string, len(redcapHeader))
+	id[0], id[1], id[3], id[4] = "record_id", form, "text", "Record ID"
+	if serial != nil {
+		id[0], id[4] = redcapIdent(serial.Name), serial.Label
+	}
+	var vars []Variable
+	names := []string{id[0]}
+	for _, v := range d.Variable {
+		if serial == nil || v.Name != serial.Name {
+			vars = append(vars, v)
+			names = append(names, redcapIdent(v.Name))
+		}
+	}
+	names = uniqueNames(names, redcapName)
+	rows = append(rows, id)
+	for i, v := range vars {
+		rows = append(rows, redcapField(form, v, names[i+1]))
+	}
+	err = w.WriteAll(rows)
+	if err != nil {
+		return err
+	}
+	return w.Error()
+}
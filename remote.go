@@ -0,0 +1,821 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+
+/* Object storage locations, read and written through the HTTP APIs of their services, and download
+links, which are only read */
+var remoteSchemes = []string{"s3", "gs", "http", "https"}
+
+/* The location every downloaded input was fetched from, by local path */
+var remoteSources = make(map[string]string)
+
+/* Folder of the run for downloaded inputs, decompressed copies and remote outputs before they are
+uploaded */
+var tempDir string
+
+/* Reports whether name is the URL of an object in storage rather than a local path. */
+func IsRemote(name string) bool {
+	u, err := url.Parse(name)
+	return err == nil && contains(remoteSchemes, u.Scheme) && u.Host != ""
+}
+
+/* Returns the temporary folder of the run, created on first use and removed when the run ends. */
+func tempFolder() (string, error) {
+	if tempDir != "" {
+		return tempDir, nil
+	}
+	dir, err := os.MkdirTemp("", "xmltosps-run")
+	if err != nil {
+		return "", err
+	}
+	tempDir = dir
+	atExit = append(atExit, func(err error) {
+		os.RemoveAll(dir)
+	})
+	return dir, nil
+}
+
+/* Removes the temporary folder of the run with the files in it. */
+func RemoveTempFolder() {
+	if tempDir != "" {
+		os.RemoveAll(tempDir)
+	}
+}
+
+/* Returns args with every remote input downloaded and replaced by the path of its copy. */
+func FetchInputs(args []string) ([]string, error) {
+	local := make([]string, len(args))
+	for i, a := range args {
+		local[i] = a
+		if !IsRemote(a) {
+			continue
+		}
+		p, err := Fetch(a)
+		if err != nil {
+			return nil, err
+		}
+		local[i] = p
+	}
+	return local, nil
+}
+
+/* Downloads the object at location in to the remote folder under its own name and returns its path. */
+func Fetch(location string) (string, error) {
+	dir, err := tempFolder()
+	if err != nil {
+		return "", err
+	}
+	sub, err := os.MkdirTemp(dir, "in")
+	if err != nil {
+		return "", err
+	}
+	shown := RedactURL(location)
+	req, err := remoteRequest(http.MethodGet, location, nil, 0)
+	if err != nil {
+		return "", &fs.PathError{Op: "get", Path: shown, Err: err}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", &fs.PathError{Op: "get", Path: shown, Err: errors.Unwrap(err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &fs.PathError{Op: "get", Path: shown, Err: remoteStatus(resp)}
+	}
+	local := filepath.Join(sub, remoteName(resp))
+	file, err := os.Create(local)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	src, p := progressBody(shown, resp)
+	n, err := io.Copy(file, src)
+	p.Finish()
+	if err != nil {
+		return "", &fs.PathError{Op: "get", Path: shown, Err: err}
+	}
+	slog.Debug(fmt.Sprintf("Downloaded %s of %s", Size(n), shown))
+	remoteSources[local] = location
+	return local, nil
+}
+
+/* Returns the name of the downloaded file: the one the server gives, as download links often end in a
+token rather than the name, or the last part of the path followed to the file. */
+func remoteName(resp *http.Response) string {
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if name := filepath.Base("/" + params["filename"]); err == nil && params["filename"] != "" && name != "/" {
+		return name
+	}
+	name := path.Base("/" + strings.TrimSuffix(resp.Request.URL.Path, "/"))
+	if name == "/" {
+		return "download"
+	}
+	return name
+}
+
+/* Returns the location without its query and user information, which hold the signature or
+credentials of a download link and are kept out of the log. */
+func RedactURL(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "..."
+	}
+	if u.User != nil {
+		u.User = url.User("...")
+	}
+	return u.String()
+}
+
+/* Returns the body of the response counting its bytes for -progress. */
+func progressBody(location string, resp *http.Response) (io.Reader, *Progress) {
+	if !*progress {
+		return resp.Body, nil
+	}
+	p := NewProgress(location, resp.ContentLength)
+	return progressReader{resp.Body, p}, p
+}
+
+/* Returns the location of ref relative to the location base, as the href of a downloaded Triple-S file
+is read relative to where it was downloaded from. */
+func RemoteReference(base string, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+/* Returns the local path to write the outputs of a remote -o to, and the location the files written
+there go to: a folder when output ends with / or has no extension, otherwise the folder of the file. */
+func RemoteOutput(output string) (string, string, error) {
+	if u, _ := url.Parse(output); u.Scheme == "http" || u.Scheme == "https" {
+		return "", "", UsageError("-o takes a path, an s3:// or a gs:// URL, not a download link")
+	}
+	dir, err := tempFolder()
+	if err != nil {
+		return "", "", err
+	}
+	out := filepath.Join(dir, "out")
+	err = os.MkdirAll(out, 0755)
+	if err != nil {
+		return "", "", err
+	}
+	if strings.HasSuffix(output, "/") || path.Ext(output) == "" {
+		return out, strings.TrimSuffix(output, "/") + "/", nil
+	}
+	i := strings.LastIndex(output, "/")
+	return filepath.Join(out, output[i+1:]), output[:i+1], nil
+}
+
+/* Uploads every file the run wrote for a remote -o to the location prefix under its name. */
+func UploadOutputs(prefix string) error {
+	dir := filepath.Join(tempDir, "out")
+	for _, name := range outputs {
+		rel, err := filepath.Rel(dir, name)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		err = Upload(name, prefix+filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Size of the parts files larger than it are uploaded in. A single PUT takes at most 5 GB on S3, so
+large outputs go up as a multipart upload to S3 and as a resumable upload to Cloud Storage. */
+var uploadPart int64 = 64 << 20
+
+/* Most parts of a multipart upload S3 accepts */
+const s3MaxParts = 10000
+
+/* Uploads the file name to the object at location, in parts of uploadPart when it is larger. */
+func Upload(name string, location string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.Size() > uploadPart && u.Scheme == "s3":
+		err = s3MultipartUpload(file, info.Size(), u.Host, strings.TrimPrefix(u.Path, "/"))
+	case info.Size() > uploadPart && u.Scheme == "gs":
+		err = gcsResumableUpload(file, info.Size(), location)
+	default:
+		var resp *http.Response
+		resp, err = remoteDo(remoteRequest(http.MethodPut, location, file, info.Size()))
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+	if err != nil {
+		return &fs.PathError{Op: "put", Path: location, Err: err}
+	}
+	slog.Info(fmt.Sprintf("Uploaded %s to %s", name, location))
+	return nil
+}
+
+/* Sends the request made by a request function and returns the response when it succeeds; the caller
+closes its body. */
+func remoteDo(req *http.Request, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Unwrap(err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, remoteStatus(resp)
+	}
+	return resp, nil
+}
+
+/* Uploads the file of size bytes to the S3 object key of bucket as a multipart upload, in parts of
+uploadPart or larger when more than s3MaxParts would be needed. An upload that fails is aborted, so no
+parts are left behind to be paid for. */
+func s3MultipartUpload(file *os.File, size int64, bucket string, key string) error {
+	resp, err := remoteDo(s3Request(http.MethodPost, bucket, key, url.Values{"uploads": {""}}, nil, 0))
+	if err != nil {
+		return err
+	}
+	var started struct {
+		UploadId	string
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&started)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	} else if started.UploadId == "" {
+		return errors.New("S3 started the multipart upload without an upload id")
+	}
+	upload := url.Values{"uploadId": {started.UploadId}}
+
+	err = s3UploadParts(file, size, bucket, key, started.UploadId)
+	if err != nil {
+		resp, abort := remoteDo(s3Request(http.MethodDelete, bucket, key, upload, nil, 0))
+		if abort == nil {
+			resp.Body.Close()
+		} else {
+			slog.Warn(fmt.Sprintf("Aborting the multipart upload %s of s3://%s/%s: %v", started.UploadId, bucket, key, abort))
+		}
+		return err
+	}
+	return nil
+}
+
+/* The parts of a multipart upload as CompleteMultipartUpload lists them */
+type s3Parts struct {
+	XMLName		xml.Name	`xml:"CompleteMultipartUpload"`
+	Part		[]s3Part
+}
+
+type s3Part struct {
+	PartNumber	int
+	ETag		string
+}
+
+/* Uploads the parts of the file to the multipart upload id and completes it. */
+func s3UploadParts(file *os.File, size int64, bucket string, key string, id string) error {
+	part := max(uploadPart, (size+s3MaxParts-1)/s3MaxParts)
+	var parts s3Parts
+	for offset := int64(0); offset < size; offset += part {
+		n := min(part, size-offset)
+		number := len(parts.Part) + 1
+		query := url.Values{"partNumber": {fmt.Sprint(number)}, "uploadId": {id}}
+		resp, err := remoteDo(s3Request(http.MethodPut, bucket, key, query, io.NewSectionReader(file, offset, n), n))
+		if err != nil {
+			return fmt.Errorf("part %d: %w", number, err)
+		}
+		resp.Body.Close()
+		parts.Part = append(parts.Part, s3Part{number, resp.Header.Get("ETag")})
+		slog.Debug(fmt.Sprintf("Uploaded part %d of %s to s3://%s/%s", number, Size(n), bucket, key))
+	}
+	body, err := xml.Marshal(parts)
+	if err != nil {
+		return err
+	}
+	resp, err := remoteDo(s3Request(http.MethodPost, bucket, key, url.Values{"uploadId": {id}}, bytes.NewReader(body), int64(len(body))))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		XMLName	xml.Name
+		Message	string
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err == nil && result.XMLName.Local == "Error" {
+		// S3 reports a failure to complete the upload in the body of a 200 response
+		return fmt.Errorf("completing the multipart upload: %s", result.Message)
+	}
+	return nil
+}
+
+/* Uploads the file of size bytes to the Cloud Storage object at location as a resumable upload sent
+in chunks of uploadPart. */
+func gcsResumableUpload(file *os.File, size int64, location string) error {
+	req, err := remoteRequest(http.MethodPost, location, nil, 0)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Goog-Resumable", "start")
+	resp, err := remoteDo(req, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return errors.New("Cloud Storage started the resumable upload without a session")
+	}
+	for offset := int64(0); offset < size; offset += uploadPart {
+		n := min(uploadPart, size-offset)
+		req, err := http.NewRequest(http.MethodPut, session, io.NewSectionReader(file, offset, n))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = n
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, size))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return errors.Unwrap(err)
+		}
+		resp.Body.Close()
+		// Every chunk but the last is acknowledged with 308 Resume Incomplete
+		if resp.StatusCode != http.StatusPermanentRedirect && resp.StatusCode/100 != 2 {
+			return remoteStatus(resp)
+		}
+		slog.Debug(fmt.Sprintf("Uploaded %s of %s to %s", Size(offset+n), Size(size), location))
+	}
+	return nil
+}
+
+/* Returns the error of a failed request with the message the service gave. */
+func remoteStatus(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	msg := strings.TrimSpace(string(b))
+	if i := strings.Index(msg, "<Message>"); i >= 0 {
+		msg = strings.SplitN(msg[i+len("<Message>"):], "<", 2)[0]
+	}
+	if msg == "" {
+		return errors.New(resp.Status)
+	}
+	return fmt.Errorf("%s: %s", resp.Status, msg)
+}
+
+/* Returns the request of method on the object at location, signed with the credentials of its
+service. A body of size bytes is sent with PUT. */
+func remoteRequest(method string, location string, body io.Reader, size int64) (*http.Request, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		return s3Request(method, u.Host, key, nil, body, size)
+	case "gs":
+		token, err := gcsToken()
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(method, "https://storage.googleapis.com/"+u.Host+"/"+escapePath(key), body)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = size
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req, nil
+	case "http", "https":
+		return http.NewRequest(method, location, body)
+	}
+	return nil, fmt.Errorf("unknown location %s", location)
+}
+
+/* Returns the path with every segment escaped as RFC 3986 asks, the form AWS signs. */
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		var b strings.Builder
+		for _, c := range []byte(s) {
+			if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		segments[i] = b.String()
+	}
+	return strings.Join(segments, "/")
+}
+
+
+/* Credentials of an AWS account or role */
+type awsCredentials struct {
+	AccessKeyId	string
+	SecretAccessKey	string
+	Token		string
+	Expiration	time.Time	// zero for keys that do not expire
+}
+
+/* Returns the request to the S3 object key of bucket, with the query of the multipart upload calls,
+signed with AWS Signature Version 4. The endpoint is that of the region in AWS_REGION, or
+AWS_ENDPOINT_URL for storage speaking the S3 API, which is addressed by path. */
+func s3Request(method string, bucket string, key string, query url.Values, body io.Reader, size int64) (*http.Request, error) {
+	creds, err := awsCredentialChain()
+	if err != nil {
+		return nil, err
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	target := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, escapePath(key))
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		target = strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/" + escapePath(key)
+	}
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+	signV4(req, creds, region, "s3", "UNSIGNED-PAYLOAD", time.Now())
+	return req, nil
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+/* Signs the request for service in region with AWS Signature Version 4, covering the host and every
+X-Amz header, with payload the hex SHA-256 of the body or UNSIGNED-PAYLOAD. */
+func signV4(req *http.Request, creds awsCredentials, region string, service string, payload string, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", stamp)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if n := strings.ToLower(name); strings.HasPrefix(n, "x-amz-") {
+			headers[n] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for n := range headers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, n := range names {
+		canonical.WriteString(n + ":" + headers[n] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, escapePath(k)+"="+escapePath(v))
+		}
+	}
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	request := strings.Join([]string{req.Method, uri, strings.Join(params, "&"), canonical.String(), signed, payload}, "\n")
+	hash := sha256.Sum256([]byte(request))
+
+	scope := stamp[:8] + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), stamp[:8])
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyId, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+/* AWS credentials, looked up once per run */
+var aws struct {
+	sync.Mutex
+	creds	awsCredentials
+}
+
+/* Returns the AWS credentials found first, in the order of the AWS SDKs: the environment, the shared
+credentials file for the profile in AWS_PROFILE, the task role of a container and the role of an EC2
+instance. The ones found are kept for the run, or until shortly before the temporary credentials of
+a role expire, so the parts of an upload do not ask the metadata service again. */
+func awsCredentialChain() (awsCredentials, error) {
+	aws.Lock()
+	defer aws.Unlock()
+	if aws.creds.AccessKeyId != "" && (aws.creds.Expiration.IsZero() || time.Until(aws.creds.Expiration) > 5*time.Minute) {
+		return aws.creds, nil
+	}
+	creds, err := awsLookupCredentials()
+	if err == nil {
+		aws.creds = creds
+	}
+	return creds, err
+}
+
+/* Returns the AWS credentials found first, looking them up in the order of awsCredentialChain. */
+func awsLookupCredentials() (awsCredentials, error) {
+	creds := awsCredentials{AccessKeyId: os.Getenv("AWS_ACCESS_KEY_ID"), SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Token: os.Getenv("AWS_SESSION_TOKEN")}
+	if creds.AccessKeyId != "" && creds.SecretAccessKey != "" {
+		return creds, nil
+	}
+	if c, ok := awsSharedCredentials(); ok {
+		return c, nil
+	}
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return awsRoleCredentials("http://169.254.170.2"+uri, nil)
+	} else if full := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); full != "" {
+		return awsRoleCredentials(full, map[string]string{"Authorization": os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")})
+	}
+	return awsInstanceCredentials()
+}
+
+/* Returns the keys of the profile in the shared credentials file, if it has them. */
+func awsSharedCredentials() (awsCredentials, bool) {
+	name := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if name == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return awsCredentials{}, false
+		}
+		name = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return awsCredentials{}, false
+	}
+	defer file.Close()
+	var creds awsCredentials
+	section := ""
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "aws_access_key_id":
+			creds.AccessKeyId = strings.TrimSpace(v)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(v)
+		case "aws_session_token":
+			creds.Token = strings.TrimSpace(v)
+		}
+	}
+	return creds, creds.AccessKeyId != "" && creds.SecretAccessKey != ""
+}
+
+/* Returns the temporary credentials of a role served as JSON at location. */
+func awsRoleCredentials(location string, headers map[string]string) (awsCredentials, error) {
+	var creds awsCredentials
+	req, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return creds, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return creds, fmt.Errorf("no AWS credentials: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return creds, fmt.Errorf("no AWS credentials: %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&creds)
+	return creds, err
+}
+
+/* Returns the credentials of the role of the EC2 instance from its metadata service, version 2. */
+func awsInstanceCredentials() (awsCredentials, error) {
+	const imds = "http://169.254.169.254/latest/"
+	client := &http.Client{Timeout: 2 * time.Second}
+	req, _ := http.NewRequest(http.MethodPut, imds+"api/token", nil)
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "300")
+	resp, err := client.Do(req)
+	if err != nil {
+		return awsCredentials{}, errors.New("no AWS credentials in the environment, the shared credentials file or the instance metadata")
+	}
+	token, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	headers := map[string]string{"X-Aws-Ec2-Metadata-Token": string(token)}
+
+	req, _ = http.NewRequest(http.MethodGet, imds+"meta-data/iam/security-credentials/", nil)
+	req.Header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+	resp, err = client.Do(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	role, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials: the instance has no role")
+	}
+	name := strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0])
+	return awsRoleCredentials(imds+"meta-data/iam/security-credentials/"+name, headers)
+}
+
+
+/* Access token for Cloud Storage, fetched once per run */
+var gcs struct {
+	sync.Mutex
+	token	string
+}
+
+/* Returns an access token for Cloud Storage found as Application Default Credentials are: a token in
+GOOGLE_OAUTH_ACCESS_TOKEN, the credentials file in GOOGLE_APPLICATION_CREDENTIALS or the one gcloud
+auth application-default login writes, and the service account of the machine from its metadata server. */
+func gcsToken() (string, error) {
+	gcs.Lock()
+	defer gcs.Unlock()
+	if gcs.token != "" {
+		return gcs.token, nil
+	}
+	token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	var err error
+	if token == "" {
+		name := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		if name == "" {
+			if dir, e := os.UserConfigDir(); e == nil {
+				name = filepath.Join(dir, "gcloud", "application_default_credentials.json")
+			}
+		}
+		if b, e := os.ReadFile(name); e == nil {
+			token, err = gcsFileToken(b)
+		} else {
+			token, err = gcsMetadataToken()
+		}
+	}
+	gcs.token = token
+	return token, err
+}
+
+/* The fields of the credentials files of service accounts and of users */
+type gcsCredentials struct {
+	Type		string	`json:"type"`
+	ClientEmail	string	`json:"client_email"`
+	PrivateKey	string	`json:"private_key"`
+	TokenURI	string	`json:"token_uri"`
+	ClientID	string	`json:"client_id"`
+	ClientSecret	string	`json:"client_secret"`
+	RefreshToken	string	`json:"refresh_token"`
+}
+
+/* Returns an access token for the credentials file b: for a service account by a JWT signed with its
+key, for a user by the refresh token. */
+func gcsFileToken(b []byte) (string, error) {
+	var c gcsCredentials
+	err := json.Unmarshal(b, &c)
+	if err != nil {
+		return "", err
+	}
+	if c.TokenURI == "" {
+		c.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	form := url.Values{}
+	switch c.Type {
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+		form.Set("refresh_token", c.RefreshToken)
+	case "service_account":
+		block, _ := pem.Decode([]byte(c.PrivateKey))
+		if block == nil {
+			return "", errors.New("no private key in the service account credentials")
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return "", err
+		}
+		key, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return "", errors.New("the service account key is no RSA key")
+		}
+		now := time.Now().Unix()
+		enc := base64.RawURLEncoding
+		header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+		claims, _ := json.Marshal(map[string]interface{}{"iss": c.ClientEmail, "aud": c.TokenURI, "iat": now,
+			"exp": now + 3600, "scope": "https://www.googleapis.com/auth/devstorage.read_write"})
+		unsigned := header + "." + enc.EncodeToString(claims)
+		hash := sha256.Sum256([]byte(unsigned))
+		sig, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, hash[:])
+		if err != nil {
+			return "", err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", unsigned+"."+enc.EncodeToString(sig))
+	default:
+		return "", fmt.Errorf("unknown type of Google credentials %q", c.Type)
+	}
+	resp, err := http.PostForm(c.TokenURI, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	return gcsAccessToken(resp)
+}
+
+/* Returns the token of the service account of the machine from the metadata server. */
+func gcsMetadataToken() (string, error) {
+	req, _ := http.NewRequest(http.MethodGet, "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	req.Header.Set("Metadata-Flavor", "Google")
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.New("no Google credentials in the environment, a credentials file or the metadata server")
+	}
+	defer resp.Body.Close()
+	return gcsAccessToken(resp)
+}
+
+func gcsAccessToken(resp *http.Response) (string, error) {
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("no Google access token: %v", remoteStatus(resp))
+	}
+	var t struct {
+		AccessToken	string	`json:"access_token"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&t)
+	return t.AccessToken, err
+}
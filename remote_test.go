@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+
+/* Requests of the AWS Signature Version 4 test suite, signed with its example credentials, give the
+signatures the suite lists. */
+func TestSignV4(t *testing.T) {
+	creds := awsCredentials{AccessKeyId: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	empty := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	vectors := []struct {
+		name		string
+		method		string
+		url		string
+		signature	string
+	}{
+		{"get-vanilla", "GET", "https://example.amazonaws.com/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query", "GET", "https://example.amazonaws.com/?", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"post-vanilla", "POST", "https://example.amazonaws.com/", "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{"get-vanilla-empty-query-key", "GET", "https://example.amazonaws.com/?Param1=value1", "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb"},
+		{"get-vanilla-query-order-key-case", "GET", "https://example.amazonaws.com/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{"get-utf8", "GET", "https://example.amazonaws.com/ሴ", "8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85"},
+	}
+	for _, v := range vectors {
+		req, err := http.NewRequest(v.method, v.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signV4(req, creds, "us-east-1", "service", empty, now)
+		want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+			"SignedHeaders=host;x-amz-date, Signature=" + v.signature
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("%s is signed\n%s\nwant\n%s", v.name, got, want)
+		}
+		if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+			t.Errorf("%s has the date %s", v.name, got)
+		}
+	}
+}
+
+/* A session token is signed along with the request. */
+func TestSignV4Token(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://bucket.s3.amazonaws.com/survey.xml", nil)
+	req.Header.Set("X-Amz-Security-Token", "token")
+	signV4(req, awsCredentials{AccessKeyId: "AKID", SecretAccessKey: "secret", Token: "token"}, "eu-west-1", "s3", "UNSIGNED-PAYLOAD", time.Now())
+	if got := req.Header.Get("Authorization"); !strings.Contains(got, "SignedHeaders=host;x-amz-date;x-amz-security-token,") {
+		t.Errorf("signed %s", got)
+	}
+}
+
+/* Serves the S3 calls of single and multipart uploads to a bucket, failing the upload of part fail. */
+type fakeS3 struct {
+	sync.Mutex
+	objects	map[string]string
+	parts	map[int]string
+	aborted	bool
+	fail	int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		http.Error(w, "<Error><Message>Unsigned</Message></Error>", http.StatusForbidden)
+		return
+	}
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>")
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		var n int
+		fmt.Sscan(query.Get("partNumber"), &n)
+		if n == f.fail || query.Get("uploadId") != "upload-1" {
+			http.Error(w, "<Error><Message>Part refused</Message></Error>", http.StatusInternalServerError)
+			return
+		}
+		f.parts[n] = string(body)
+		w.Header().Set("ETag", fmt.Sprintf("\"etag-%d\"", n))
+	case r.Method == http.MethodPost && query.Get("uploadId") == "upload-1":
+		var parts s3Parts
+		xml.Unmarshal(body, &parts)
+		var object strings.Builder
+		for i, p := range parts.Part {
+			if p.PartNumber != i+1 || p.ETag != fmt.Sprintf("\"etag-%d\"", i+1) {
+				fmt.Fprint(w, "<Error><Message>Invalid part</Message></Error>")
+				return
+			}
+			object.WriteString(f.parts[p.PartNumber])
+		}
+		f.objects[r.URL.Path] = object.String()
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && query.Get("uploadId") == "upload-1":
+		f.aborted = true
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && len(query) == 0:
+		f.objects[r.URL.Path] = string(body)
+	default:
+		http.Error(w, "<Error><Message>Unexpected call</Message></Error>", http.StatusBadRequest)
+	}
+}
+
+/* Files larger than a part go up to S3 as a multipart upload, smaller ones in a single PUT, and a
+multipart upload that fails is aborted. */
+func TestUploadS3(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string]string), parts: make(map[int]string)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	defer func(part int64) { uploadPart = part }(uploadPart)
+	uploadPart = 4
+
+	dir := t.TempDir()
+	for _, content := range []string{"tiny", "a file in parts"} {
+		name := filepath.Join(dir, "out.sav")
+		err := os.WriteFile(name, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Upload(name, "s3://bucket/surveys/out.sav")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fake.objects["/bucket/surveys/out.sav"]; got != content {
+			t.Errorf("uploaded %q, want %q", got, content)
+		}
+	}
+	if len(fake.parts) != 4 {
+		t.Errorf("uploaded %d parts, want 4", len(fake.parts))
+	}
+
+	fake.fail = 2
+	name := filepath.Join(dir, "out.parquet")
+	os.WriteFile(name, []byte("a failing upload"), 0644)
+	err := Upload(name, "s3://bucket/surveys/out.parquet")
+	if err == nil || !strings.Contains(err.Error(), "Part refused") {
+		t.Errorf("upload failed with %v, want the part refused", err)
+	}
+	if !fake.aborted {
+		t.Error("the failed upload was not aborted")
+	}
+}
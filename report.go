@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+
+/* Counts the values of a variable over the cases of the data file */
+type tally struct {
+	cols	[]Column		// columns of the variable, worked out once for all records
+	codes	map[int]int		// cases per code, for multiples cases mentioning it
+	other	map[string]int		// cases per value that is no labelled code
+	missing	int
+	n	int
+	sum	float64
+	min	float64
+	max	float64
+}
+
+func newTally(v Variable) *tally {
+	return &tally{cols: v.Columns(), codes: make(map[int]int), other: make(map[string]int), min: math.Inf(1),
+		max: math.Inf(-1)}
+}
+
+/* Counts the values of a variable in a record. */
+func (t *tally) add(v Variable, rec string) {
+	switch v.Type {
+	case "multiple":
+		seen := make(map[int]bool)
+		for i, c := range t.cols {
+			value := CleanField(c, rec)
+			if !v.CountCoded() && value == "1" {
+				seen[v.Vals[i].Value] = true
+			} else if code, err := strconv.Atoi(value); v.CountCoded() && err == nil && code != 0 {
+				seen[code] = true
+			}
+		}
+		if len(seen) == 0 {
+			t.missing++
+		}
+		for code := range seen {
+			t.codes[code]++
+		}
+	case "single", "logical":
+		value := CleanField(t.cols[0], rec)
+		if value == "" {
+			t.missing++
+		} else if code, err := strconv.Atoi(value); err == nil {
+			t.codes[code]++
+		} else if _, ok := t.other[value]; ok {
+			t.other[value]++
+		} else {
+			t.other[strings.Clone(value)] = 1
+		}
+	case "quantity":
+		f, err := strconv.ParseFloat(CleanField(t.cols[0], rec), 64)
+		if err != nil {
+			t.missing++
+			return
+		}
+		t.n++
+		t.sum += f
+		t.min, t.max = math.Min(t.min, f), math.Max(t.max, f)
+	}
+}
+
+/* Writes the frequencies of the single, multiple and logical variables and the minimum, maximum
+and mean of the quantities, read straight from the data file. Percentages are of all cases. */
+func Frequencies(asc string, w io.Writer, d *Variables) error {
+	tallies := make([]*tally, len(d.Variable))
+	for i, v := range d.Variable {
+		tallies[i] = newTally(v)
+	}
+	cases := 0
+	err := ReadRecords(asc, func(n int, rec string) error {
+		for i, v := range d.Variable {
+			tallies[i].add(v, rec)
+		}
+		cases = n
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	percent := func(n int) string {
+		if cases == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(cases))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "%d cases\n", cases)
+	for i, v := range d.Variable {
+		t := tallies[i]
+		switch v.Type {
+		case "single", "multiple", "logical":
+			fmt.Fprintf(tw, "\n%s\t%s (%s)\n", v.Name, v.Label, v.Type)
+			labels := v.Vals
+			if v.Type == "logical" {
+				labels = v.ColumnLabels(0)
+			}
+			labelled := make(map[int]bool)
+			for _, l := range labels {
+				labelled[l.Value] = true
+				fmt.Fprintf(tw, "\t%d\t%s\t%d\t%s\n", l.Value, l.Name, t.codes[l.Value], percent(t.codes[l.Value]))
+			}
+			var others []string
+			for code, n := range t.codes {
+				if !labelled[code] {
+					t.other[strconv.Itoa(code)] += n
+				}
+			}
+			for value := range t.other {
+				others = append(others, value)
+			}
+			sort.Strings(others)
+			for _, value := range others {
+				fmt.Fprintf(tw, "\t%s\t(no label)\t%d\t%s\n", value, t.other[value], percent(t.other[value]))
+			}
+			none := "missing"
+			if v.Type == "multiple" {
+				none = "none"
+			}
+			fmt.Fprintf(tw, "\t\t%s\t%d\t%s\n", none, t.missing, percent(t.missing))
+		case "quantity":
+			fmt.Fprintf(tw, "\n%s\t%s (%s)\n", v.Name, v.Label, v.Type)
+			fmt.Fprintf(tw, "\t\tvalid\t%d\t%s\n", t.n, percent(t.n))
+			fmt.Fprintf(tw, "\t\tmissing\t%d\t%s\n", t.missing, percent(t.missing))
+			if t.n > 0 {
+				dec := v.Decimals()
+				fmt.Fprintf(tw, "\t\tminimum\t%s\n", strconv.FormatFloat(t.min, 'f', dec, 64))
+				fmt.Fprintf(tw, "\t\tmaximum\t%s\n", strconv.FormatFloat(t.max, 'f', dec, 64))
+				fmt.Fprintf(tw, "\t\tmean\t%s\n", strconv.FormatFloat(t.sum/float64(t.n), 'f', dec+2, 64))
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+/* Runs the frequencies command printing the report of the data file. */
+func FrequenciesCommand(args []string) error {
+	fs := flag.NewFlagSet("frequencies", flag.ExitOnError)
+	fs.IntVar(sample, "sample", 0, "count only the first n records")
+	paths := parseArgs(fs, args)
+	if len(paths) < 2 {
+		return UsageError("Usage: XMLtoSPS frequencies [-sample n] <XML:filepath> <ASC:filepath>")
+	}
+	d, err := ReadMetadata(paths[0])
+	if err != nil {
+		return err
+	}
+	return Frequencies(paths[1], os.Stdout, d)
+}
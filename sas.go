@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+
+/* Limits of SAS names and labels */
+const (
+	sasName = 32
+	sasLabel = 256
+)
+
+/* Returns s as a single quoted SAS string, which keeps the macro processor from resolving & and %. */
+func sasQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+/* Returns unique SAS names of the columns and the names of their value label formats, which may not end in a digit. */
+func sasNames(cols []Column) ([]string, []string) {
+	names, formats := make([]string, len(cols)), make([]string, len(cols))
+	for i, c := range cols {
+		names[i], formats[i] = Identifier(c.Name, sasName), Identifier(c.Name, sasName-1)
+	}
+	formats = uniqueNames(formats, sasName-1)
+	for i := range formats {
+		formats[i] += "F"
+	}
+	return uniqueNames(names, sasName), formats
+}
+
+/* Writes a SAS program reading the fixed-width data file with column input, labelling the variables
+and attaching PROC FORMAT value labels, selectable with -to sas. */
+func WriteSas(asc string, out string, d *Variables) error {
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	var cols []Column
+	lrecl := 1
+	for _, v := range d.Variable {
+		cols = append(cols, v.Columns()...)
+		if v.Position.Finish > lrecl {
+			lrecl = v.Position.Finish
+		}
+	}
+	names, formats := sasNames(cols)
+
+	fmt.Fprint(w, "PROC FORMAT;\n")
+	for i, c := range cols {
+		if len(c.Labels) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\tVALUE %s\n", formats[i])
+		for _, l := range c.Labels {
+			label, _ := Truncate(l.Name, sasLabel, false)
+			fmt.Fprintf(w, "\t\t%d = %s\n", l.Value, sasQuote(label))
+		}
+		fmt.Fprint(w, "\t;\n")
+	}
+	fmt.Fprint(w, "RUN;\n\n")
+
+	name := d.Name
+	if name == "" {
+		name = "survey"
+	}
+	fmt.Fprintf(w, "DATA %s", Identifier(name, sasName))
+	if d.Title != "" {
+		label, _ := Truncate(d.Title, sasLabel, false)
+		fmt.Fprintf(w, " (LABEL=%s)", sasQuote(label))
+	}
+	fmt.Fprintf(w, ";\n\tINFILE %s LRECL=%d TRUNCOVER;\n\tINPUT\n", sasQuote(asc), lrecl)
+	for i, c := range cols {
+		if c.Print == "" {
+			fmt.Fprintf(w, "\t\t%s $ %d-%d\n", names[i], c.Start, c.Finish)
+		} else if c.Implied > 0 {
+			fmt.Fprintf(w, "\t\t%s %d-%d .%d\n", names[i], c.Start, c.Finish, c.Implied)
+		} else {
+			fmt.Fprintf(w, "\t\t%s %d-%d\n", names[i], c.Start, c.Finish)
+		}
+	}
+	fmt.Fprint(w, "\t;\n\tLABEL\n")
+	for i, c := range cols {
+		label, _ := Truncate(c.Label, sasLabel, false)
+		fmt.Fprintf(w, "\t\t%s = %s\n", names[i], sasQuote(label))
+	}
+	fmt.Fprint(w, "\t;\n\tFORMAT\n")
+	for i, c := range cols {
+		if len(c.Labels) > 0 {
+			fmt.Fprintf(w, "\t\t%s %s.\n", names[i], formats[i])
+		} else if c.Print != "" {
+			fmt.Fprintf(w, "\t\t%s %s\n", names[i], strings.TrimPrefix(c.Print, "F"))
+		}
+	}
+	fmt.Fprint(w, "\t;\nRUN;\n")
+	return w.Flush()
+}
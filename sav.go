@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+
+/* Codes of the bytecode compression used for the cases of a system file */
+const (
+	savBias		= 100
+	savEOF		= 252
+	savRaw		= 253
+	savSpaces	= 254
+	savSysmis	= 255
+)
+
+/* Print format type codes of a system file */
+var savFormatTypes = map[string]int32{"A": 1, "F": 5}
+
+/* A variable of the system file dictionary. Every SPSS column of the Triple-S file becomes one. */
+type SavVariable struct {
+	Name		string
+	Short		string
+	Label		string
+	Width		int		// 0 for numeric variables
+	Format		string		// print and write format
+	Start		int
+	Finish		int
+	Measure		int32		// 1 nominal, 3 scale
+	Implied		int		// decimals implied in numbers written without a decimal point
+	Overpunch	bool		// the last digit of numbers may carry the sign as a COBOL overpunch
+	Labels		[]Val
+}
+
+/* Returns the number of 8 byte segments the variable takes up in a case. */
+func (sv SavVariable) Segments() int {
+	if sv.Width == 0 {
+		return 1
+	}
+	return (sv.Width + 7) / 8
+}
+
+/* Builds the system file dictionary from the Triple-S variables.
+Strings wider than MaxShortString are cut, the system file writer does not split very long strings. */
+func SavVariables(d *Variables) []SavVariable {
+	var vars []SavVariable
+	for _, v := range d.Variable {
+		for _, c := range v.Columns() {
+			sv := SavVariable{Name: c.Name, Label: c.Label, Start: c.Start, Finish: c.Finish, Measure: 1,
+				Format: c.Print, Labels: c.Labels, Implied: c.Implied, Overpunch: c.Overpunch}
+			if c.Print == "" {
+				sv.Width = c.Finish - c.Start + 1
+				if sv.Width > MaxShortString {
+					sv.Width = MaxShortString
+				}
+				sv.Format = fmt.Sprintf("A%d", sv.Width)
+			} else if c.Type == "quantity" {
+				sv.Measure = 3
+			}
+			vars = append(vars, sv)
+		}
+	}
+	assignShortNames(vars)
+	return vars
+}
+
+/* Gives every variable a unique upper case name of at most 8 bytes, the dictionary records cannot
+hold more. The full names are kept in the long variable names record. */
+func assignShortNames(vars []SavVariable) {
+	used := make(map[string]bool)
+	for i := range vars {
+		base := strings.ToUpper(vars[i].Name)
+		short := cutBytes(base, 8)
+		for n := 1; used[short]; n++ {
+			suffix := strconv.Itoa(n)
+			short = cutBytes(base, 8-len(suffix)) + suffix
+		}
+		used[short] = true
+		vars[i].Short = short
+	}
+}
+
+/* Cuts s to at most n bytes without splitting a UTF-8 sequence. */
+func cutBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+/* Returns the system file encoding of a print format like F6.2 or A10. */
+func savFormat(format string) int32 {
+	i := strings.IndexAny(format, "0123456789")
+	w, dec := format[i:], "0"
+	if j := strings.Index(w, "."); j >= 0 {
+		w, dec = w[:j], w[j+1:]
+	}
+	width, _ := strconv.Atoi(w)
+	decimals, _ := strconv.Atoi(dec)
+	return savFormatTypes[format[:i]]<<16 | int32(width)<<8 | int32(decimals)
+}
+
+
+/* Writes the little-endian records of a system file and remembers the first error. */
+type savWriter struct {
+	w	*bufio.Writer
+	err	error
+}
+
+func (s *savWriter) put(v interface{}) {
+	if s.err == nil {
+		s.err = binary.Write(s.w, binary.LittleEndian, v)
+	}
+}
+
+/* Writes str padded with spaces, or cut, to exactly n bytes. */
+func (s *savWriter) text(str string, n int) {
+	b := []byte(cutBytes(str, n))
+	for len(b) < n {
+		b = append(b, ' ')
+	}
+	s.put(b)
+}
+
+/* Writes str preceded by its length and padded with spaces to a multiple of pad bytes,
+the length itself taking up prefix bytes. */
+func (s *savWriter) counted(str string, prefix int, pad int) {
+	n := prefix + len(str)
+	if n%pad != 0 {
+		n += pad - n%pad
+	}
+	if prefix == 1 {
+		s.put(uint8(len(str)))
+	} else {
+		s.put(int32(len(str)))
+	}
+	s.text(str, n-prefix)
+}
+
+/* Writes an extension record holding count elements of size bytes. */
+func (s *savWriter) extension(subtype int32, size int32, count int32) {
+	s.put([]int32{7, subtype, size, count})
+}
+
+
+/* Collects the bytecode compressed cases, 8 instruction bytes followed by the data they refer to. */
+type savCompressor struct {
+	w	*savWriter
+	codes	[8]byte
+	n	int
+	data	[]byte
+}
+
+func (c *savCompressor) code(b byte, raw []byte) {
+	c.codes[c.n] = b
+	c.n++
+	c.data = append(c.data, raw...)
+	if c.n == len(c.codes) {
+		c.flush()
+	}
+}
+
+func (c *savCompressor) flush() {
+	for i := c.n; i < len(c.codes); i++ {
+		c.codes[i] = 0
+	}
+	c.w.put(c.codes[:])
+	c.w.put(c.data)
+	c.n, c.data = 0, c.data[:0]
+}
+
+/* Compresses a numeric value, blanks and content that is not a number become system missing. */
+func (c *savCompressor) number(field string) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+	if err != nil {
+		c.code(savSysmis, nil)
+	} else if f == math.Trunc(f) && f >= 1-savBias && f <= savEOF-1-savBias {
+		c.code(byte(f+savBias), nil)
+	} else {
+		raw := make([]byte, 8)
+		binary.LittleEndian.PutUint64(raw, math.Float64bits(f))
+		c.code(savRaw, raw)
+	}
+}
+
+/* Compresses a string value padded to the segments of its variable. */
+func (c *savCompressor) str(field string, segments int) {
+	b := []byte(field)
+	for len(b) < segments*8 {
+		b = append(b, ' ')
+	}
+	for i := 0; i < segments; i++ {
+		chunk := b[i*8 : i*8+8]
+		if strings.TrimRight(string(chunk), " ") == "" {
+			c.code(savSpaces, nil)
+		} else {
+			c.code(savRaw, chunk)
+		}
+	}
+}
+
+func (c *savCompressor) end() {
+	c.code(savEOF, nil)
+	if c.n > 0 {
+		c.flush()
+	}
+}
+
+
+/* Writes an SPSS system file with the dictionary of the Triple-S metadata and the cases of the data file.
+label and docs become the file label and documents, weight names the weight variable, if any, and created
+is stored as the creation time. */
+func WriteSav(asc string, out string, label string, docs []string, created time.Time, weight string, d *Variables) error {
+	vars := SavVariables(d)
+	var cut []string
+	for _, v := range d.Variable {
+		if v.Format() == "" && v.Width() > MaxShortString {
+			cut = append(cut, v.Name)
+		}
+	}
+	if len(cut) > 0 {
+		Warn(fmt.Sprintf("Character fields wider than %d bytes are cut in the .sav for %s, the syntax reads them whole", MaxShortString, strings.Join(cut, ", ")))
+	}
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	s := &savWriter{w: bufio.NewWriter(file)}
+
+	segments, weightIndex := 0, 0
+	for _, sv := range vars {
+		if sv.Name == weight && sv.Width == 0 {
+			weightIndex = segments + 1
+		}
+		segments += sv.Segments()
+	}
+
+	s.put([]byte("$FL2"))
+	s.text("@(#) SPSS DATA FILE xmltosps", 60)
+	s.put([]int32{2, int32(segments), 1, int32(weightIndex), -1})
+	s.put(float64(savBias))
+	s.text(created.Format("02 Jan 06"), 9)
+	s.text(created.Format("15:04:05"), 8)
+	s.text(label, 64)
+	s.put([]byte{0, 0, 0})
+
+	index := make([]int, len(vars))
+	n := 1
+	for i, sv := range vars {
+		index[i] = n
+		n += sv.Segments()
+		hasLabel := int32(0)
+		if sv.Label != "" {
+			hasLabel = 1
+		}
+		f := savFormat(sv.Format)
+		s.put([]int32{2, int32(sv.Width), hasLabel, 0, f, f})
+		s.text(sv.Short, 8)
+		if hasLabel == 1 {
+			s.counted(sv.Label, 4, 4)
+		}
+		for k := 1; k < sv.Segments(); k++ {
+			s.put([]int32{2, -1, 0, 0, 0, 0})
+			s.text("", 8)
+		}
+	}
+
+	for i, sv := range vars {
+		if len(sv.Labels) == 0 || sv.Width != 0 {
+			continue
+		}
+		s.put([]int32{3, int32(len(sv.Labels))})
+		for _, l := range sv.Labels {
+			s.put(float64(l.Value))
+			s.counted(cutBytes(l.Name, 255), 1, 8)
+		}
+		s.put([]int32{4, 1, int32(index[i])})
+	}
+
+	if len(docs) > 0 {
+		s.put([]int32{6, int32(len(docs))})
+		for _, l := range docs {
+			s.text(l, 80)
+		}
+	}
+
+	s.extension(3, 4, 8)
+	s.put([]int32{1, 0, 0, -1, 1, 1, 2, 65001})
+	s.extension(4, 8, 3)
+	s.put([]float64{-math.MaxFloat64, math.MaxFloat64, math.Nextafter(-math.MaxFloat64, 0)})
+
+	s.extension(11, 4, int32(3*len(vars)))
+	for _, sv := range vars {
+		width, align := int32(sv.Width), int32(0)
+		if sv.Width == 0 {
+			width, align = int32(savFormat(sv.Format)>>8&0xff), 1
+		}
+		s.put([]int32{sv.Measure, width, align})
+	}
+
+	names := make([]string, len(vars))
+	for i, sv := range vars {
+		names[i] = sv.Short + "=" + sv.Name
+	}
+	long := strings.Join(names, "\t")
+	s.extension(13, 1, int32(len(long)))
+	s.put([]byte(long))
+	s.extension(20, 1, int32(len("UTF-8")))
+	s.put([]byte("UTF-8"))
+	s.put([]int32{999, 0})
+
+	c := &savCompressor{w: s}
+	cases := 0
+	err = ReadRecords(asc, func(n int, rec string) error {
+		for _, sv := range vars {
+			if sv.Width == 0 {
+				field := Field(rec, sv.Start, sv.Finish)
+				if sv.Overpunch {
+					field = Unpunch(field)
+				}
+				c.number(ImplyDecimals(field, sv.Implied))
+			} else {
+				c.str(Field(rec, sv.Start, sv.Start+sv.Width-1), sv.Segments())
+			}
+		}
+		cases++
+		return s.err
+	})
+	if err != nil {
+		return err
+	}
+	c.end()
+	if s.err != nil {
+		return s.err
+	}
+	err = s.w.Flush()
+	if err != nil {
+		return err
+	}
+	ncases := make([]byte, 4)
+	binary.LittleEndian.PutUint32(ncases, uint32(cases))
+	_, err = file.WriteAt(ncases, 80)
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+
+/* The header of the system file holds its fields at the offsets SPSS reads them from. */
+func TestWriteSavHeader(t *testing.T) {
+	d, asc := testData(t)
+	out := filepath.Join(t.TempDir(), "test.sav")
+	created := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	err := WriteSav(asc, out, "Test survey", nil, created, "WT", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+	checks := []struct {
+		name	string
+		got	interface{}
+		want	interface{}
+	}{
+		{"magic", string(b[0:4]), "$FL2"},
+		{"product", string(b[4:23]), "@(#) SPSS DATA FILE"},
+		{"layout code", le.Uint32(b[64:]), uint32(2)},
+		{"nominal case size", le.Uint32(b[68:]), uint32(8)},
+		{"compression", le.Uint32(b[72:]), uint32(1)},
+		{"weight index", le.Uint32(b[76:]), uint32(6)},
+		{"cases", le.Uint32(b[80:]), uint32(len(testRecords))},
+		{"bias", math.Float64frombits(le.Uint64(b[84:])), 100.0},
+		{"creation date", string(b[92:101]), "02 Jan 26"},
+		{"creation time", string(b[101:109]), "15:04:05"},
+		{"file label", string(b[109:120]), "Test survey"},
+		{"first record type", le.Uint32(b[176:]), uint32(2)},
+		{"first variable width", le.Uint32(b[180:]), uint32(4)},
+		{"first variable name", string(b[200:208]), "ID      "},
+	}
+	for _, c := range checks {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s is %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+/* The system file reads back with the variables, labels and values it was written with. */
+func TestWriteSavRoundTrip(t *testing.T) {
+	d, asc := testData(t)
+	out := filepath.Join(t.TempDir(), "test.sav")
+	err := WriteSav(asc, out, "Test survey", []string{"A document"}, time.Now(), "WT", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenSav(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range s.Fields {
+		names = append(names, f.Name)
+	}
+	if want := testColumns(d); !reflect.DeepEqual(names, want) {
+		t.Fatalf("variables are %v, want %v", names, want)
+	}
+	if s.Label != "Test survey" || s.Weight != 5 {
+		t.Errorf("label %q and weight %d, want Test survey and 5", s.Label, s.Weight)
+	}
+	if q1 := s.Fields[1]; q1.Label != "Gender" || !reflect.DeepEqual(q1.Labels, []SavLabel{{1, "Male"}, {2, "Female"}}) {
+		t.Errorf("Q1 has the label %q and value labels %v", q1.Label, q1.Labels)
+	}
+	want := [][]string{
+		{"0001", "1", "1", "0", "1", "1.500", "hello"},
+		{"0002", "2", "0", "1", "0", "2.250", ""},
+		{"0003", "", "0", "0", "0", "", ""},
+	}
+	var got [][]string
+	err = s.Cases(func(slots []byte) error {
+		values := make([]string, len(s.Fields))
+		for i, f := range s.Fields {
+			values[i] = f.Text(slots, binary.LittleEndian)
+		}
+		got = append(got, values)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cases are %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+
+/* Print format types of a system file holding dates and times of day */
+var savDateFormats = map[int32]bool{20: true, 23: true, 24: true, 38: true, 39: true}
+
+const savTimeFormat = 21
+
+/* The origin of SPSS dates, which count seconds from the start of the Gregorian calendar */
+var savEpoch = time.Date(1582, 10, 14, 0, 0, 0, 0, time.UTC)
+
+/* A variable of a system file dictionary as read back */
+type SavField struct {
+	Name		string
+	Label		string
+	Width		int		// 0 for numeric variables
+	Print		int32		// print format: type, width and decimals
+	Slot		int		// first 8 byte segment in a case
+	Labels		[]SavLabel
+}
+
+type SavLabel struct {
+	Value		float64
+	Label		string
+}
+
+/* Returns the number of decimals of the print format. */
+func (sf SavField) Decimals() int {
+	return int(sf.Print & 0xff)
+}
+
+/* Returns the Triple-S type of the variable; numbers with integer value labels are single coded. */
+func (sf SavField) Type() string {
+	switch {
+	case sf.Width > 0:
+		return "character"
+	case savDateFormats[sf.Print>>16]:
+		return "date"
+	case sf.Print>>16 == savTimeFormat:
+		return "time"
+	case len(sf.Labels) > 0 && sf.Decimals() == 0:
+		for _, l := range sf.Labels {
+			if l.Value != math.Trunc(l.Value) || l.Value < 0 {
+				return "quantity"
+			}
+		}
+		return "single"
+	}
+	return "quantity"
+}
+
+/* Returns the value of the variable in a case as Triple-S writes it, blank for system missing. */
+func (sf SavField) Text(slots []byte, order binary.ByteOrder) string {
+	if sf.Width > 0 {
+		b := slots[sf.Slot*8 : sf.Slot*8+sf.Width]
+		return strings.TrimRight(string(b), " ")
+	}
+	f := math.Float64frombits(order.Uint64(slots[sf.Slot*8:]))
+	if f == -math.MaxFloat64 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return ""
+	}
+	switch sf.Type() {
+	case "date":
+		return time.Unix(savEpoch.Unix()+int64(f), 0).UTC().Format("20060102")
+	case "time":
+		s := int64(f)
+		return fmt.Sprintf("%02d%02d%02d", s/3600, s/60%60, s%60)
+	}
+	return strconv.FormatFloat(f, 'f', sf.Decimals(), 64)
+}
+
+
+/* Reads the dictionary and cases of an uncompressed or bytecode compressed SPSS system file. */
+type SavReader struct {
+	Product		string
+	Label		string
+	Fields		[]SavField
+	Weight		int		// index of the weight variable in Fields, -1 without one
+	r		*bufio.Reader
+	file		*os.File
+	order		binary.ByteOrder
+	compressed	bool
+	bias		float64
+	slots		int
+	err		error
+}
+
+func (s *SavReader) get(v interface{}) {
+	if s.err == nil {
+		s.err = binary.Read(s.r, s.order, v)
+	}
+}
+
+func (s *SavReader) i32() int32 {
+	var v int32
+	s.get(&v)
+	return v
+}
+
+func (s *SavReader) bytes(n int) []byte {
+	b := make([]byte, n)
+	if s.err == nil {
+		_, s.err = io.ReadFull(s.r, b)
+	}
+	return b
+}
+
+/* Opens a system file and reads its dictionary; the cases are read by Cases. */
+func OpenSav(in string) (*SavReader, error) {
+	file, err := os.Open(in)
+	if err != nil {
+		return nil, err
+	}
+	s := &SavReader{r: bufio.NewReader(file), file: file, order: binary.LittleEndian, Weight: -1}
+	header := s.bytes(176)
+	if s.err != nil || string(header[:4]) != "$FL2" {
+		file.Close()
+		return nil, fmt.Errorf("%s is not an uncompressed or bytecode compressed system file", in)
+	}
+	if layout := s.order.Uint32(header[64:]); layout != 2 && layout != 3 {
+		s.order = binary.BigEndian
+	}
+	s.Product = strings.TrimSpace(strings.TrimPrefix(string(header[4:64]), "@(#) SPSS DATA FILE"))
+	s.compressed = s.order.Uint32(header[72:]) == 1
+	weight := int(s.order.Uint32(header[76:]))
+	s.bias = math.Float64frombits(s.order.Uint64(header[84:]))
+	s.Label = strings.TrimSpace(string(header[109:173]))
+
+	var lastLabels []SavLabel
+	longNames := make(map[string]string)
+	for s.err == nil {
+		switch rt := s.i32(); rt {
+		case 2:
+			width := s.i32()
+			hasLabel, missing := s.i32(), s.i32()
+			format := s.i32()
+			s.i32()
+			name := strings.TrimRight(string(s.bytes(8)), " ")
+			sf := SavField{Name: name, Width: int(width), Print: format, Slot: s.slots}
+			if hasLabel == 1 {
+				n := int(s.i32())
+				sf.Label = string(s.bytes((n + 3) / 4 * 4)[:n])
+			}
+			if missing < 0 {
+				missing = -missing
+			}
+			s.bytes(int(missing) * 8)
+			s.slots++
+			if width >= 0 {
+				if weight == s.slots {
+					s.Weight = len(s.Fields)
+				}
+				s.Fields = append(s.Fields, sf)
+			}
+		case 3:
+			lastLabels = nil
+			for n := s.i32(); n > 0 && s.err == nil; n-- {
+				value := s.bytes(8)
+				size := s.bytes(1)[0]
+				label := s.bytes((int(size) + 8) / 8 * 8 - 1)[:size]
+				lastLabels = append(lastLabels, SavLabel{math.Float64frombits(s.order.Uint64(value)), string(label)})
+			}
+		case 4:
+			for n := s.i32(); n > 0 && s.err == nil; n-- {
+				slot := int(s.i32()) - 1
+				for i := range s.Fields {
+					if s.Fields[i].Slot == slot && s.Fields[i].Width == 0 {
+						s.Fields[i].Labels = lastLabels
+					}
+				}
+			}
+		case 6:
+			s.bytes(int(s.i32()) * 80)
+		case 7:
+			subtype, size, count := s.i32(), s.i32(), s.i32()
+			data := s.bytes(int(size) * int(count))
+			if subtype == 13 {
+				for _, pair := range strings.Split(string(data), "\t") {
+					if i := strings.Index(pair, "="); i > 0 {
+						longNames[pair[:i]] = pair[i+1:]
+					}
+				}
+			}
+		case 999:
+			s.i32()
+			for i := range s.Fields {
+				if long, ok := longNames[s.Fields[i].Name]; ok {
+					s.Fields[i].Name = long
+				}
+			}
+			return s, nil
+		default:
+			if s.err == nil {
+				s.err = fmt.Errorf("%s has an unknown record type %d", in, rt)
+			}
+		}
+	}
+	file.Close()
+	return nil, s.err
+}
+
+/* Calls fn with the 8 byte segments of every case in turn. */
+func (s *SavReader) Cases(fn func(slots []byte) error) error {
+	defer s.file.Close()
+	slots := make([]byte, s.slots*8)
+	codes, next := make([]byte, 8), 8
+	for {
+		if !s.compressed {
+			_, err := io.ReadFull(s.r, slots)
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		} else {
+			for i := 0; i < s.slots; i++ {
+				code := byte(0)
+				for code == 0 {
+					if next == len(codes) {
+						_, err := io.ReadFull(s.r, codes)
+						if err == io.EOF && i == 0 {
+							return nil
+						} else if err != nil {
+							return err
+						}
+						next = 0
+					}
+					code = codes[next]
+					next++
+				}
+				slot := slots[i*8 : i*8+8]
+				switch code {
+				case savEOF:
+					if i > 0 {
+						return io.ErrUnexpectedEOF
+					}
+					return nil
+				case savRaw:
+					_, err := io.ReadFull(s.r, slot)
+					if err != nil {
+						return err
+					}
+				case savSpaces:
+					copy(slot, "        ")
+				case savSysmis:
+					s.order.PutUint64(slot, math.Float64bits(-math.MaxFloat64))
+				default:
+					s.order.PutUint64(slot, math.Float64bits(float64(code)-s.bias))
+				}
+			}
+		}
+		err := fn(slots)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+
+/* Converts an SPSS system file to a Triple-S file and a fixed-width data file, out.sss.xml and out.asc.
+The data is read twice: first for the widths and ranges of the numbers, then to write it.
+Labelled integers become single variables, dates and times keep their Triple-S formats and other
+numbers become quantities. Very long strings come out as the 255 byte segments SPSS stores them in. */
+func SavToSss(in string, out string, version string) (*Variables, error) {
+	s, err := OpenSav(in)
+	if err != nil {
+		return nil, err
+	}
+	widths := make([]int, len(s.Fields))
+	low, high := make([]float64, len(s.Fields)), make([]float64, len(s.Fields))
+	lowText, highText := make([]string, len(s.Fields)), make([]string, len(s.Fields))
+	for i, sf := range s.Fields {
+		widths[i] = 1
+		if sf.Width > 0 {
+			widths[i] = sf.Width
+		} else if sf.Type() == "date" {
+			widths[i] = 8
+		} else if sf.Type() == "time" {
+			widths[i] = 6
+		}
+		for _, l := range sf.Labels {
+			if n := len(strconv.FormatFloat(l.Value, 'f', sf.Decimals(), 64)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+		low[i], high[i] = math.Inf(1), math.Inf(-1)
+	}
+	err = s.Cases(func(slots []byte) error {
+		for i, sf := range s.Fields {
+			text := sf.Text(slots, s.order)
+			if sf.Width > 0 || text == "" {
+				continue
+			}
+			if len(text) > widths[i] {
+				widths[i] = len(text)
+			}
+			f := math.Float64frombits(s.order.Uint64(slots[sf.Slot*8:]))
+			if f < low[i] {
+				low[i], lowText[i] = f, text
+			}
+			if f > high[i] {
+				high[i], highText[i] = f, text
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Variables{Origin: s.Product, Name: path.Base(out), Title: s.Label}
+	start := 1
+	for i, sf := range s.Fields {
+		v := Variable{Ident: strconv.Itoa(i + 1), Type: sf.Type(), Name: sf.Name, Label: sf.Label,
+			Position: Posit{Start: start, Finish: start + widths[i] - 1}}
+		if v.Label == "" {
+			v.Label = sf.Name
+		}
+		if i == s.Weight {
+			v.Use = "weight"
+		}
+		if v.Type == "single" {
+			for _, l := range sf.Labels {
+				v.Vals = append(v.Vals, Val{int(l.Value), l.Label})
+			}
+		} else if v.Type == "quantity" {
+			if lowText[i] == "" {
+				lowText[i], highText[i] = "0", "0"
+			}
+			v.Ranges = []Range{{lowText[i], highText[i]}}
+		}
+		d.Variable = append(d.Variable, v)
+		start += widths[i]
+	}
+	err = WriteSss(out+".sss.xml", version, d)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err = OpenSav(in)
+	if err != nil {
+		return nil, err
+	}
+	file, err := Create(out + ".asc")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	err = s.Cases(func(slots []byte) error {
+		for i, sf := range s.Fields {
+			text := sf.Text(slots, s.order)
+			pad := strings.Repeat(" ", widths[i]-len(text))
+			if sf.Width > 0 {
+				text, pad = pad, text
+			}
+			w.WriteString(pad + text)
+		}
+		_, err := w.WriteString("\n")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, w.Flush()
+}
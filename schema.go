@@ -0,0 +1,463 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+
+/* Returned by the validate command when the Triple-S file breaks the schema of its version */
+var ErrSchema = errors.New("the Triple-S file does not follow the standard")
+
+/* What an element of a Triple-S file may hold: its attributes, of which required must be given, and
+its child elements, of which needs must be given. Since is the first version with the element. */
+type sssElement struct {
+	attrs		[]string
+	required	[]string
+	children	[]string
+	needs		[]string
+	since		string
+}
+
+/* The content models of the Triple-S schemas. The versions differ only in the elements they add:
+spreads and multilingual texts in 1.2, filters and sizes in 2.0. The order of the children is not checked. */
+var sssSchema = map[string]sssElement{
+	"sss":		{attrs: []string{"version", "languages", "modes"}, required: []string{"version"},
+			children: []string{"date", "time", "origin", "user", "survey"}, needs: []string{"survey"}},
+	"date":		{},
+	"time":		{},
+	"origin":	{},
+	"user":		{},
+	"survey":	{children: []string{"name", "version", "title", "record"}, needs: []string{"record"}},
+	"name":		{},
+	"version":	{},
+	"title":	{children: []string{"text"}},
+	"record":	{attrs: []string{"ident", "format", "skip", "href"}, required: []string{"ident"},
+			children: []string{"variable"}, needs: []string{"variable"}},
+	"variable":	{attrs: []string{"ident", "type", "use", "format"}, required: []string{"ident", "type"},
+			children: []string{"name", "label", "position", "spread", "values", "filter", "size"},
+			needs: []string{"label", "position"}},
+	"label":	{children: []string{"text"}},
+	"text":		{attrs: []string{"lang", "mode"}, since: "1.2"},
+	"position":	{attrs: []string{"start", "finish"}, required: []string{"start"}},
+	"spread":	{attrs: []string{"subfields", "width"}, required: []string{"subfields"}, since: "1.2"},
+	"values":	{children: []string{"value", "range"}},
+	"value":	{attrs: []string{"code", "score"}, required: []string{"code"}, children: []string{"text"}},
+	"range":	{attrs: []string{"from", "to"}, required: []string{"from", "to"}},
+	"filter":	{since: "2.0"},
+	"size":		{since: "2.0"},
+}
+
+/* The values the enumerated attributes may take */
+var sssAttributeValues = map[string][]string{
+	"variable type":	{"single", "multiple", "quantity", "character", "logical", "date", "time"},
+	"variable use":		{"serial", "weight"},
+	"record format":	{"fixed", "csv"},
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+/* Checks the Triple-S file in b against the schema of the version it declares: which elements may
+hold which others and which attributes, what must be given, what the version has and the values of
+enumerated and numeric attributes. Returns every violation with the line it is on. */
+func CheckSchema(r io.Reader) ([]string, error) {
+	var problems []string
+	report := func(line int, format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf("line %d: ", line)+fmt.Sprintf(format, a...))
+	}
+	type open struct {
+		name	string
+		line	int
+		seen	map[string]bool
+	}
+	var stack []open
+	version := ""
+	dec := xml.NewDecoder(r)
+	for {
+		line, _ := dec.InputPos()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return problems, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			e, known := sssSchema[name]
+			if len(stack) == 0 {
+				if name != "sss" {
+					report(line, "the root element is %s instead of sss", name)
+					return problems, nil
+				}
+			} else {
+				parent := stack[len(stack)-1]
+				parent.seen[name] = true
+				if !contains(sssSchema[parent.name].children, name) {
+					report(line, "%s cannot hold %s", parent.name, name)
+				}
+			}
+			if known && e.since != "" && version != "" && version < e.since {
+				report(line, "%s needs Triple-S %s, the file declares %s", name, e.since, version)
+			}
+			given := make(map[string]bool)
+			for _, a := range t.Attr {
+				if a.Name.Space != "" || a.Name.Local == "xmlns" {
+					continue
+				} // Namespaces and schema locations
+				given[a.Name.Local] = true
+				if known && !contains(e.attrs, a.Name.Local) {
+					report(line, "%s has no attribute %s", name, a.Name.Local)
+					continue
+				}
+				if allowed, ok := sssAttributeValues[name+" "+a.Name.Local]; ok && !contains(allowed, a.Value) {
+					report(line, "%s %s %q is not one of %s", name, a.Name.Local, a.Value, strings.Join(allowed, ", "))
+				}
+				switch name + " " + a.Name.Local {
+				case "position start", "position finish", "spread subfields", "spread width", "record skip":
+					if n, err := strconv.Atoi(a.Value); err != nil || n < 0 {
+						report(line, "%s %s %q is not a whole number", name, a.Name.Local, a.Value)
+					}
+				case "sss version":
+					version = a.Value
+					if !contains(SssVersions, version) {
+						report(line, "unknown Triple-S version %q, the schemas are those of %s", version, strings.Join(SssVersions, ", "))
+					}
+				}
+			}
+			for _, a := range e.required {
+				if !given[a] {
+					report(line, "%s needs the attribute %s", name, a)
+				}
+			}
+			stack = append(stack, open{name, line, make(map[string]bool)})
+		case xml.EndElement:
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, c := range sssSchema[top.name].needs {
+				if !top.seen[c] {
+					report(top.line, "%s needs a %s element", top.name, c)
+				}
+			}
+		}
+	}
+	return problems, nil
+}
+
+/* Checks what the schema cannot: that idents are unique, positions start before they finish and
+fit the type, spreads fill their positions, categorical variables have codes that fit their width,
+quantities have a range, there is at most one serial and one weight, no code is given twice and no
+positions overlap. */
+func CheckMetadata(d *Variables) []string {
+	var problems []string
+	report := func(v Variable, format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: ", v.Name)+fmt.Sprintf(format, a...))
+	}
+	idents := make(map[string]bool)
+	uses := make(map[string]int)
+	for _, v := range d.Variable {
+		if idents[v.Ident] {
+			report(v, "ident %s is used before", v.Ident)
+		}
+		idents[v.Ident] = true
+		if v.Use != "" {
+			uses[v.Use]++
+			if uses[v.Use] == 2 {
+				report(v, "a second variable with use=%q", v.Use)
+			}
+		}
+		if v.Use == "weight" && v.Type != "quantity" {
+			report(v, "the weight is a %s variable instead of a quantity", v.Type)
+		}
+
+		start, finish := v.Position.Start, v.Position.Finish
+		if finish == 0 {
+			finish = start
+		}
+		if start < 1 || finish < start {
+			report(v, "position %d-%d does not start at 1 or later and before it finishes", start, finish)
+			continue
+		}
+		width := finish - start + 1
+		switch v.Type {
+		case "logical":
+			if width != 1 {
+				report(v, "a logical is 1 column wide, not %d", width)
+			}
+		case "date":
+			if width != 8 {
+				report(v, "a date is 8 columns wide as YYYYMMDD, not %d", width)
+			}
+		case "time":
+			if width != 6 {
+				report(v, "a time is 6 columns wide as HHMMSS, not %d", width)
+			}
+		case "quantity":
+			if len(v.Ranges) == 0 {
+				report(v, "a quantity needs a range")
+			}
+		case "single", "multiple":
+			if len(v.Vals) == 0 {
+				report(v, "a %s variable needs values", v.Type)
+			}
+		}
+		if v.Spread.Subfields > 0 {
+			if v.Type != "multiple" {
+				report(v, "only multiple variables are spread, not %s", v.Type)
+			} else if v.Spread.Subfields*max(v.Spread.Width, 1) != width {
+				report(v, "a spread of %d subfields of %d columns does not fill the %d columns", v.Spread.Subfields, max(v.Spread.Width, 1), width)
+			}
+			width = max(v.Spread.Width, 1)
+		}
+		for _, r := range v.Ranges {
+			from, err1 := strconv.ParseFloat(r.From, 64)
+			to, err2 := strconv.ParseFloat(r.To, 64)
+			if v.Type != "quantity" && v.Type != "single" && v.Type != "multiple" {
+				report(v, "a %s variable has no range", v.Type)
+			} else if err1 != nil || err2 != nil {
+				report(v, "range %s to %s is not numeric", r.From, r.To)
+			} else if from > to {
+				report(v, "range %s to %s runs backwards", r.From, r.To)
+			}
+		}
+		if v.Type == "single" || v.Type == "multiple" && v.Spread.Subfields > 0 {
+			for _, val := range v.Vals {
+				if val.Value < 0 || len(strconv.Itoa(val.Value)) > width {
+					report(v, "code %d does not fit %d columns", val.Value, width)
+				}
+			}
+		}
+		if (v.Type == "character" || v.Type == "logical") && len(v.Vals) > 0 {
+			report(v, "a %s variable has no values", v.Type)
+		}
+	}
+	problems = append(problems, DuplicateCodes(d, false)...)
+	return append(problems, Overlaps(d)...)
+}
+
+/* Returns the variables in order of their start position. */
+func byPosition(d *Variables) []Variable {
+	vars := append([]Variable(nil), d.Variable...)
+	sort.SliceStable(vars, func(i, j int) bool {
+		return vars[i].Position.Start < vars[j].Position.Start
+	})
+	return vars
+}
+
+/* Returns the variables whose positions overlap those of a variable starting before or with them,
+which DATA LIST would read the same columns for. */
+func Overlaps(d *Variables) []string {
+	var overlaps []string
+	var last Variable
+	for i, v := range byPosition(d) {
+		if i > 0 && v.Position.Start <= last.Position.Finish {
+			overlaps = append(overlaps, fmt.Sprintf("%s: position %d-%d overlaps %s at %d-%d", v.Name,
+				v.Position.Start, v.Position.Finish, last.Name, last.Position.Start, last.Position.Finish))
+		}
+		if i == 0 || v.Position.Finish > last.Position.Finish {
+			last = v
+		}
+	}
+	return overlaps
+}
+
+/* Returns the columns of the records no variable is at, before the first and between the others. */
+func Gaps(d *Variables) []string {
+	var gaps []string
+	finish, last := 0, "the start of the record"
+	for _, v := range byPosition(d) {
+		if v.Position.Start > finish+1 {
+			gaps = append(gaps, fmt.Sprintf("columns %d-%d between %s and %s are not described", finish+1,
+				v.Position.Start-1, last, v.Name))
+		}
+		if v.Position.Finish > finish {
+			finish, last = v.Position.Finish, v.Name
+		}
+	}
+	return gaps
+}
+
+/* Repairs what the converter can in variables as parsed: a missing name becomes V and the ident, a
+missing ident the number of the variable. A missing finish is the start, as the standard has it. Variables of an unknown type
+or without a start before their finish are left out. Returns what was repaired and what left out. */
+func Repair(d *Variables) ([]string, []string) {
+	var repaired, dropped []string
+	kept := d.Variable[:0] // Filtered in place, a variable is never moved after one not yet looked at
+	for i, v := range d.Variable {
+		if v.Ident == "" {
+			v.Ident = fmt.Sprint(i + 1)
+			repaired = append(repaired, fmt.Sprintf("variable %d has no ident, given %s", i+1, v.Ident))
+		}
+		if strings.TrimSpace(v.Name) == "" {
+			v.Name = "V" + Identifier(v.Ident, 63)
+			repaired = append(repaired, fmt.Sprintf("variable %s has no name, named %s", v.Ident, v.Name))
+		}
+		if !contains(sssAttributeValues["variable type"], v.Type) {
+			dropped = append(dropped, fmt.Sprintf("%s: unknown type %q", v.Name, v.Type))
+			continue
+		}
+		if v.Position.Start < 1 {
+			dropped = append(dropped, fmt.Sprintf("%s: no position", v.Name))
+			continue
+		}
+		if v.Position.Finish == 0 {
+			v.Position.Finish = v.Position.Start
+		} else if v.Position.Finish < v.Position.Start {
+			dropped = append(dropped, fmt.Sprintf("%s: position %d-%d finishes before it starts", v.Name, v.Position.Start, v.Position.Finish))
+			continue
+		}
+		kept = append(kept, v)
+	}
+	d.Variable = kept
+	return repaired, dropped
+}
+
+/* Checks the Triple-S file input, read in to d by ReadMetadata, for what the conversion cannot take as
+it is. Strict fails on any of it, with every problem logged; otherwise what ReadMetadata repaired and
+left out is warned about. Either way what the conversion does not read is warned about. */
+func CheckParse(input string, d *Variables, strict bool) error {
+	repaired, dropped := d.repaired, d.dropped
+	unread, err := UnsupportedFile(input)
+	if err != nil {
+		return err
+	}
+	if len(unread) > 0 {
+		Warn(fmt.Sprintf("Not converted from %s: %s", input, strings.Join(unread, ", ")))
+	}
+	if !strict {
+		for _, r := range repaired {
+			Warn("Repaired " + r)
+		}
+		for _, r := range dropped {
+			Warn("Left out " + r)
+		}
+		return nil
+	}
+	problems, err := CheckSchemaFile(input)
+	if err != nil {
+		return err
+	}
+	problems = append(append(problems, repaired...), dropped...)
+	for _, p := range problems {
+		slog.Error(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems found in %s with -strict: %w", len(problems), input, ErrSchema)
+	}
+	return nil
+}
+
+/* The elements the conversion reads with the attributes it reads of them */
+var sssHandled = map[string][]string{
+	"sss":		{"version"},
+	"date":		nil,
+	"time":		nil,
+	"origin":	nil,
+	"user":		nil,
+	"survey":	nil,
+	"name":		nil,
+	"version":	nil,
+	"title":	nil,
+	"record":	{"ident", "href"},
+	"variable":	{"ident", "type", "use"},
+	"label":	nil,
+	"position":	{"start", "finish"},
+	"spread":	{"subfields", "width"},
+	"values":	nil,
+	"value":	{"code"},
+	"range":	{"from", "to"},
+	"filter":	nil,
+	"size":		nil,
+}
+
+/* Returns the elements and attributes of the Triple-S file in b that the conversion does not read,
+such as multilingual texts, hierarchies and scores, with how often they occur, so it is known what
+did not make it in to the outputs. Fixed, the default record format, is read. */
+func Unsupported(r io.Reader) []string {
+	counts := make(map[string]int)
+	var order []string
+	note := func(what string) {
+		if counts[what] == 0 {
+			order = append(order, what)
+		}
+		counts[what]++
+	}
+	dec := xml.NewDecoder(r)
+	stack := []string{"the document"}
+	skip := 0
+	for {
+		tok, err := dec.RawToken() // Without the namespace checks of Token, the file was read once already
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			attrs, ok := sssHandled[name]
+			if skip > 0 {
+				skip++
+				continue
+			} else if !ok {
+				note(fmt.Sprintf("element %s in %s", name, stack[len(stack)-1]))
+				skip = 1
+				continue
+			}
+			for _, a := range t.Attr {
+				if a.Name.Space != "" || a.Name.Local == "xmlns" || contains(attrs, a.Name.Local) ||
+					name == "record" && a.Name.Local == "format" && a.Value == "fixed" {
+					continue
+				}
+				note(fmt.Sprintf("attribute %s of %s", a.Name.Local, name))
+			}
+			stack = append(stack, name)
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	lines := make([]string, len(order))
+	for i, what := range order {
+		lines[i] = fmt.Sprintf("%s (%d)", what, counts[what])
+	}
+	return lines
+}
+
+/* Checks the Triple-S file input against its schema with CheckSchema. */
+func CheckSchemaFile(input string) ([]string, error) {
+	file, err := OpenInput(input)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	problems, err := CheckSchema(file)
+	if err != nil {
+		return nil, &ParseError{File: input, Err: err}
+	}
+	return problems, nil
+}
+
+/* Returns what the conversion does not read of the Triple-S file input with Unsupported. */
+func UnsupportedFile(input string) ([]string, error) {
+	file, err := OpenInput(input)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Unsupported(file), nil
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+
+/* A conversion requested from the service, as GET /jobs/{id} returns it */
+type Job struct {
+	ID	string		`json:"id"`
+	Status	string		`json:"status"`		// running, done or failed
+	Error	string		`json:"error,omitempty"`
+	Files	[]string	`json:"files,omitempty"`
+	code	int		// exit code of the conversion
+	dir	string
+	done	chan bool
+}
+
+/* Flags of convert a request cannot set, as they reach outside the folder of its job */
+var serveBlocked = map[string]bool{"o": true, "output": true, "glob": true, "jobs": true, "cache": true,
+	"interval": true, "done-dir": true, "error-dir": true, "log-file": true, "summary": true, "report": true,
+	"cpuprofile": true, "memprofile": true, "pprof-addr": true, "dry-run": true, "name-template": true,
+	"confine-href": true}
+
+/* The conversion service: the jobs it knows, the conversions it runs at a time and how long it keeps
+finished jobs */
+type server struct {
+	dir	string
+	slots	chan bool
+	keep	time.Duration
+	mu	sync.Mutex
+	jobs	map[string]*Job
+}
+
+func (s *server) job(id string) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+/* Returns the options of convert given as query parameters of the request, every value of a
+parameter but async as -name=value. */
+func requestFlags(r *http.Request) ([]string, error) {
+	var flags []string
+	for name, values := range r.URL.Query() {
+		if name == "async" {
+			continue
+		}
+		if flag.Lookup(name) == nil || serveBlocked[name] {
+			return nil, fmt.Errorf("unknown option %s", name)
+		}
+		for _, v := range values {
+			flags = append(flags, fmt.Sprintf("-%s=%s", name, v))
+		}
+	}
+	sort.Strings(flags)
+	return flags, nil
+}
+
+/* Saves the body of the request in dir: the parts named metadata and data of a multipart form, or the
+body itself as the Triple-S file. Returns the paths of the Triple-S file and the data files. */
+func saveUpload(r *http.Request, dir string) ([]string, error) {
+	save := func(name string, src io.Reader) (string, error) {
+		p := filepath.Join(dir, filepath.Base("/"+name))
+		file, err := os.Create(p)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		_, err = io.Copy(file, src)
+		return p, err
+	}
+	media, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if media != "multipart/form-data" {
+		p, err := save("survey.xml", r.Body)
+		return []string{p}, err
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	var metadata string
+	var data []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		name := part.FileName()
+		switch part.FormName() {
+		case "metadata":
+			if name == "" {
+				name = "survey.xml"
+			}
+			metadata, err = save(name, part)
+		case "data":
+			if name == "" {
+				name = fmt.Sprintf("survey_%d.asc", len(data)+1)
+			}
+			var p string
+			p, err = save(name, part)
+			data = append(data, p)
+		default:
+			err = fmt.Errorf("unknown part %s, send metadata and data", part.FormName())
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if metadata == "" {
+		return nil, fmt.Errorf("no metadata part with the Triple-S file")
+	}
+	return append([]string{metadata}, data...), nil
+}
+
+/* Converts the uploaded files of the job with flags by running this program on them, at most as many
+at a time as the service has slots. A data file named by href is only read from the folder of the
+upload. The job is removed once it has been kept finished for the keep time of the service. */
+func (s *server) run(job *Job, flags []string, files []string) {
+	defer func() {
+		time.AfterFunc(s.keep, func() {
+			s.remove(job)
+		})
+	}()
+	defer close(job.done)
+	s.slots <- true
+	defer func() {
+		<-s.slots
+	}()
+	code := ExitFailure
+	self, err := os.Executable()
+	if err == nil {
+		out := filepath.Join(job.dir, "out")
+		args := append(append([]string{"convert", "-force", "-confine-href", "-o", out}, flags...), files...)
+		var messages bytes.Buffer
+		cmd := exec.Command(self, args...)
+		cmd.Stderr = &messages
+		err = cmd.Run()
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			code, err = exit.ExitCode(), errors.New(lastMessage(messages.String()))
+		}
+	}
+	var outputs []string
+	if err == nil {
+		entries, _ := os.ReadDir(filepath.Join(job.dir, "out"))
+		for _, e := range entries {
+			outputs = append(outputs, e.Name())
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		job.Status, job.Error, job.code = "failed", err.Error(), code
+		slog.Error(fmt.Sprintf("Job %s failed: %s", job.ID, job.Error))
+		return
+	}
+	job.Status, job.Files = "done", outputs
+	slog.Info(fmt.Sprintf("Job %s converted to %s", job.ID, strings.Join(outputs, ", ")))
+}
+
+/* POST /convert starts a conversion of the uploaded files and answers with its outputs, or at once
+with the job to ask for them later when async is given. */
+func (s *server) convert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("POST the Triple-S file to convert it"))
+		return
+	}
+	flags, err := requestFlags(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	job := &Job{ID: hex.EncodeToString(id), Status: "running", dir: filepath.Join(s.dir, hex.EncodeToString(id)),
+		done: make(chan bool)}
+	err = os.MkdirAll(filepath.Join(job.dir, "in"), 0755)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	files, err := saveUpload(r, filepath.Join(job.dir, "in"))
+	if err != nil {
+		os.RemoveAll(job.dir)
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.mu.Unlock()
+	go s.run(job, flags, files)
+
+	if async := r.URL.Query().Get("async"); async != "" && async != "0" && async != "false" {
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+		return
+	}
+	<-job.done
+	s.result(w, r, job)
+	s.remove(job)
+}
+
+/* Answers the requests about a job: GET /jobs/{id} with its state, GET /jobs/{id}/result with its
+outputs as POST /convert does, GET /jobs/{id}/files/{name} with one of them and DELETE /jobs/{id}
+removing them. Outputs are only there once the job is finished. */
+func (s *server) jobRequest(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	job := s.job(parts[0])
+	if job == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	state := *job
+	s.mu.Unlock()
+	finished := job.finished()
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, state)
+	case len(parts) == 1 && r.Method == http.MethodDelete && finished:
+		s.remove(job)
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && parts[1] == "result" && r.Method == http.MethodGet && finished:
+		s.result(w, r, job)
+	case len(parts) == 3 && parts[1] == "files" && r.Method == http.MethodGet && finished:
+		if !contains(state.Files, parts[2]) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": parts[2]}))
+		http.ServeFile(w, r, filepath.Join(job.dir, "out", parts[2]))
+	case !finished && len(parts) <= 3:
+		writeJSON(w, http.StatusConflict, state)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (job *Job) finished() bool {
+	select {
+	case <-job.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *server) remove(job *Job) {
+	s.mu.Lock()
+	delete(s.jobs, job.ID)
+	s.mu.Unlock()
+	os.RemoveAll(job.dir)
+}
+
+/* Answers with the outputs of the finished job: a single output as it is, several in a zip archive,
+or the error of a failed conversion with the status matching its exit code. */
+func (s *server) result(w http.ResponseWriter, r *http.Request, job *Job) {
+	if job.Status == "failed" {
+		status := http.StatusInternalServerError
+		switch job.code {
+		case ExitUsage:
+			status = http.StatusBadRequest
+		case ExitParse, ExitInvalid, ExitWarnings:
+			status = http.StatusUnprocessableEntity
+		}
+		writeJSON(w, status, job)
+		return
+	}
+	out := filepath.Join(job.dir, "out")
+	if len(job.Files) == 1 {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": job.Files[0]}))
+		http.ServeFile(w, r, filepath.Join(out, job.Files[0]))
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": job.ID + ".zip"}))
+	z := zip.NewWriter(w)
+	for _, name := range job.Files {
+		dst, err := z.Create(name)
+		if err != nil {
+			return
+		}
+		src, err := os.Open(filepath.Join(out, name))
+		if err != nil {
+			return
+		}
+		_, err = io.Copy(dst, src)
+		src.Close()
+		if err != nil {
+			return
+		}
+	}
+	z.Close()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func httpError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+/* Runs the serve command offering the conversion over HTTP until the program is stopped. */
+func ServeCommand(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	dir := fs.String("dir", filepath.Join(os.TempDir(), "xmltosps-serve"), "folder the files of the jobs are kept in")
+	jobs := fs.Int("jobs", 2, "number of conversions run at a time")
+	keep := fs.Duration("keep", time.Hour, "time finished jobs are kept for their outputs to be fetched")
+	parseArgs(fs, args)
+	if *jobs < 1 || *keep <= 0 {
+		return UsageError("Usage: XMLtoSPS serve [-addr host:port] [-dir folder] [-jobs n] [-keep d]")
+	}
+	err := os.MkdirAll(*dir, 0755)
+	if err != nil {
+		return err
+	}
+	s := &server{dir: *dir, slots: make(chan bool, *jobs), keep: *keep, jobs: make(map[string]*Job)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/convert", s.convert)
+	mux.HandleFunc("/jobs/", s.jobRequest)
+	slog.Info(fmt.Sprintf("Serving conversions on http://%s/convert", *addr))
+	return http.ListenAndServe(*addr, mux)
+}
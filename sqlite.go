@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+
+/* Page layout of the SQLite database file */
+const (
+	sqlitePage		= 4096
+	sqliteHeader		= 100
+	sqliteMaxLocal		= sqlitePage - 35
+	sqliteMinLocal		= (sqlitePage-12)*32/255 - 23
+	sqliteLeaf		= 0x0d
+	sqliteInterior		= 0x05
+	sqliteMaxColumns	= 2000
+)
+
+
+/* Appends the pages of a SQLite file and remembers the first error. Page 1 is reserved for the schema
+and written last, once the root pages of the tables are known. */
+type sqliteFile struct {
+	w	*bufio.Writer
+	pages	uint32
+	err	error
+}
+
+/* Writes a page and returns its number. */
+func (s *sqliteFile) page(b []byte) uint32 {
+	if s.err == nil {
+		page := make([]byte, sqlitePage)
+		copy(page, b)
+		_, s.err = s.w.Write(page)
+	}
+	s.pages++
+	return s.pages
+}
+
+/* Appends the SQLite varint of v, which holds any length or rowid the writer stores. */
+func sqliteVarint(b []byte, v uint64) []byte {
+	var tmp [9]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		tmp[i] = byte(v&0x7f) | 0x80
+	}
+	return append(b, tmp[i:]...)
+}
+
+/* Returns the record of the values, which are nil, int64, float64 or string. */
+func sqliteRecord(values []interface{}) []byte {
+	var types, body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			types = sqliteVarint(types, 0)
+		case int64:
+			switch {
+			case v == 0:
+				types = sqliteVarint(types, 8)
+			case v == 1:
+				types = sqliteVarint(types, 9)
+			case v >= math.MinInt8 && v <= math.MaxInt8:
+				types = sqliteVarint(types, 1)
+				body = append(body, byte(v))
+			case v >= math.MinInt16 && v <= math.MaxInt16:
+				types = sqliteVarint(types, 2)
+				body = binary.BigEndian.AppendUint16(body, uint16(v))
+			case v >= math.MinInt32 && v <= math.MaxInt32:
+				types = sqliteVarint(types, 4)
+				body = binary.BigEndian.AppendUint32(body, uint32(v))
+			default:
+				types = sqliteVarint(types, 6)
+				body = binary.BigEndian.AppendUint64(body, uint64(v))
+			}
+		case float64:
+			types = sqliteVarint(types, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		case string:
+			types = sqliteVarint(types, uint64(13+2*len(v)))
+			body = append(body, v...)
+		}
+	}
+	size := len(types) + 1
+	for len(sqliteVarint(nil, uint64(size))) + len(types) != size {
+		size++
+	}
+	record := sqliteVarint(nil, uint64(size))
+	record = append(record, types...)
+	return append(record, body...)
+}
+
+
+/* A child page of an interior page with the largest rowid stored below it */
+type sqliteChild struct {
+	page	uint32
+	key	int64
+}
+
+/* Builds the b-tree of a table from rows inserted in rowid order. Full leaves are written as they fill up,
+so only one page of cells is held in memory whatever the number of rows. */
+type sqliteTable struct {
+	db	*sqliteFile
+	cells	[][]byte
+	used	int
+	rowid	int64
+	leaves	[]sqliteChild
+}
+
+func (t *sqliteTable) insert(values ...interface{}) {
+	t.rowid++
+	payload := sqliteRecord(values)
+	cell := sqliteVarint(nil, uint64(len(payload)))
+	cell = sqliteVarint(cell, uint64(t.rowid))
+	local := len(payload)
+	if local > sqliteMaxLocal {
+		local = sqliteMinLocal + (len(payload)-sqliteMinLocal)%(sqlitePage-4)
+		if local > sqliteMaxLocal {
+			local = sqliteMinLocal
+		}
+	}
+	cell = append(cell, payload[:local]...)
+	if local < len(payload) {
+		cell = binary.BigEndian.AppendUint32(cell, t.db.pages+1)
+		for rest := payload[local:]; len(rest) > 0; {
+			n := len(rest)
+			if n > sqlitePage-4 {
+				n = sqlitePage - 4
+			}
+			next := t.db.pages + 2
+			if n == len(rest) {
+				next = 0
+			}
+			t.db.page(append(binary.BigEndian.AppendUint32(nil, next), rest[:n]...))
+			rest = rest[n:]
+		}
+	}
+	if t.used+len(cell)+2 > sqlitePage-8 {
+		t.flush()
+	}
+	t.cells = append(t.cells, cell)
+	t.used += len(cell) + 2
+}
+
+/* Writes the cells collected so far as a leaf page. */
+func (t *sqliteTable) flush() {
+	if len(t.cells) > 0 {
+		t.leaves = append(t.leaves, sqliteChild{t.db.page(sqliteBtreePage(sqliteLeaf, 0, t.cells, 0)), t.rowid - 1})
+		t.cells, t.used = nil, 0
+	}
+}
+
+/* Finishes the table and returns the content of its root page, laid out to start at offset hdr of the page. */
+func (t *sqliteTable) root(hdr int) []byte {
+	if len(t.leaves) == 0 && hdr+8+t.used <= sqlitePage {
+		return sqliteBtreePage(sqliteLeaf, hdr, t.cells, 0)
+	}
+	if len(t.cells) > 0 {
+		t.leaves = append(t.leaves, sqliteChild{t.db.page(sqliteBtreePage(sqliteLeaf, 0, t.cells, 0)), t.rowid})
+		t.cells, t.used = nil, 0
+	}
+	children := t.leaves
+	for {
+		var cells [][]byte
+		used := 0
+		for _, c := range children[:len(children)-1] {
+			cell := sqliteVarint(binary.BigEndian.AppendUint32(nil, c.page), uint64(c.key))
+			cells = append(cells, cell)
+			used += len(cell) + 2
+		}
+		if hdr+12+used <= sqlitePage {
+			return sqliteBtreePage(sqliteInterior, hdr, cells, children[len(children)-1].page)
+		}
+		var level []sqliteChild
+		cells, used = nil, 0
+		for i, c := range children {
+			cell := sqliteVarint(binary.BigEndian.AppendUint32(nil, c.page), uint64(c.key))
+			if used+len(cell)+2 > sqlitePage-12 || i == len(children)-1 {
+				level = append(level, sqliteChild{t.db.page(sqliteBtreePage(sqliteInterior, 0, cells, c.page)), c.key})
+				cells, used = nil, 0
+				continue
+			}
+			cells = append(cells, cell)
+			used += len(cell) + 2
+		}
+		children = level
+	}
+}
+
+/* Returns a b-tree page with the cells, its header at offset hdr and, for interior pages, the right-most child. */
+func sqliteBtreePage(kind byte, hdr int, cells [][]byte, right uint32) []byte {
+	page := make([]byte, sqlitePage)
+	page[hdr] = kind
+	binary.BigEndian.PutUint16(page[hdr+3:], uint16(len(cells)))
+	pointers := hdr + 8
+	if kind == sqliteInterior {
+		binary.BigEndian.PutUint32(page[hdr+8:], right)
+		pointers = hdr + 12
+	}
+	content := sqlitePage
+	for i, cell := range cells {
+		content -= len(cell)
+		copy(page[content:], cell)
+		binary.BigEndian.PutUint16(page[pointers+2*i:], uint16(content))
+	}
+	binary.BigEndian.PutUint16(page[hdr+5:], uint16(content))
+	return page
+}
+
+
+/* Returns the SQLite value of a column in a record, NULL for blank or unreadable numbers. */
+func sqliteValue(c Column, rec string) interface{} {
+	value := CleanField(c, rec)
+	if c.Print == "" {
+		return value
+	} else if strings.HasSuffix(c.Print, ".0") {
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	return nil
+}
+
+/* Returns the SQLite type of the column. */
+func sqliteType(c Column) string {
+	if c.Print == "" {
+		return "TEXT"
+	} else if strings.HasSuffix(c.Print, ".0") {
+		return "INTEGER"
+	}
+	return "REAL"
+}
+
+/* Writes a SQLite database with the Triple-S metadata in a variables and a values table and, with cases,
+the data file in a cases table of one column per SPSS variable. The file format is written directly,
+so no SQLite library is needed to create it. */
+func WriteSqlite(asc string, out string, cases bool, d *Variables) error {
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	db := &sqliteFile{w: bufio.NewWriter(file)}
+	db.page(nil)
+
+	type table struct {
+		name	string
+		sql	string
+		root	uint32
+	}
+	var tables []table
+
+	variables := &sqliteTable{db: db}
+	values := &sqliteTable{db: db}
+	for _, v := range d.Variable {
+		variables.insert(v.Name, v.Ident, v.Type, v.Use, v.Label, int64(v.Position.Start), int64(v.Position.Finish),
+			strings.Join(v.SpssNames(), " "))
+		for _, val := range v.Vals {
+			values.insert(v.Name, int64(val.Value), val.Name)
+		}
+	}
+	tables = append(tables, table{"variables", "CREATE TABLE variables (name TEXT, ident TEXT, type TEXT, use TEXT, " +
+		"label TEXT, start INTEGER, finish INTEGER, columns TEXT)", db.page(variables.root(0))})
+	tables = append(tables, table{"\"values\"", "CREATE TABLE \"values\" (variable TEXT, code INTEGER, label TEXT)",
+		db.page(values.root(0))})
+
+	if cases {
+		var cols []Column
+		for _, v := range d.Variable {
+			cols = append(cols, v.Columns()...)
+		}
+		if len(cols) > sqliteMaxColumns {
+			return fmt.Errorf("%d columns do not fit in a SQLite table of at most %d", len(cols), sqliteMaxColumns)
+		}
+		defs := make([]string, len(cols))
+		for i, c := range cols {
+			defs[i] = fmt.Sprintf("%s %s", Quote(c.Name), sqliteType(c))
+		}
+		data := &sqliteTable{db: db}
+		row := make([]interface{}, len(cols))
+		err = ReadRecords(asc, func(n int, rec string) error {
+			for i, c := range cols {
+				row[i] = sqliteValue(c, rec)
+			}
+			data.insert(row...)
+			return db.err
+		})
+		if err != nil {
+			return err
+		}
+		tables = append(tables, table{"cases", fmt.Sprintf("CREATE TABLE cases (%s)", strings.Join(defs, ", ")),
+			db.page(data.root(0))})
+	}
+
+	master := &sqliteTable{db: db}
+	for _, t := range tables {
+		name := strings.Trim(t.name, "\"")
+		master.insert("table", name, name, int64(t.root), t.sql)
+	}
+	page := master.root(sqliteHeader)
+	if db.err != nil {
+		return db.err
+	}
+	err = db.w.Flush()
+	if err != nil {
+		return err
+	}
+
+	copy(page, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(page[16:], sqlitePage)
+	page[18], page[19] = 1, 1
+	page[21], page[22], page[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(page[24:], 1)
+	binary.BigEndian.PutUint32(page[28:], db.pages)
+	binary.BigEndian.PutUint32(page[40:], 1)
+	binary.BigEndian.PutUint32(page[44:], 4)
+	binary.BigEndian.PutUint32(page[56:], 1)
+	binary.BigEndian.PutUint32(page[92:], 1)
+	binary.BigEndian.PutUint32(page[96:], 3046000)
+	_, err = file.WriteAt(page, 0)
+	return err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+
+/* The database header describes the pages written, and the schema page lists every table. */
+func TestWriteSqliteHeader(t *testing.T) {
+	d, asc := testData(t)
+	out := filepath.Join(t.TempDir(), "test.sqlite")
+	err := WriteSqlite(asc, out, true, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:16]) != "SQLite format 3\x00" {
+		t.Fatalf("header starts %q", b[:16])
+	}
+	size := int(binary.BigEndian.Uint16(b[16:]))
+	pages := int(binary.BigEndian.Uint32(b[28:]))
+	if size != sqlitePage || pages*size != len(b) {
+		t.Errorf("%d pages of %d bytes in a file of %d bytes", pages, size, len(b))
+	}
+	if b[sqliteHeader] != sqliteLeaf {
+		t.Errorf("schema page is of type %#x, want a table leaf", b[sqliteHeader])
+	}
+	if n := binary.BigEndian.Uint16(b[sqliteHeader+3:]); n != 3 {
+		t.Errorf("schema lists %d tables, want 3", n)
+	}
+}
+
+/* SQLite itself finds the database intact and reads the cases from it. Skipped without the sqlite3 shell. */
+func TestWriteSqliteIntegrity(t *testing.T) {
+	shell, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("no sqlite3 shell")
+	}
+	d, asc := testData(t)
+	out := filepath.Join(t.TempDir(), "test.sqlite")
+	err = WriteSqlite(asc, out, true, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := []struct {
+		sql	string
+		want	string
+	}{
+		{"PRAGMA integrity_check", "ok"},
+		{"SELECT count(*) FROM variables", "5"},
+		{"SELECT group_concat(label, '|') FROM \"values\" WHERE variable = 'Q1'", "Male|Female"},
+		{"SELECT ID, Q1, \"Q2#2\", WT, OE FROM cases ORDER BY ID LIMIT 1", "0001|1|0|1.5|hello"},
+		{"SELECT count(*) FROM cases WHERE Q1 IS NULL AND WT IS NULL", "1"},
+	}
+	for _, q := range queries {
+		if got := sqliteQuery(t, shell, out, q.sql); got != q.want {
+			t.Errorf("%s gives %q, want %q", q.sql, got, q.want)
+		}
+	}
+}
+
+/* Tables of more cases than a page holds are written as a tree of pages SQLite finds intact. */
+func TestWriteSqliteManyPages(t *testing.T) {
+	shell, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("no sqlite3 shell")
+	}
+	d, asc := testData(t)
+	var b strings.Builder
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&b, "%04d%s\n", i%10000, testRecords[i%len(testRecords)][4:])
+	}
+	err = os.WriteFile(asc, []byte(b.String()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "test.sqlite")
+	err = WriteSqlite(asc, out, true, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sqliteQuery(t, shell, out, "PRAGMA integrity_check"); got != "ok" {
+		t.Errorf("integrity check gives %q", got)
+	}
+	if got := sqliteQuery(t, shell, out, "SELECT count(*), sum(Q1) FROM cases"); got != "50000|50001" {
+		t.Errorf("cases sum up to %q, want 50000|50001", got)
+	}
+}
+
+/* Returns the trimmed output of the sqlite3 shell running the query on the database. */
+func sqliteQuery(t *testing.T, shell string, db string, sql string) string {
+	t.Helper()
+	got, err := exec.Command(shell, db, sql).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %v: %s", sql, err, got)
+	}
+	return strings.TrimSpace(string(got))
+}
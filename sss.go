@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+
+/* Triple-S versions the writer produces */
+var SssVersions = []string{"1.1", "1.2", "2.0", "3.0"}
+
+/* Structures of the written Triple-S file, which leave out what the parsed structures default */
+type sssFile struct {
+	XMLName		xml.Name		`xml:"sss"`
+	Version		string			`xml:"version,attr"`
+	Date		string			`xml:"date,omitempty"`
+	Time		string			`xml:"time,omitempty"`
+	Origin		string			`xml:"origin,omitempty"`
+	User		string			`xml:"user,omitempty"`
+	Survey		sssSurvey		`xml:"survey"`
+}
+
+type sssSurvey struct {
+	Name		string			`xml:"name,omitempty"`
+	Version		string			`xml:"version,omitempty"`
+	Title		string			`xml:"title,omitempty"`
+	Record		sssRecord		`xml:"record"`
+}
+
+type sssRecord struct {
+	Ident		string			`xml:"ident,attr"`
+	Href		string			`xml:"href,attr,omitempty"`
+	Variable	[]sssVariable		`xml:"variable"`
+}
+
+type sssVariable struct {
+	Ident		string			`xml:"ident,attr"`
+	Type		string			`xml:"type,attr"`
+	Use		string			`xml:"use,attr,omitempty"`
+	Name		string			`xml:"name"`
+	Label		string			`xml:"label"`
+	Filter		string			`xml:"filter,omitempty"`
+	Position	Posit
+	Spread		*Spread
+	Size		int			`xml:"size,omitempty"`
+	Values		*sssValues		`xml:"values"`
+}
+
+type sssValues struct {
+	Vals		[]Val			`xml:"value"`
+	Ranges		[]Range			`xml:"range"`
+}
+
+/* Returns what a Triple-S file of an older version cannot hold and WriteSss leaves out:
+filters and the use attribute before 2.0, spreads before 1.2. */
+func SssLosses(version string, d *Variables) []string {
+	var lost []string
+	for _, v := range d.Variable {
+		if version < "2.0" && v.Filter != "" {
+			lost = append(lost, fmt.Sprintf("filter of %s", v.Name))
+		}
+		if version < "2.0" && v.Use != "" {
+			lost = append(lost, fmt.Sprintf("use of %s", v.Name))
+		}
+		if version < "1.2" && v.Spread.Subfields > 0 {
+			lost = append(lost, fmt.Sprintf("spread of %s", v.Name))
+		}
+	}
+	return lost
+}
+
+/* Writes the metadata back as a Triple-S file of the given version, so a survey can be parsed,
+corrected and written again or moved to another version. What the version lacks, as listed by SssLosses,
+is left out. */
+func WriteSss(out string, version string, d *Variables) error {
+	known := false
+	for _, v := range SssVersions {
+		known = known || v == version
+	}
+	if !known {
+		return fmt.Errorf("unknown Triple-S version %s, use one of %v", version, SssVersions)
+	}
+
+	f := sssFile{Version: version, Date: d.Date, Time: d.Time, Origin: d.Origin, User: d.User,
+		Survey: sssSurvey{Name: d.Name, Version: d.Version, Title: d.Title, Record: sssRecord{Ident: "A", Href: d.Href}}}
+	for _, v := range d.Variable {
+		sv := sssVariable{Ident: v.Ident, Type: v.Type, Use: v.Use, Name: v.Name, Label: v.Label, Filter: v.Filter,
+			Position: v.Position}
+		if version < "2.0" {
+			sv.Use, sv.Filter = "", ""
+		}
+		if v.Spread.Subfields > 0 && version >= "1.2" {
+			spread := v.Spread
+			sv.Spread = &spread
+		}
+		if len(v.Vals) > 0 || len(v.Ranges) > 0 {
+			sv.Values = &sssValues{v.Vals, v.Ranges}
+		}
+		if v.Type == "character" {
+			sv.Size = v.Width()
+		}
+		f.Survey.Record.Variable = append(f.Survey.Record.Variable, sv)
+	}
+
+	file, err := Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(file)
+	enc.Indent("", "  ")
+	err = enc.Encode(f)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString("\n")
+	return err
+}
+
+/* Runs the upgrade command rewriting a Triple-S file as another version, newer or, leaving out what
+that version lacks, older, as -to sss does in a conversion. */
+func UpgradeCommand(args []string) error {
+	fs := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	out := fs.String("o", "", "Triple-S file to write, by default the input with .sss.xml in place of .xml")
+	version := fs.String("sss-version", "2.0", "Triple-S version to write")
+	paths := parseArgs(fs, args)
+	if len(paths) != 1 {
+		return UsageError("Usage: XMLtoSPS upgrade [-o out.xml] [-sss-version v] <XML:filepath>")
+	}
+	d, err := ReadMetadata(paths[0])
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(paths[0], path.Ext(paths[0])) + ".sss.xml"
+	}
+	if lost := SssLosses(*version, d); len(lost) > 0 {
+		Warn(fmt.Sprintf("Triple-S %s has no %s", *version, strings.Join(lost, ", ")))
+	}
+	err = WriteSss(*out, *version, d)
+	if err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Rewrote Triple-S %s as %s in %s", d.SssVersion, *version, *out))
+	return nil
+}
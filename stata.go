@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+
+/* Limits of Stata names, labels and str variables */
+const (
+	stataName = 32
+	stataLabel = 80
+	stataStr = 2045
+)
+
+/* Returns s as a Stata compound double quoted string, which may contain double quotes itself.
+Compound quotes do not stop macro expansion, so $ and ` are escaped to keep them in the label. */
+func stataQuote(s string) string {
+	s = strings.NewReplacer("$", "\\$", "`", "\\`").Replace(s)
+	return "`\"" + s + "\"'"
+}
+
+/* Returns the Stata storage type able to hold the column. */
+func stataType(c Column) string {
+	width := c.Finish - c.Start + 1
+	if c.Print == "" {
+		if width > stataStr {
+			width = stataStr
+		}
+		return fmt.Sprintf("str%d", width)
+	}
+	if !strings.HasSuffix(c.Print, ".0") {
+		return "double"
+	} else if width <= 2 {
+		return "byte"
+	} else if width <= 4 {
+		return "int"
+	} else if width <= 9 {
+		return "long"
+	}
+	return "double"
+}
+
+/* Writes a Stata infix dictionary base.dct reading the fixed-width data file and a do-file base.do
+that reads the data through it and applies the variable and value labels, like the SPS-syntax does for SPSS. */
+func WriteStata(asc string, base string, d *Variables) error {
+	dct, err := Create(base + ".dct")
+	if err != nil {
+		return err
+	}
+	defer dct.Close()
+	w := bufio.NewWriter(dct)
+	var cols []Column
+	for _, v := range d.Variable {
+		cols = append(cols, v.Columns()...)
+	}
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = Identifier(c.Name, stataName)
+	}
+	names = uniqueNames(names, stataName)
+	fmt.Fprintf(w, "infix dictionary using %s {\n", strconv.Quote(asc))
+	for i, c := range cols {
+		finish := c.Finish
+		if c.Print == "" && finish-c.Start+1 > stataStr {
+			finish = c.Start + stataStr - 1
+		}
+		fmt.Fprintf(w, "\t%s %s %d-%d\n", stataType(c), names[i], c.Start, finish)
+	}
+	fmt.Fprint(w, "}\n")
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	do, err := Create(base + ".do")
+	if err != nil {
+		return err
+	}
+	defer do.Close()
+	w = bufio.NewWriter(do)
+	fmt.Fprintf(w, "clear\ninfix using %s\n", strconv.Quote(path.Base(base)+".dct"))
+	if d.Title != "" {
+		label, _ := Truncate(d.Title, stataLabel, false)
+		fmt.Fprintf(w, "label data %s\n", stataQuote(label))
+	}
+	for i, c := range cols {
+		label, _ := Truncate(c.Label, stataLabel, false)
+		fmt.Fprintf(w, "label variable %s %s\n", names[i], stataQuote(label))
+		if len(c.Labels) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "label define %s", names[i])
+		for _, l := range c.Labels {
+			fmt.Fprintf(w, " %d %s", l.Value, stataQuote(l.Name))
+		}
+		fmt.Fprintf(w, ", replace\nlabel values %s %s\n", names[i], names[i])
+	}
+	return w.Flush()
+}
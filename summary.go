@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+
+/* Structure of the JSON summary of a run */
+type Summary struct {
+	Started		string			`json:"started"`
+	Seconds		float64			`json:"seconds"`
+	ExitCode	int			`json:"exit_code"`
+	Error		string			`json:"error,omitempty"`
+	Metadata	*ManifestFile		`json:"metadata,omitempty"`
+	Data		[]ManifestFile		`json:"data"`
+	Outputs		[]ManifestFile		`json:"outputs"`
+	Variables	int			`json:"variables"`
+	Records		int			`json:"records"`
+	Warnings	[]string		`json:"warnings"`
+	Surveys		[]Summary		`json:"surveys,omitempty"`
+}
+
+/* What the run has read so far, for the summary */
+var started = time.Now()
+var runMetadata string
+var runData []string
+var runConverted []string
+var runVariables int
+
+/* Summaries of the surveys -glob and watch converted, each in a run of its own */
+var runSurveys struct {
+	sync.Mutex
+	list	[]Summary
+}
+
+/* Adds the summary a run over a single survey wrote to name to those of the run. */
+func addSurveySummary(name string) error {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var s Summary
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	runSurveys.Lock()
+	defer runSurveys.Unlock()
+	runSurveys.list = append(runSurveys.list, s)
+	return nil
+}
+
+/* Records of the data files read to their end in the run, by path, so the summary reads none again */
+var recordsRead = struct {
+	sync.Mutex
+	counts	map[string]int
+}{counts: make(map[string]int)}
+
+/* Keeps the number of records read from the data file asc. */
+func countRecords(asc string, n int) {
+	recordsRead.Lock()
+	defer recordsRead.Unlock()
+	recordsRead.counts[asc] = n
+}
+
+/* Returns the number of records read from the data file asc, and whether it was read to its end. */
+func recordCount(asc string) (int, bool) {
+	recordsRead.Lock()
+	defer recordsRead.Unlock()
+	n, ok := recordsRead.counts[asc]
+	return n, ok
+}
+
+/* Writes the summary of the run to out: the checksums of the files read and written, the number of
+variables and records converted, the warnings and the time taken, with the exit code and err for
+a run that failed. Files that cannot be read are left out, so a failed run is still summarized.
+The records are those counted as the outputs read the data; only data no output read, as when the
+syntax alone is written, is read here to count them. A run over many surveys lists the summary of each
+and adds up their variables, records and warnings. */
+func WriteSummary(out string, err error) error {
+	s := Summary{Started: started.UTC().Format(time.RFC3339), Seconds: time.Since(started).Seconds(),
+		Data: []ManifestFile{}, Outputs: []ManifestFile{}, Variables: runVariables, Warnings: Warnings}
+	if s.Warnings == nil {
+		s.Warnings = []string{}
+	}
+	if err != nil {
+		s.ExitCode, s.Error = ExitCode(err), err.Error()
+	} else if *exitWarnings && len(Warnings) > 0 {
+		s.ExitCode = ExitWarnings
+	}
+	if runMetadata != "" && runMetadata != "-" {
+		if f, err := Checksum(runMetadata); err == nil {
+			s.Metadata = &f
+		}
+	}
+	for _, name := range runData {
+		if f, err := Checksum(name); err == nil {
+			s.Data = append(s.Data, f)
+		}
+	}
+	for _, name := range runConverted {
+		if _, ok := recordCount(name); !ok {
+			ReadRecords(name, func(n int, rec string) error {
+				return nil
+			})
+		}
+		n, _ := recordCount(name)
+		s.Records += n
+	}
+	for _, name := range outputs {
+		if f, err := Checksum(name); err == nil {
+			s.Outputs = append(s.Outputs, f)
+		}
+	}
+	runSurveys.Lock()
+	s.Surveys = runSurveys.list
+	runSurveys.Unlock()
+	for _, survey := range s.Surveys {
+		s.Variables += survey.Variables
+		s.Records += survey.Records
+		s.Warnings = append(s.Warnings, survey.Warnings...)
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+
+/* Characters of the bytes 0x80 to 0x9f in Windows-1252; the rest of the code page is ISO-8859-1 */
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8d, 'Ž', 0x8f,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9d, 'ž', 'Ÿ',
+}
+
+/* Characters of the bytes of EBCDIC code page 037, as exported by IBM mainframes in the US and Canada */
+var cp037 = [256]rune{
+	0x00, 0x01, 0x02, 0x03, 0x9c, 0x09, 0x86, 0x7f, 0x97, 0x8d, 0x8e, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+	0x10, 0x11, 0x12, 0x13, 0x9d, 0x85, 0x08, 0x87, 0x18, 0x19, 0x92, 0x8f, 0x1c, 0x1d, 0x1e, 0x1f,
+	0x80, 0x81, 0x82, 0x83, 0x84, 0x0a, 0x17, 0x1b, 0x88, 0x89, 0x8a, 0x8b, 0x8c, 0x05, 0x06, 0x07,
+	0x90, 0x91, 0x16, 0x93, 0x94, 0x95, 0x96, 0x04, 0x98, 0x99, 0x9a, 0x9b, 0x14, 0x15, 0x9e, 0x1a,
+	0x20, 0xa0, 0xe2, 0xe4, 0xe0, 0xe1, 0xe3, 0xe5, 0xe7, 0xf1, 0xa2, 0x2e, 0x3c, 0x28, 0x2b, 0x7c,
+	0x26, 0xe9, 0xea, 0xeb, 0xe8, 0xed, 0xee, 0xef, 0xec, 0xdf, 0x21, 0x24, 0x2a, 0x29, 0x3b, 0xac,
+	0x2d, 0x2f, 0xc2, 0xc4, 0xc0, 0xc1, 0xc3, 0xc5, 0xc7, 0xd1, 0xa6, 0x2c, 0x25, 0x5f, 0x3e, 0x3f,
+	0xf8, 0xc9, 0xca, 0xcb, 0xc8, 0xcd, 0xce, 0xcf, 0xcc, 0x60, 0x3a, 0x23, 0x40, 0x27, 0x3d, 0x22,
+	0xd8, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0xab, 0xbb, 0xf0, 0xfd, 0xfe, 0xb1,
+	0xb0, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72, 0xaa, 0xba, 0xe6, 0xb8, 0xc6, 0xa4,
+	0xb5, 0x7e, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0xa1, 0xbf, 0xd0, 0xdd, 0xde, 0xae,
+	0x5e, 0xa3, 0xa5, 0xb7, 0xa9, 0xa7, 0xb6, 0xbc, 0xbd, 0xbe, 0x5b, 0x5d, 0xaf, 0xa8, 0xb4, 0xd7,
+	0x7b, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49, 0xad, 0xf4, 0xf6, 0xf2, 0xf3, 0xf5,
+	0x7d, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f, 0x50, 0x51, 0x52, 0xb9, 0xfb, 0xfc, 0xf9, 0xfa, 0xff,
+	0x5c, 0xf7, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0xb2, 0xd4, 0xd6, 0xd2, 0xd3, 0xd5,
+	0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0xb3, 0xdb, 0xdc, 0xd9, 0xda, 0x9f,
+}
+
+/* Returns the decoder of a single byte character set the data file can be transcoded from, and whether
+its records are fixed length without line breaks, as data exported from a mainframe is. */
+func Charset(name string) (func(b byte) rune, bool, error) {
+	switch strings.ToLower(strings.Replace(name, "_", "-", -1)) {
+	case "ebcdic", "cp037", "ibm037", "ibm-037":
+		return func(b byte) rune { return cp037[b] }, true, nil
+	case "latin1", "iso-8859-1", "iso8859-1":
+		return func(b byte) rune { return rune(b) }, false, nil
+	case "windows-1252", "cp1252":
+		return func(b byte) rune {
+			if b >= 0x80 && b < 0xa0 {
+				return cp1252[b-0x80]
+			}
+			return rune(b)
+		}, false, nil
+	}
+	return nil, false, fmt.Errorf("unknown data encoding %s, use latin1, windows-1252 or ebcdic", name)
+}
+
+/* Returns s decoded to UTF-8. */
+func decode(s string, dec func(b byte) rune) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		b.WriteRune(dec(s[i]))
+	}
+	return b.String()
+}
+
+/* Writes the data file transcoded to UTF-8 to out and moves the positions of the metadata to match it.
+A character field whose text takes more bytes in UTF-8 than its columns hold is widened to its longest
+value and the variables behind it move along. Records of a character set without line breaks are read
+as long as the last finish position. Returns the names of the widened fields. */
+func TranscodeData(asc string, out string, charset string, d *Variables) ([]string, error) {
+	dec, fixed, err := Charset(charset)
+	if err != nil {
+		return nil, err
+	}
+	read := ReadRecords
+	if fixed {
+		length := 0
+		for _, v := range d.Variable {
+			if v.Position.Finish > length {
+				length = v.Position.Finish
+			}
+		}
+		read = func(asc string, fn func(n int, rec string) error) error {
+			return ReadFixedRecords(asc, length, fn)
+		}
+	}
+	var chars []*Variable
+	for i := range d.Variable {
+		if d.Variable[i].Type == "character" {
+			chars = append(chars, &d.Variable[i])
+		}
+	}
+	sort.SliceStable(chars, func(i, j int) bool { return chars[i].Position.Start < chars[j].Position.Start })
+
+	widths := make([]int, len(chars))
+	for i, v := range chars {
+		widths[i] = v.Width()
+	}
+	err = read(asc, func(n int, rec string) error {
+		for i, v := range chars {
+			text := strings.TrimRight(decode(Field(rec, v.Position.Start, v.Position.Finish), dec), " ")
+			if len(text) > widths[i] {
+				widths[i] = len(text)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := Create(out)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	err = read(asc, func(n int, rec string) error {
+		var b strings.Builder
+		pos := 1
+		for i, v := range chars {
+			if v.Position.Start < pos {
+				continue
+			}
+			b.WriteString(decode(Field(rec, pos, v.Position.Start-1), dec))
+			text := strings.TrimRight(decode(Field(rec, v.Position.Start, v.Position.Finish), dec), " ")
+			if len(rec) >= v.Position.Start {
+				b.WriteString(text + strings.Repeat(" ", widths[i]-len(text)))
+			}
+			pos = v.Position.Finish + 1
+		}
+		if pos <= len(rec) {
+			b.WriteString(decode(rec[pos-1:], dec))
+		}
+		_, err := w.WriteString(b.String() + "\n")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return WidenVariables(chars, widths, d), w.Flush()
+}
+
+/* Widens the variables of d to the widths given and moves the variables behind each along.
+Returns the names of the variables that got wider. */
+func WidenVariables(vars []*Variable, widths []int, d *Variables) []string {
+	var widened []string
+	shifts := make([]int, len(d.Variable))
+	for i, v := range vars {
+		extra := widths[i] - v.Width()
+		if extra == 0 {
+			continue
+		}
+		widened = append(widened, v.Name)
+		for j, other := range d.Variable {
+			if other.Position.Start > v.Position.Finish {
+				shifts[j] += extra
+			}
+		}
+		v.Position.Finish += extra
+	}
+	for j := range d.Variable {
+		d.Variable[j].Position.Start += shifts[j]
+		d.Variable[j].Position.Finish += shifts[j]
+	}
+	return widened
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+
+/* Record numbers kept per problem to point at in the report */
+const maxExamples = 5
+
+/* A kind of violation found in the data of a variable, or of the records themselves when Variable is empty */
+type Problem struct {
+	Variable	string
+	Message		string
+	Count		int
+	Records		[]int		// the first maxExamples records it occurs in
+}
+
+func (p Problem) String() string {
+	records := make([]string, len(p.Records))
+	for i, n := range p.Records {
+		records[i] = strconv.Itoa(n)
+	}
+	prefix, noun := "", "record"
+	if p.Variable != "" {
+		prefix = p.Variable + ": "
+	}
+	if len(records) > 1 {
+		noun = "records"
+	}
+	return fmt.Sprintf("%s%s: %d, first in %s %s", prefix, p.Message, p.Count, noun, strings.Join(records, ", "))
+}
+
+/* Collects problems in the order they are first found. With a Report every occurrence is also written
+to it, one line per record and problem. */
+type Problems struct {
+	Report	io.Writer
+	err	error
+	list	[]*Problem
+	index	map[string]*Problem
+}
+
+func (ps *Problems) Add(variable string, message string, n int) {
+	if ps.index == nil {
+		ps.index = make(map[string]*Problem)
+	}
+	key := variable + "\x00" + message
+	p, ok := ps.index[key]
+	if !ok {
+		p = &Problem{Variable: variable, Message: message}
+		ps.index[key] = p
+		ps.list = append(ps.list, p)
+	}
+	p.Count++
+	if ps.Report != nil && ps.err == nil {
+		prefix := ""
+		if variable != "" {
+			prefix = variable + ": "
+		}
+		_, ps.err = fmt.Fprintf(ps.Report, "record %d: %s%s\n", n, prefix, message)
+	}
+	if len(p.Records) < maxExamples {
+		p.Records = append(p.Records, n)
+	}
+}
+
+func (ps *Problems) List() []Problem {
+	list := make([]Problem, len(ps.list))
+	for i, p := range ps.list {
+		list[i] = *p
+	}
+	return list
+}
+
+/* Reports whether s is a number as fixed-width data holds them: digits with an optional sign
+and decimal point. */
+func IsNumber(s string) bool {
+	s = strings.TrimLeft(s, "+-")
+	digits := strings.Trim(strings.Replace(s, ".", "", 1), "0123456789")
+	return digits == "" && strings.ContainsAny(s, "0123456789") && strings.Count(s, ".") <= 1
+}
+
+/* Returns what is wrong with a value of the column, or an empty string when it fits its type.
+Blank values are missing and always fit. */
+func CheckField(c Column, rec string) string {
+	field := Field(rec, c.Start, c.Finish)
+	value := strings.TrimSpace(field)
+	if c.Overpunch {
+		value = Unpunch(value)
+	}
+	if value == "" {
+		return ""
+	}
+	switch {
+	case c.Type == "character":
+		if !utf8.ValidString(field) {
+			return "values that are not valid UTF-8"
+		}
+	case c.Type == "date":
+		_, err := time.Parse(SssDate, value)
+		if _, iso := time.Parse(IsoDate, value); err != nil && iso != nil {
+			return "values that are not a date YYYYMMDD"
+		}
+	case c.Type == "time":
+		_, err := time.Parse(SssTime, value)
+		if _, iso := time.Parse(IsoTime, value); err != nil && iso != nil {
+			return "values that are not a time HHMMSS"
+		}
+	case c.Type == "logical" || c.Type == "multiple" && c.Start == c.Finish:
+		if value != "0" && value != "1" {
+			return "values that are not 0 or 1"
+		}
+	default:
+		if !IsNumber(value) {
+			return "values that are not numbers"
+		} else if c.Type != "quantity" && strings.Contains(strings.TrimRight(value, "0"), ".") {
+			return "values that are not whole numbers"
+		}
+	}
+	return ""
+}
+
+/* Returns a message naming the code of a single or count-coded multiple column when the values of the
+metadata do not list it, or an empty string. Zero marks an unused sub-field of a multiple. */
+func CheckCode(c Column, rec string) string {
+	if c.Type != "single" && !(c.Type == "multiple" && c.Start < c.Finish) {
+		return ""
+	}
+	value := CleanField(c, rec)
+	if value == "" || c.Type == "multiple" && value == "0" {
+		return ""
+	}
+	for _, l := range c.Labels {
+		if value == strconv.Itoa(l.Value) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("code %s not in the values of the metadata", value)
+}
+
+/* Returns for every column of the layout up to length+1 the variable whose finish comes last before it,
+the one content in a column no variable covers has spilled from. */
+func spillSources(d *Variables, length int) ([]bool, []string) {
+	covered := make([]bool, length+2)
+	before := make([]string, length+2)
+	last := make([]int, length+2)
+	for _, v := range d.Variable {
+		for col := v.Position.Start; col <= v.Position.Finish && col <= length; col++ {
+			covered[col] = true
+		}
+		for col := v.Position.Finish + 1; col <= length+1; col++ {
+			if v.Position.Finish > last[col] {
+				last[col], before[col] = v.Position.Finish, v.Name
+			}
+		}
+	}
+	return covered, before
+}
+
+/* Reads the data file and checks it against the metadata: every record should be as long as the
+last finish position, no content should lie outside the fields, every value should fit the type
+of its variable, every code should be one of its values and every case should have a serial of its own. With a report every problem is also written to it with its record number. */
+func ValidateData(asc string, report io.Writer, d *Variables) ([]Problem, error) {
+	var cols []Column
+	length := 0
+	for _, v := range d.Variable {
+		cols = append(cols, v.Columns()...)
+		if v.Position.Finish > length {
+			length = v.Position.Finish
+		}
+	}
+	covered, before := spillSources(d, length)
+	serial := Serial(d)
+	serials := make(map[string]bool)
+	ps := Problems{Report: report}
+	err := ReadRecords(asc, func(n int, rec string) error {
+		if serial != nil {
+			id := strings.TrimSpace(Field(rec, serial.Position.Start, serial.Position.Finish))
+			if id == "" {
+				ps.Add(serial.Name, "blank serials", n)
+			} else if serials[id] {
+				ps.Add(serial.Name, "serials already used by an earlier record", n)
+			} else {
+				serials[strings.Clone(id)] = true
+			} // A copy, so the serials kept do not hold on to their whole records
+		}
+		if len(rec) < length {
+			ps.Add("", fmt.Sprintf("records shorter than the %d columns of the metadata", length), n)
+		} else if len(rec) > length {
+			ps.Add("", fmt.Sprintf("records longer than the %d columns of the metadata", length), n)
+		}
+		spilled := make(map[string]bool)
+		for col := 1; col <= len(rec); col++ {
+			if col <= length && covered[col] || rec[col-1] == ' ' {
+				continue
+			}
+			source := before[length+1]
+			if col <= length {
+				source = before[col]
+			}
+			if source != "" && !spilled[source] {
+				spilled[source] = true
+				ps.Add(source, "content past the finish column", n)
+			}
+		}
+		for _, c := range cols {
+			if msg := CheckField(c, rec); msg != "" {
+				ps.Add(c.Name, msg, n)
+			} else if msg := CheckCode(c, rec); msg != "" {
+				ps.Add(c.Name, msg, n)
+			}
+		}
+		return ps.err
+	})
+	return ps.List(), err
+}
+
+/* Writes a copy of the data file with the values of numeric columns that do not fit their type blanked,
+so they read as missing. Returns the number of values blanked. */
+func CleanData(asc string, out string, d *Variables) (int, error) {
+	var cols []Column
+	for _, v := range d.Variable {
+		for _, c := range v.Columns() {
+			if c.Print != "" {
+				cols = append(cols, c)
+			}
+		}
+	}
+	file, err := Create(out)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	blanked := 0
+	err = ReadRecords(asc, func(n int, rec string) error {
+		b := []byte(rec)
+		for _, c := range cols {
+			if CheckField(c, rec) == "" {
+				continue
+			}
+			for i := c.Start - 1; i < c.Finish && i < len(b); i++ {
+				b[i] = ' '
+			}
+			blanked++
+		}
+		_, err := w.Write(append(b, '\n'))
+		return err
+	})
+	if err != nil {
+		return blanked, err
+	}
+	return blanked, w.Flush()
+}
+
+/* Runs the validate command checking the data file against the metadata. Every problem is printed and
+any problem makes it return an error, so scripts can tell a good delivery from a bad one. */
+func ValidateCommand(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.StringVar(reportFile, "report", "", "write every problem with its record number to this file")
+	fs.IntVar(sample, "sample", 0, "check only the first n records")
+	paths := parseArgs(fs, args)
+	if len(paths) < 1 {
+		return UsageError("Usage: XMLtoSPS validate [-report file] [-sample n] <XML:filepath> [ASC:filepath]")
+	}
+	d, err := ReadMetadata(paths[0])
+	if err != nil {
+		return err
+	} // Fails first on XML that is not well-formed, with its location
+	schema, err := CheckSchemaFile(paths[0])
+	if err != nil {
+		return err
+	}
+	schema = append(schema, CheckMetadata(d)...)
+	for _, p := range schema {
+		fmt.Println(p)
+	}
+	unread, err := UnsupportedFile(paths[0])
+	if err != nil {
+		return err
+	}
+	for _, u := range unread {
+		fmt.Println("not converted: " + u)
+	}
+	if len(schema) > 0 {
+		return fmt.Errorf("%d problems found in %s: %w", len(schema), paths[0], ErrSchema)
+	}
+	for _, g := range Gaps(d) {
+		fmt.Println(g)
+	} // Columns left out on purpose are no error
+
+	asc := ""
+	if len(paths) > 1 {
+		asc = paths[1]
+	} else if d.Href == "" {
+		return nil
+	} else if asc, err = DataPath(paths[0], d.Href); err != nil {
+		return err
+	} // Without a data file only the metadata is checked
+	var report io.Writer
+	var buf *bufio.Writer
+	if *reportFile != "" {
+		file, err := Create(*reportFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		buf = bufio.NewWriter(file)
+		report = buf
+	}
+	problems, err := ValidateData(asc, report, d)
+	if err != nil {
+		return err
+	}
+	if buf != nil {
+		err = buf.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d kinds of problems found in %s: %w", len(problems), asc, ErrInvalid)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+
+/* Asks the questions of the wizard on a terminal */
+type wizard struct {
+	in		*bufio.Reader
+	out		io.Writer
+}
+
+/* Returns the answer to question, or def when it is left empty. */
+func (w *wizard) ask(question string, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	line, err := w.in.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return "", errors.New("the wizard was left before the conversion")
+	} else if err != nil && err != io.EOF {
+		return "", err
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+/* Returns one of the numbered options, which can also be given by itself, or anything else when free. */
+func (w *wizard) choose(question string, options []string, def string, free bool) (string, error) {
+	for i, o := range options {
+		fmt.Fprintf(w.out, "  %d) %s\n", i+1, o)
+	}
+	for {
+		answer, err := w.ask(question, def)
+		if err != nil {
+			return "", err
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+			return options[n-1], nil
+		}
+		if free || contains(options, answer) {
+			return answer, nil
+		}
+		fmt.Fprintf(w.out, "Please give a number from 1 to %d\n", len(options))
+	}
+}
+
+/* Returns whether question is answered with yes. */
+func (w *wizard) yes(question string, def bool) (bool, error) {
+	d := "n"
+	if def {
+		d = "y"
+	}
+	for {
+		answer, err := w.ask(question+" (y/n)", d)
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+/* Returns the arguments of a command line quoted where the shell needs it, in single quotes so nothing
+in them is expanded. */
+func commandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = a
+		if a == "" || strings.ContainsAny(a, " \t\n\"'$`\\&|;<>()*?[]{}~#!") {
+			quoted[i] = "'" + strings.Replace(a, "'", `'\''`, -1) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+/* Walks through the choice of the Triple-S file, its data file, the outputs and the options with a
+look at the survey and its first cases, then shows the command line doing the same and runs it. */
+func Wizard(in io.Reader, out io.Writer) error {
+	w := &wizard{bufio.NewReader(in), out}
+	fmt.Fprintln(out, "This wizard converts a Triple-S survey. Press Enter to keep the answer in brackets.")
+
+	files, _ := filepath.Glob("*.xml")
+	def := ""
+	if len(files) > 0 {
+		def = "1"
+		fmt.Fprintln(out, "\nTriple-S files in this folder:")
+	}
+	var metadata string
+	var d *Variables
+	for d == nil {
+		var err error
+		metadata, err = w.choose("Triple-S file, by number or path", files, def, true)
+		if err != nil {
+			return err
+		}
+		if d, err = ReadMetadata(metadata); err != nil {
+			fmt.Fprintln(out, err)
+		}
+	}
+	length := 0
+	for _, v := range d.Variable {
+		if v.Position.Finish > length {
+			length = v.Position.Finish
+		}
+	}
+	fmt.Fprintf(out, "\n%s %s: %d variables in records of %d columns\n", d.Name, d.Title, len(d.Variable), length)
+
+	data := DataFileFor(metadata)
+	if d.Href != "" {
+		data, _ = DataPath(metadata, d.Href)
+	}
+	for {
+		var err error
+		data, err = w.ask("\nData file", data)
+		if err != nil {
+			return err
+		}
+		if _, err = os.Stat(data); err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+		fmt.Fprintln(out, "\nThe first cases:")
+		err = Preview(data, 1, 5, out, d)
+		if err != nil {
+			fmt.Fprintln(out, err)
+		}
+		ok, err := w.yes("Is this the right data file", true)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+	}
+
+	args := []string{"convert"}
+	fmt.Fprintln(out, "\nOutputs, several can be given separated by commas:")
+	for {
+		to, err := w.choose("Write", Outputs, "sps", true)
+		if err != nil {
+			return err
+		}
+		targets, err := OutputTargets(to)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+		if to != "sps" {
+			args = append(args, "-to", to)
+		}
+		if targets["sps"] {
+			fmt.Fprintln(out, "\nSyntax language:")
+			dialect, err := w.choose("Syntax for", []string{"spss", "pspp"}, "spss", false)
+			if err != nil {
+				return err
+			}
+			if dialect != "spss" {
+				args = append(args, "-dialect", dialect)
+			}
+		}
+		break
+	}
+
+	fmt.Fprintln(out, "\nCharacter set of the data file:")
+	charset, err := w.choose("Character set", []string{"utf-8", "latin1", "windows-1252", "ebcdic"}, "utf-8", false)
+	if err != nil {
+		return err
+	}
+	if charset != "utf-8" {
+		args = append(args, "-data-encoding", charset)
+	}
+	options := []struct {
+		question	string
+		flag		string
+	}{
+		{"\nCheck the data against the metadata first", "-validate"},
+		{"Add the survey title and a description to the file", "-document"},
+		{"Overwrite earlier syntax and system files", "-force"},
+	}
+	for _, o := range options {
+		ok, err := w.yes(o.question, false)
+		if err != nil {
+			return err
+		}
+		if ok {
+			args = append(args, o.flag)
+		}
+	}
+	folder, err := w.ask("Folder to write to, Enter for the folder of the Triple-S file", "")
+	if err != nil {
+		return err
+	}
+	if folder != "" {
+		args = append(args, "-o", folder+"/")
+	}
+	args = append(args, metadata, data)
+
+	fmt.Fprintf(out, "\nThe same conversion on the command line:\n  XMLtoSPS %s\n\n", commandLine(args))
+	ok, err := w.yes("Convert now", true)
+	if err != nil || !ok {
+		return err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(self, args...)
+	cmd.Stdout, cmd.Stderr = out, os.Stderr
+	return cmd.Run()
+}
+
+/* Runs the interactive wizard on standard input and output. */
+func WizardCommand(args []string) error {
+	fs := flag.NewFlagSet("wizard", flag.ExitOnError)
+	if len(parseArgs(fs, args)) > 0 {
+		return UsageError("Usage: XMLtoSPS wizard")
+	}
+	return Wizard(os.Stdin, os.Stdout)
+}
@@ -11,34 +11,415 @@ $ xmltosps C:/MySurvey.xml C:/MySurvey.asc
 
 Will result in an MySurvey.sps file to be created in the same folder as the executable xmltosps.exe
 
+A Triple-S file given as - is read from standard input, and -o - writes the syntax to standard output:
+
+$ cat MySurvey.xml | xmltosps convert -o - - MySurvey.asc > MySurvey.sps
+
+A - after the file paths writes the syntax to standard output as -o - does, reading the data file the
+Triple-S file names:
+
+$ cat MySurvey.xml | xmltosps convert - - > MySurvey.sps
+
+The data file may be left out when the record of the Triple-S file names it with href, which is read
+relative to the Triple-S file.
+
+Files in object storage are given by their s3:// or gs:// URL, as the Triple-S file, the data files
+and -o, and download links, such as the signed links of panel providers, by their http:// or https://
+URL as the Triple-S file and data files. They are downloaded to a temporary folder, a data file named
+by href relative to the URL of the Triple-S file, and the outputs are uploaded once written, to the
+folder of -o when it ends with / or has no extension. Outputs larger than 64 MB go up in parts, as a
+multipart upload to S3 and a resumable upload to Cloud Storage, as S3 takes no single upload over 5 GB:
+
+$ xmltosps -to sps,csv -o s3://results/wave3/ s3://deliveries/wave3/MySurvey.xml
+
+Credentials are found as the tools of the services find them. For S3: AWS_ACCESS_KEY_ID and
+AWS_SECRET_ACCESS_KEY, the profile AWS_PROFILE of ~/.aws/credentials, the role of the container or of
+the EC2 instance, in the region AWS_REGION, or AWS_ENDPOINT_URL for other storage with the S3 API.
+For Cloud Storage: GOOGLE_OAUTH_ACCESS_TOKEN, the file GOOGLE_APPLICATION_CREDENTIALS, the credentials
+of gcloud auth application-default login, or the service account of the machine. As SPSS reads no
+URLs, a downloaded data file is kept with the outputs as MySurvey.asc for the syntax to read, and the
+outputs of a downloaded Triple-S file go to the current folder without -o. Download links are kept out
+of the log without their query, which often holds the signature.
+
+Gzip compressed Triple-S and data files, such as MySurvey.xml.gz and MySurvey.asc.gz, are read as they
+are, known by their first bytes whatever their name. SPSS reads no compressed data, so a compressed data
+file is decompressed next to the syntax as MySurvey.asc for it to read; other commands read a
+decompressed copy in a temporary folder.
+
+A delivery zipped with the Triple-S file and the data file is given as it is, also from a URL:
+
+$ xmltosps -to sps,sav Delivery.zip
+
+The Triple-S file is the member with the sss root element, and the data file the one its href names,
+or else the member ending in .asc, .dat or .txt, gzip compressed or not, with the name of the Triple-S
+file when there are several. The outputs are named after the Triple-S file, in the folder of the
+archive without -o, and the data file is kept with them for the syntax to read.
+
+A data file delivered in batches is given as several data files after the metadata:
+
+$ xmltosps C:/MySurvey.xml C:/MySurvey_1.asc C:/MySurvey_2.asc
+
+The same input always gives byte-identical syntax: variables, sub-variables and labels keep the order
+of the Triple-S file and nothing depends on the time of the run unless -timestamp is given.
+Names SPSS does not accept are made valid and cut to 64 bytes, and variables whose name is taken, also
+by the names like Q2#1 multiples are split in to, are renamed with _2, _3 and so on added. Renamed
+variables are listed in the log and in MySurvey_names.csv with their Triple-S name and ident.
+
+Commands are given as the first argument. Without one the arguments are those of convert, as in
+earlier versions:
+
+	convert [options] MySurvey.xml MySurvey.asc
+			converts the survey with the options below, which may also follow the file paths
+
+	validate [-report file] [-sample 1000] MySurvey.xml MySurvey.asc
+			checks the Triple-S file against the schema of its version and the rules of the
+			standard, then the data file, if given or named by the metadata, against the metadata,
+			and exits with an error if anything is wrong
+
+	inspect MySurvey.xml
+			prints a summary of the metadata and a table of the variables with their type,
+			positions, number of categories and label
+
+	watch [-interval 1m] [-done-dir d] [-error-dir d] [options] incoming
+			converts every Triple-S file dropped in to the folder incoming with its data file once
+			neither has changed between two looks at the folder, using the options below, and moves
+			both to incoming/done, or to incoming/error when the conversion fails. The folder is
+			looked at every -interval rather than notified of changes, which works alike on every
+			system and on network shares
+
+	data convert [-delimiter ;] [-sample 1000] MySurvey.xml MySurvey.asc
+			writes the data as MySurvey.csv with a header row, trimmed strings and normalized numbers
+
+	data repack [-delimiter ;] MySurvey.xml MySurvey.csv
+			writes delimited data as MySurvey.asc with every value at the position of the metadata,
+			a header row of column names gives the order of the fields
+
+	preview [-rows 20] [-from 1000000] MySurvey.xml MySurvey.asc
+			prints the first cases, or those from record -from on, as a table with value labels
+			in place of the codes; the data file is mapped in to memory where the system
+			supports it, so a record far in to a large file is found quickly
+
+	frequencies MySurvey.xml MySurvey.asc
+			prints the frequencies of the coded variables and minimum, maximum and mean of quantities
+
+	diff Wave1.xml Wave2.xml
+			prints the variables added, removed and renamed in Wave2 and the changed types,
+			positions, labels and value lists, and fails when there are any
+
+	merge [-o merged.xml] [-keep-positions] [-sss-version v] Main.xml Module.xml...
+			writes the variables of the files as merged.xml, with the variables of every file
+			behind those before and their data files side by side in merged.asc, joining the
+			records on the serial of Main when a file has one of the same name and otherwise by
+			their order; with -keep-positions the files describe the same records and only the
+			metadata is merged. Fails when names clash or, with -keep-positions, positions overlap
+
+	upgrade [-o MySurvey_2.xml] [-sss-version 3.0] MySurvey.xml
+			rewrites a Triple-S file of any version as another one, by default 2.0, as
+			MySurvey.sss.xml; written as an older version the filters, uses and spreads it
+			cannot hold are left out with a warning
+
+	lint [-dialect pspp] MySurvey.sps
+			checks syntax, for example edited by hand, for lines too long, strings not closed on
+			their line, labels longer than the dialect allows and commands without a period
+
+	wizard
+			asks for the Triple-S file, the data file, the outputs and the main options step by
+			step, showing the survey and its first cases, then prints the command line doing the
+			same and runs it
+
+	serve [-addr localhost:8080] [-dir folder] [-jobs 2] [-keep 1h]
+			offers the conversion over HTTP. POST /convert with the Triple-S file as the body, or
+			a multipart form with the parts metadata and data, converts it with the options given
+			as query parameters, such as ?to=sps,csv&dialect=pspp, and answers with the output, or
+			a zip archive of several. With ?async=1 it answers at once with a job whose state
+			GET /jobs/{id} gives; once done GET /jobs/{id}/result and /jobs/{id}/files/{name}
+			return the outputs and DELETE /jobs/{id} removes them, as happens -keep after the job
+			is done. A failed conversion answers with its error, 400 for wrong options and 422
+			for files that cannot be converted, also when the href of the Triple-S file names a
+			data file outside the upload
+
+Options are given before the file paths, or anywhere after convert:
+
+	-document	writes FILE LABEL and ADD DOCUMENT from the survey title, name, version and date
+	-timestamp	adds the time of conversion to the ADD DOCUMENT block written by -document
+	-dedup		sorts cases by the serial variable and flags cases sharing a serial
+	-add-labels	writes ADD VALUE LABELS instead of VALUE LABELS to keep labels already in a .sav
+	-get-data	reads the data with GET DATA /TYPE=TXT /ARRANGEMENT=FIXED instead of DATA LIST
+	-encoding ENC	encoding of the data file given to GET DATA, UTF8 by default
+	-ellipsis	ends labels cut to the SPSS limits of 255 and 120 bytes with "..."
+	-data-encoding CS	transcodes a latin1, windows-1252 or ebcdic (code page 037) data file to MySurvey_utf8.asc and converts that,
+			widening character fields whose UTF-8 text no longer fits their columns; ebcdic data
+			without line breaks is cut in to records as long as the last finish position
+	-long-text	moves character fields too wide for SPSS out of the syntax in to MySurvey_longtext.txt
+	-dialect pspp	writes syntax PSPP accepts: no MRSETS or ALTER TYPE, DATA LIST reads the data file directly
+	-to F,G		writes every output listed from the same reading of the metadata and data,
+			for example -to sps,sav,json
+	-to sav		writes MySurvey.sav with the metadata and the data directly, SPSS is not needed to run syntax
+	-to stata	writes a MySurvey.dct infix dictionary and a MySurvey.do file applying the labels
+	-to sas		writes a MySurvey.sas program with INPUT, LABEL and PROC FORMAT value labels
+	-to r		writes a MySurvey.R script reading the data with readr and labelling it with labelled
+	-to python	writes a MySurvey.py script reading the data with pandas, optionally saving it with pyreadstat
+	-to csv		converts the data to MySurvey.csv and writes the metadata as a MySurvey.json codebook
+	-to json	writes the metadata as a MySurvey.json codebook only
+	-to parquet	converts the data to MySurvey.parquet with the labels in the column metadata
+	-to xlsx	writes a MySurvey.xlsx data map with a sheet of variables and a sheet of value labels
+	-to sqlite	writes a MySurvey.sqlite database with variables and values tables of the metadata
+	-cases		with -to sqlite also loads the data into a cases table
+	-to postgres	writes a MySurvey.sql CREATE TABLE script and the data as MySurvey.tsv for its \copy
+	-to sss		writes the metadata back as a MySurvey.sss.xml Triple-S file, labels are not cut;
+			the data file may be left out, so -sss-version upgrades or downgrades a file on its own
+	-sss-version V	Triple-S version written by -to sss: 1.1, 1.2, 2.0 or 3.0, 2.0 by default
+	-to cspro	writes a MySurvey.dcf CSPro dictionary with the positions and value sets of the data file
+	-to mplus	writes a MySurvey.inp Mplus input skeleton and the numeric data as MySurvey.dat
+	-to redcap	writes a MySurvey_redcap.csv REDCap data dictionary with the fields and their choices
+	-to limesurvey	writes a MySurvey_limesurvey.txt survey structure LimeSurvey imports as a new survey
+	-from sav	reads MySurvey.sav instead and writes MySurvey.sss.xml and the data as MySurvey.asc
+	-csv-data	with -from sav also writes the data as MySurvey.csv
+	-validate	reads the data file first and reports records and values that do not fit the metadata
+	-report FILE	with -validate writes every problem found to FILE with its record number
+	-clean		writes MySurvey_clean.asc with invalid numeric values blanked and converts that
+	-drop-duplicates	writes MySurvey_dedup.asc with the first record of every serial only and converts that
+	-sample N	reads only the first N records of the data file, also for the data commands, to check
+			a large file quickly; the syntax gets N OF CASES N
+	-implied-decimals	reads quantities written without a decimal point as having the decimals of their
+			range implied, so 1234 in a range of 0.00 to 99.99 is 12.34; numbers with a point keep it
+	-overpunch	reads numbers whose last digit carries the sign as a COBOL signed overpunch, { A-I positive
+			and } J-R negative; the syntax reads them with the Z format
+	-add-files	with several data files the syntax reads every one and stacks them with ADD FILES;
+			otherwise they are written one after the other to MySurvey_all.asc and that is converted
+	-date-layout L	layout of the dates in the data file, such as ddmmyyyy or dd.mm.yy, and NAME=layout for
+			single date or time variables, e.g. ddmmyyyy,T1=hh:mm; MySurvey_dates.asc is written with
+			the dates in the Triple-S layout YYYYMMDD and the times as HHMMSS and converted
+	-iso-dates	writes the dates as YYYY-MM-DD and the times as HH:MM:SS instead; either way the SPSS
+			syntax turns date and time variables in to dates with the SDATE10 and TIME8 formats
+	-blanks-sysmis	sets BLANKS to SYSMIS while the data is read, so blank numeric fields are system missing
+			even in a session where SET BLANKS was changed, and restores the setting afterwards
+	-verbatims	writes the character variables with the serial to MySurvey_verbatims.csv for coding
+			and leaves them out of the syntax, and converts MySurvey_noverbatims.asc, a copy of the
+			data with their columns blanked
+	-anonymize-serial drop	leaves the serial variable out; hash writes MySurvey_anon.asc with every serial
+			replaced by a hash keyed with -hash-key and converts that
+	-anonymize-text blank	writes MySurvey_anon.asc with the character variables other than the serial
+			blanked and converts that; drop leaves them out
+	-hash-key KEY	key of the serial hash, without one serials can be recovered by hashing candidates
+	-manifest	writes MySurvey_manifest.json listing the files read and written with their sizes and
+			SHA-256 checksums
+	-glob PATTERN	converts every Triple-S file matching PATTERN, in which ** stands for any number of
+			directories, with the data file of the same name ending in .asc, .dat or .txt, and
+			prints a summary; -o must then be a folder, or have -name-template, and -report and
+			the profiles cannot be given, as every survey would write the same file
+	-jobs N		converts N of the surveys -glob matches at a time, 1 by default, printing the messages
+			of each conversion together once it is done
+	-cache FILE	skips the surveys of -glob and watch whose Triple-S and data files were converted
+			before with the same options, keeping the SHA-256 hashes of good conversions in FILE
+	-dry-run	runs the conversion in a temporary folder and reports the files it would write with their
+			sizes, the variables and every warning, leaving nothing behind; -log-file and -summary
+			are listed rather than written and -cache is not updated
+	-verbose	also logs every file read and written
+	-quiet		logs only warnings and errors
+	-log-format json	logs every message as a JSON object with its time, level and text
+	-progress	reports how much of the metadata and data files has been read, as a bar on a
+			terminal and every ten seconds in the log otherwise, and each step of the syntax written
+	-log-file FILE	adds every message of the run, including those -verbose would show, to the end of FILE
+			with the command line, whatever is shown on the console
+	-placeholder-labels	labels variables without a label with their name and categories without one
+			with their code; without it they are only listed in a warning, as are multiples
+			without categories, which have no variables in the syntax
+	-duplicate-labels	also warns about different codes of a variable given the same label; codes
+			given twice are always warned about
+	-strict		fails on unknown elements and attributes, variables without a name or position and
+			values that cannot be read, instead of repairing what can be repaired and leaving
+			out the rest with a warning
+	-summary FILE	writes a JSON summary of the run to FILE, also when it fails: the checksums of the
+			files read and written, the numbers of variables and records, the warnings, the time
+			taken and the exit code; with -glob and watch it lists the summary of every survey
+			and adds up their variables, records and warnings
+	-bench		reports the time of each step of the conversion, from reading the metadata to writing
+			every output, the peak memory and the bytes and variables converted per second
+	-cpuprofile FILE	writes a CPU profile of the run to FILE for go tool pprof
+	-memprofile FILE	writes a heap profile at the end of the run to FILE
+	-pprof-addr ADDR	serves the live profiles on ADDR, such as localhost:6060, at /debug/pprof/
+			while the run lasts, which with watch is until it is stopped
+	-exit-warnings	exits with 6 when the conversion finished but logged warnings
+	-fail-on-warning	stops at the first warning, such as a label cut, a variable renamed or an
+			element not converted, and exits with 6, so a pipeline can block the delivery
+	-o, -output P	writes to the directory P instead of next to the Triple-S file, or with P a file
+			such as /out/Wave3.sps to that file, naming the other outputs and the .sav Wave3 as well;
+			-o - writes the syntax to standard output
+	-confine-href	reads a data file named by the href of the metadata only when it is in the folder of
+			the Triple-S file or below it, failing on absolute paths and paths leaving it; serve
+			converts with it, so an upload cannot name a file of the server
+	-force		overwrites an existing MySurvey.sps or MySurvey.sav, which are otherwise left as they are
+			and the conversion fails, as they may have been edited by hand
+	-name-template T	names the outputs after the template T instead of the Triple-S file, replacing
+			{input}, {survey}, {version}, {title}, {date} and {time} with the name of the Triple-S
+			file and the fields of the metadata, as in "{survey}_{version}_{date}.sps"; an
+			extension is left out and characters file names cannot hold become _
+	-weight WT	weights the saved file by WT; by default the variable marked use="weight" is used
+
+The exit code tells what went wrong: 0 for success, 2 for wrong arguments or options, 3 for a Triple-S
+file that is not well-formed XML or does not follow the standard, 4 for a file that cannot be read or
+written, 5 for data that does not fit the metadata, 6 for warnings with -exit-warnings or
+-fail-on-warning and 1 for anything else.
+
 */
 
 
 package main
 import (
+	"bufio"
+	"bytes"
 	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"io"
 	"io/ioutil"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
-	"log"
+	"log/slog"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+
+/* Longest labels SPSS accepts, in bytes */
+const (
+	MaxVariableLabel = 255
+	MaxValueLabel = 120
+)
+
+/* Describes the syntax a statistics package accepts */
+type Dialect struct {
+	FileHandle		bool	// DATA LIST reads through a FILE HANDLE
+	MRSets			bool	// MRSETS is supported
+	AlterType		bool	// ALTER TYPE is supported
+	MaxVariableLabel	int
+	MaxValueLabel		int
+}
+
+var Dialects = map[string]Dialect{
+	"spss": {true, true, true, MaxVariableLabel, MaxValueLabel},
+	"pspp": {false, false, false, 255, 255},
+}
+
+/* Widest string variable SPSS supports and widest string older versions support, in bytes */
+const (
+	MaxString = 32767
+	MaxShortString = 255
 )
 
+/* Most labels written by a single VARIABLE LABELS or VALUE LABELS statement */
+const MaxCommandEntries = 1000
+
+
+/* Commands given as the first argument, convert is run by main itself */
+var Commands = map[string]func(args []string) error{"validate": ValidateCommand, "inspect": InspectCommand,
+	"data": DataCommand, "preview": PreviewCommand, "frequencies": FrequenciesCommand, "diff": DiffCommand,
+	"merge": MergeCommand, "upgrade": UpgradeCommand, "wizard": WizardCommand,
+	"lint": LintCommand, "serve": ServeCommand}
+
+/* Printed when the arguments are missing */
+const usage = "Usage: XMLtoSPS [convert] [-document] [-timestamp] [-add-labels] [-dedup] [-ellipsis] [-get-data] [-encoding enc] [-data-encoding cs] [-long-text] [-dialect spss|pspp] [-to sps|sav|stata|sas|r|python|csv|parquet|xlsx|sqlite|postgres|sss|cspro|mplus|redcap|limesurvey|json[,...]] [-cases] [-sss-version v] [-validate] [-report file] [-clean] [-drop-duplicates] [-sample n] [-implied-decimals] [-overpunch] [-date-layout l] [-iso-dates] [-blanks-sysmis] [-add-files] [-verbatims] [-anonymize-serial drop|hash] [-anonymize-text blank|drop] [-hash-key key] [-manifest] [-strict] [-placeholder-labels] [-duplicate-labels] [-glob pattern] [-jobs n] [-cache file] [-dry-run] [-verbose|-quiet] [-log-format text|json] [-log-file file] [-summary file] [-bench] [-cpuprofile file] [-memprofile file] [-pprof-addr addr] [-progress] [-exit-warnings] [-fail-on-warning] [-o path] [-confine-href] [-force] [-name-template t] [-weight name] <XML:filepath> [ASC:filepath...]\n       XMLtoSPS -to sss [-sss-version v] <XML:filepath>\n       XMLtoSPS -from sav [-csv-data] [-sss-version v] <SAV:filepath>\n       XMLtoSPS validate|inspect|watch|data|preview|frequencies|diff|merge|upgrade|wizard|lint|serve ..."
+
+var document = flag.Bool("document", false, "write FILE LABEL and ADD DOCUMENT from the survey metadata")
+var timestamp = flag.Bool("timestamp", false, "add the time of conversion to the document block")
+var dedup = flag.Bool("dedup", false, "sort cases by the serial variable and flag duplicate serials")
+var addLabels = flag.Bool("add-labels", false, "write ADD VALUE LABELS so labels applied by other syntax are kept")
+var getData = flag.Bool("get-data", false, "read the data with GET DATA /TYPE=TXT instead of DATA LIST")
+var encoding = flag.String("encoding", "UTF8", "encoding of the data file used by GET DATA")
+var ellipsis = flag.Bool("ellipsis", false, "end labels truncated to the SPSS limits with ...")
+var dataEncoding = flag.String("data-encoding", "", "transcode the data file from latin1 or windows-1252 to UTF-8 first")
+var longText = flag.Bool("long-text", false, "export character fields wider than 32767 bytes to a separate text file")
+var dialect = flag.String("dialect", "spss", "write syntax for spss or pspp")
+var to = flag.String("to", "sps", "write SPS syntax (sps), an SPSS system file (sav) or other outputs, several separated by commas")
+var cases = flag.Bool("cases", false, "with -to sqlite also load the data file into a cases table")
+var sssVersion = flag.String("sss-version", "2.0", "Triple-S version written by -to sss")
+var from = flag.String("from", "", "convert an SPSS system file (sav) to Triple-S instead")
+var csvData = flag.Bool("csv-data", false, "with -from sav also write the data as CSV")
+var validate = flag.Bool("validate", false, "check the data file against the metadata and report violations")
+var reportFile = flag.String("report", "", "with -validate write every problem with its record number to this file")
+var clean = flag.Bool("clean", false, "blank numeric values that do not fit their type in a copy of the data and convert that")
+var dropDuplicates = flag.Bool("drop-duplicates", false, "keep the first record of every serial in a copy of the data and convert that")
+var sample = flag.Int("sample", 0, "read only the first n records of the data file")
+var impliedDecimals = flag.Bool("implied-decimals", false, "read quantities without a decimal point with the decimals of their range implied")
+var overpunch = flag.Bool("overpunch", false, "read numbers whose last digit carries the sign as a COBOL overpunch")
+var addFiles = flag.Bool("add-files", false, "stack several data files with ADD FILES in the syntax instead of one after the other")
+var dateLayout = flag.String("date-layout", "", "layout of the dates in the data, e.g. ddmmyyyy, with NAME=layout for single variables")
+var isoDates = flag.Bool("iso-dates", false, "write dates as YYYY-MM-DD and times as HH:MM:SS in a copy of the data")
+var blanksSysmis = flag.Bool("blanks-sysmis", false, "write SET BLANKS=SYSMIS so blank numeric fields are system missing in any session")
+var verbatims = flag.Bool("verbatims", false, "move the character variables out to a CSV file of verbatims")
+var anonymizeSerial = flag.String("anonymize-serial", "", "drop the serial, or hash it with -hash-key in a copy of the data")
+var anonymizeText = flag.String("anonymize-text", "", "blank the character variables in a copy of the data, or drop them")
+var hashKey = flag.String("hash-key", "", "key of the hash -anonymize-serial hash replaces serials with")
+var manifest = flag.Bool("manifest", false, "list every file written with its SHA-256 checksum in a JSON manifest")
+var glob = flag.String("glob", "", "convert every Triple-S file matching this pattern with its data file")
+var jobs = flag.Int("jobs", 1, "number of the surveys -glob matches converted at a time")
+var cache = flag.String("cache", "", "file of the hashes of the surveys -glob and watch converted, to skip them when unchanged")
+var interval = flag.Duration("interval", 10*time.Second, "time between the polls of the watched folder")
+var doneDir = flag.String("done-dir", "", "folder watch moves converted surveys to, done in the watched folder by default")
+var errorDir = flag.String("error-dir", "", "folder watch moves failed surveys to, error in the watched folder by default")
+var dryRun = flag.Bool("dry-run", false, "report the files the conversion would write without writing them")
+var verbose = flag.Bool("verbose", false, "also log the files read and written")
+var quiet = flag.Bool("quiet", false, "log only warnings and errors")
+var logFormat = flag.String("log-format", "text", "format of the log: text, or json for one object per message")
+var progress = flag.Bool("progress", false, "report the progress of reading the files and writing the syntax")
+var logFile = flag.String("log-file", "", "also add every message of the run to this file")
+var placeholderLabels = flag.Bool("placeholder-labels", false, "label variables without a label with their name and categories with their code")
+var duplicateLabels = flag.Bool("duplicate-labels", false, "also warn about different codes of a variable with the same label")
+var strict = flag.Bool("strict", false, "fail on anything in the Triple-S file the converter would have to repair or leave out")
+var bench = flag.Bool("bench", false, "report the time of each step, the peak memory and the throughput")
+var pprofAddr = flag.String("pprof-addr", "", "serve the runtime profiles over HTTP on this address, such as localhost:6060")
+var cpuProfile = flag.String("cpuprofile", "", "write a CPU profile of the run to this file")
+var memProfile = flag.String("memprofile", "", "write a heap profile at the end of the run to this file")
+var summary = flag.String("summary", "", "write a JSON summary of the run to this file")
+var failOnWarning = flag.Bool("fail-on-warning", false, "stop at the first warning and exit with 6")
+var exitWarnings = flag.Bool("exit-warnings", false, "exit with 6 when the conversion logged warnings")
+var confineHref = flag.Bool("confine-href", false, "only read a data file named by href in the folder of the Triple-S file or below")
+var force = flag.Bool("force", false, "overwrite existing syntax and system files")
+var nameTemplate = flag.String("name-template", "", "name the outputs after the metadata, as in {survey}_{version}_{date}")
+var output = flag.String("output", "", "directory or syntax file to write to instead of next to the Triple-S file")
+var weight = flag.String("weight", "", "weight the saved file by this variable instead of the one marked use=\"weight\"")
+
+func init() {
+	flag.StringVar(output, "o", "", "short for -output")
+}
+
 
 /* Structures the Triple-S format */
 type Variables struct {
 	XMLName		xml.Name		`xml:"sss"`
+	SssVersion	string			`xml:"version,attr"`
+	Date		string			`xml:"date"`
+	Time		string			`xml:"time"`
+	Origin		string			`xml:"origin"`
+	User		string			`xml:"user"`
+	Name		string			`xml:"survey>name"`
+	Version		string			`xml:"survey>version"`
+	Title		string			`xml:"survey>title"`
+	Href		string			`xml:"-"`		// data file the record points to
 	Variable	[]Variable		`xml:"survey>record>variable"`
+	repaired	[]string		// what Repair made of the variables read
+	dropped		[]string		// variables Repair left out
 }
 
 type Variable struct {
 	XMLName		xml.Name		`xml:"variable"`
+	Ident		string			`xml:"ident,attr"`
 	Type		string			`xml:"type,attr"`
+	Use		string			`xml:"use,attr"`
 	Name		string			`xml:"name"`
 	Label		string			`xml:"label"`
+	Filter		string			`xml:"filter"`
 	Position	Posit
+	Spread		Spread
 	Vals		[]Val			`xml:"values>value"`
+	Ranges		[]Range			`xml:"values>range"`
+	OriginalName	string			`xml:"-"`		// name in the Triple-S file when renamed for SPSS
 }
 
 type Posit struct {
@@ -47,48 +428,298 @@ type Posit struct {
 	Finish		int			`xml:"finish,attr"`
 }
 
+type Spread struct {
+	XMLName		xml.Name		`xml:"spread"`
+	Subfields	int			`xml:"subfields,attr"`
+	Width		int			`xml:"width,attr"`
+}
+
+type Range struct {
+	From		string			`xml:"from,attr"`
+	To		string			`xml:"to,attr"`
+}
+
+/* A single SPSS column of the fixed-width data, multiples span several of these */
+type Column struct {
+	Name		string
+	Start		int
+	Finish		int
+	Format		string		// input format
+	Print		string		// print format, empty for strings
+	Implied		int		// decimals implied in numbers written without a decimal point
+	Overpunch	bool		// the last digit of numbers may carry the sign as a COBOL overpunch
+	Type		string		// Triple-S type of the variable
+	Label		string
+	Labels		[]Val
+}
+
 type Val struct {
 	Value		int			`xml:"code,attr"`
 	Name		string			`xml:",chardata"`
 }
 
 
-/* Helps determine what kind of a variable it is and appends the correct extension to the DATA LIST */
-func (v Variable) VarType() string {
-	if v.Type == "character" || v.Type == "time" {
-		return fmt.Sprintf(" (A)")
-	} else if v.Type == "date"{
-		return fmt.Sprintf(" (A)")
-	} else {
-		return fmt.Sprintf("")
+/* Returns s as a double quoted SPSS string literal. Embedded double quotes are doubled, which is how
+SPSS escapes the quote character inside a literal, so any label text can be written. */
+func Quote(s string) string {
+	return "\"" + strings.Replace(s, "\"", "\"\"", -1) + "\""
+}
+
+/* Cuts s to at most max bytes without splitting a UTF-8 sequence, ending it with "..." when ellipsis is set.
+Reports whether anything was cut. */
+func Truncate(s string, max int, ellipsis bool) (string, bool) {
+	if len(s) <= max {
+		return s, false
+	}
+	end := max
+	if ellipsis {
+		end -= 3
+	}
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	if ellipsis {
+		return s[:end] + "...", true
+	}
+	return s[:end], true
+}
+
+/* Truncates variable and value labels that exceed the limits of the dialect and returns the names of the
+variables that were affected. */
+func TruncateLabels(d *Variables, dl Dialect, ellipsis bool) []string {
+	var affected []string
+	for i := range d.Variable {
+		v := &d.Variable[i]
+		var cut, c bool
+		v.Label, cut = Truncate(v.Label, dl.MaxVariableLabel, ellipsis)
+		for j := range v.Vals {
+			v.Vals[j].Name, c = Truncate(v.Vals[j].Name, dl.MaxValueLabel, ellipsis)
+			cut = cut || c
+		}
+		if cut {
+			affected = append(affected, v.Name)
+		}
 	}
+	return affected
+}
+
+/* Writes one command whose entries are spread over several statements once it grows past
+MaxCommandEntries, so very large surveys do not hit the SPSS command limits. Entries are never split. */
+type Statement struct {
+	f	io.StringWriter
+	cmd	string
+	sep	string
+	n	int
 }
 
-/* Writes the DATA LIST statement to the SPS-syntax. */
-func DataList(o string, f *os.File, d *Variables) error {
-	_, err := f.WriteString(fmt.Sprintf("FILE HANDLE longdata\n/NAME=\"%s\".\n", o))
+/* Writes an entry holding size labels, starting a new statement when needed. */
+func (st *Statement) Entry(text string, size int) error {
+	if st.n > 0 && st.n+size > MaxCommandEntries {
+		err := st.End()
+		if err != nil {
+			return err
+		}
+	}
+	var err error
+	if st.n == 0 {
+		_, err = st.f.WriteString(fmt.Sprintf("%s\n", st.cmd))
+	} else {
+		_, err = st.f.WriteString(st.sep)
+	}
 	if err != nil {
 		return err
 	}
-	_, err = f.WriteString(fmt.Sprint("DATA LIST FILE=longdata\n/"))
+	_, err = st.f.WriteString(text)
+	if err != nil {
+		return err
+	}
+	st.n += size
+	return nil
+}
+
+/* Ends the current statement, if one was started. */
+func (st *Statement) End() error {
+	if st.n == 0 {
+		return nil
+	}
+	st.n = 0
+	_, err := st.f.WriteString(fmt.Sprint(".\n"))
+	return err
+}
+
+/* Returns the number of columns the variable occupies in the data file. */
+func (v Variable) Width() int {
+	return v.Position.Finish - v.Position.Start + 1
+}
+
+/* Returns the names of character fields wider than the longest string SPSS can hold. */
+func LongStrings(d *Variables) []string {
+	var long []string
+	for _, v := range d.Variable {
+		if v.Format() == "" && v.Width() > MaxString {
+			long = append(long, v.Name)
+		}
+	}
+	return long
+}
+
+/* Reports whether a multiple stores category codes in its sub-columns rather than 0/1 flags.
+Triple-S marks these with a spread wider than a single column. */
+func (v Variable) CountCoded() bool {
+	return v.Type == "multiple" && v.Spread.Width > 1
+}
+
+/* Returns the SPSS variable names a variable expands to; multiples get one per sub-column. */
+func (v Variable) SpssNames() []string {
+	if v.CountCoded() {
+		names := make([]string, v.Spread.Subfields)
+		for i := range names {
+			names[i] = fmt.Sprintf("%s#%d", v.Name, i+1)
+		}
+		return names
+	} else if v.Type == "multiple" {
+		names := make([]string, len(v.Vals))
+		for i, mult := range v.Vals {
+			names[i] = fmt.Sprintf("%s#%d", v.Name, mult.Value)
+		}
+		return names
+	}
+	return []string{v.Name}
+}
+
+/* Returns name as an identifier of at most max bytes made of letters, digits and underscores,
+the names other packages accept. Other characters, like the # of multiples, become underscores. */
+func Identifier(name string, max int) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || b[0] >= '0' && b[0] <= '9' {
+		b = append([]byte{'_'}, b...)
+	}
+	if len(b) > max {
+		b = b[:max]
+	}
+	return string(b)
+}
+
+/* Returns the number of decimals declared by the range bounds of a quantity. */
+func (v Variable) Decimals() int {
+	d := 0
+	for _, r := range v.Ranges {
+		for _, bound := range []string{r.From, r.To} {
+			if i := strings.Index(bound, "."); i >= 0 && len(bound)-i-1 > d {
+				d = len(bound) - i - 1
+			}
+		}
+	}
+	return d
+}
+
+/* Returns the name of the variable in the Triple-S file, before any rename for SPSS. */
+func (v Variable) SssName() string {
+	if v.OriginalName != "" {
+		return v.OriginalName
+	}
+	return v.Name
+}
+
+/* Returns the SPSS print format of a numeric variable, or an empty string for strings. */
+func (v Variable) Format() string {
+	switch v.Type {
+	case "character", "time", "date":
+		return ""
+	case "multiple":
+		if v.CountCoded() {
+			return fmt.Sprintf("F%d.0", v.Spread.Width)
+		}
+		return "F1.0"
+	case "quantity":
+		return fmt.Sprintf("F%d.%d", v.Width(), v.Decimals())
+	default:
+		return fmt.Sprintf("F%d.0", v.Width())
+	}
+}
+
+/* Helps determine what kind of a variable it is and returns the SPSS format its columns are read with.
+Numbers are read without implied decimals unless -implied-decimals is given for the quantities,
+data with a decimal point keeps its decimals regardless. With -overpunch numbers are read as zoned decimals.
+Strings wider than SPSS supports are cut to the first MaxString bytes. */
+func (v Variable) InputFormat() string {
+	format := fmt.Sprintf("F%d.0", v.Width())
+	if v.Format() == "" && v.Width() > MaxString {
+		return fmt.Sprintf("A%d", MaxString)
+	} else if v.Format() == "" {
+		return fmt.Sprintf("A%d", v.Width())
+	} else if v.Type == "multiple" || v.Type == "quantity" && *impliedDecimals {
+		format = v.Format()
+	}
+	if *overpunch {
+		return "Z" + strings.TrimPrefix(format, "F")
+	}
+	return format
+}
+
+/* Returns the value labels of the i-th column of the variable. */
+func (v Variable) ColumnLabels(i int) []Val {
+	switch {
+	case v.Type == "single" || v.CountCoded():
+		return v.Vals
+	case v.Type == "multiple":
+		return []Val{{Value: 0, Name: "No"}, {Value: 1, Name: v.Vals[i].Name}}
+	case v.Type == "logical":
+		return []Val{{Value: 0, Name: "False"}, {Value: 1, Name: "True"}}
+	}
+	return nil
+}
+
+/* Returns the columns a variable occupies in the data file with their SPSS input format and labels. */
+func (v Variable) Columns() []Column {
+	names := v.SpssNames()
+	cols := make([]Column, len(names))
+	for i, n := range names {
+		c := Column{Name: n, Start: v.Position.Start, Finish: v.Position.Finish, Format: v.InputFormat(),
+			Print: v.Format(), Type: v.Type, Label: v.Label, Labels: v.ColumnLabels(i)}
+		if v.Type == "quantity" && *impliedDecimals {
+			c.Implied = v.Decimals()
+		}
+		c.Overpunch = *overpunch && c.Print != ""
+		if v.CountCoded() {
+			c.Start = v.Position.Start + i*v.Spread.Width
+			c.Finish = c.Start + v.Spread.Width - 1
+		} else if v.Type == "multiple" {
+			c.Start, c.Finish = v.Position.Start+i, v.Position.Start+i
+		}
+		cols[i] = c
+	}
+	return cols
+}
+
+/* Writes the DATA LIST statement to the SPS-syntax.
+Every column is given with its start column and explicit input format, so SPSS infers nothing.
+Without a file handle the data file is named on DATA LIST itself. */
+func DataList(o string, handle string, f io.StringWriter, d *Variables) error {
+	var err error
+	if handle != "" {
+		_, err = f.WriteString(fmt.Sprintf("FILE HANDLE %s\n/NAME=%s.\n", handle, Quote(o)))
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(fmt.Sprintf("DATA LIST FILE=%s\n/", handle))
+	} else {
+		_, err = f.WriteString(fmt.Sprintf("DATA LIST FILE=%s\n/", Quote(o)))
+	}
 	if err != nil {
 		return err
 	}
 	for _, v := range d.Variable {
-		if v.Type != "multiple" {
-			_, err = f.WriteString(fmt.Sprintf("\t%s\t%d-%d%v\n",
-				v.Name, v.Position.Start, v.Position.Finish, v.VarType()))
+		for _, c := range v.Columns() {
+			_, err = f.WriteString("\t" + c.Name + "\t(T" + strconv.Itoa(c.Start) + "," + c.Format + ")\n")
 			if err != nil {
 				return err
 			}
-		} else {
-			for i, mult := range v.Vals {
-				_, err = f.WriteString(fmt.Sprintf("\t%s#%d\t%d-%d\n",
-					v.Name, mult.Value, v.Position.Start+i, v.Position.Start+i))
-				if err != nil {
-					return err
-				}
-			}
 		}
 	}
 	_, err = f.WriteString(fmt.Sprint(".\n\n"))
@@ -99,28 +730,61 @@ func DataList(o string, f *os.File, d *Variables) error {
 }
 
 
-/* Writes the VARIABLE LABELS statement to the SPS-syntax. */
-func VariableLabels(f *os.File, d *Variables) error {
-	_, err := f.WriteString(fmt.Sprint("VARIABLE LABELS\n"))
+/* Writes a GET DATA statement reading the fixed-width file, an alternative to DATA LIST.
+GET DATA counts columns from 0, so the Triple-S positions are shifted by one. */
+func GetData(o string, enc string, f io.StringWriter, d *Variables) error {
+	_, err := f.WriteString(fmt.Sprintf("GET DATA\n/TYPE=TXT\n/FILE=%s\n/ENCODING=%s\n"+
+		"/ARRANGEMENT=FIXED\n/FIRSTCASE=1\n/VARIABLES=\n/1", Quote(o), Quote(enc)))
 	if err != nil {
 		return err
 	}
 	for _, v := range d.Variable {
-		if v.Type != "multiple" {
-			_, err = f.WriteString(fmt.Sprintf("\t%s\t\"%s\"\n", v.Name, v.Label))
+		for _, c := range v.Columns() {
+			_, err = f.WriteString("\t" + c.Name + "\t" + strconv.Itoa(c.Start-1) + "-" + strconv.Itoa(c.Finish-1) + "\t" + c.Format + "\n")
 			if err != nil {
 				return err
 			}
+		}
+	}
+	_, err = f.WriteString(fmt.Sprint(".\n\n"))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+
+/* Reads every part of a data file split in batches in to a dataset of its own and stacks them in to one
+with ADD FILES. Each part gets a file handle of its own when handle is given. */
+func AddFiles(parts []string, handle string, getData bool, enc string, f io.StringWriter, d *Variables) error {
+	var err error
+	for i, p := range parts {
+		if getData {
+			err = GetData(p, enc, f, d)
+		} else if handle != "" {
+			err = DataList(p, fmt.Sprintf("%s%d", handle, i+1), f, d)
 		} else {
-			for _, mult := range v.Vals {
-				_, err = f.WriteString(fmt.Sprintf("\t%s#%d\t\"%s\"\n", v.Name, mult.Value, v.Label))
-				if err != nil {
-					return err
-				}
-			}
+			err = DataList(p, "", f, d)
+		}
+		if err != nil {
+			return err
 		}
+		_, err = f.WriteString(fmt.Sprintf("DATASET NAME part%d.\n\n", i+1))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = f.WriteString("ADD FILES")
+	if err != nil {
+		return err
 	}
-	_, err = f.WriteString(fmt.Sprint(".\nEXECUTE.\n\n\n"))
+	for i := range parts {
+		_, err = f.WriteString(fmt.Sprintf("\n/FILE=part%d", i+1))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = f.WriteString(".\nEXECUTE.\nDATASET CLOSE ALL.\n\n")
 	if err != nil {
 		return err
 	}
@@ -128,50 +792,161 @@ func VariableLabels(f *os.File, d *Variables) error {
 }
 
 
-/* Writes the VALUE LABELS statement to the SPS-syntax. */
-func ValueLabels(f *os.File, d *Variables) error {
-	_, err := f.WriteString(fmt.Sprint("VALUE LABELS\n"))
+/* Writes SET BLANKS=SYSMIS so blank numeric fields read as system missing whatever BLANKS was set to
+in the session. PRESERVE keeps the setting of the session for RestoreBlanks. */
+func SetBlanks(f io.StringWriter) error {
+	_, err := f.WriteString("PRESERVE.\nSET BLANKS=SYSMIS.\n\n")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+/* Reads the data with EXECUTE, since BLANKS applies while the data is read, and restores the setting
+SetBlanks preserved. */
+func RestoreBlanks(f io.StringWriter) error {
+	_, err := f.WriteString("EXECUTE.\nRESTORE.\n\n")
 	if err != nil {
 		return err
 	}
+	return nil
+}
+
+
+/* Returns the SPSS date format of a date or time variable, SDATE10 or TIME8, or an empty string for
+other variables and for fields whose width fits neither the Triple-S nor the -iso-dates layout. */
+func (v Variable) DateFormat() string {
+	switch {
+	case v.Type == "date" && (v.Width() == len(SssDate) || v.Width() == len(IsoDate)):
+		return "SDATE10"
+	case v.Type == "time" && (v.Width() == len(SssTime) || v.Width() == len(IsoTime)):
+		return "TIME8"
+	}
+	return ""
+}
+
+/* Writes the syntax turning the date and time variables, read by DATA LIST as strings, in to SPSS dates
+and times. Triple-S writes them without separators, which SPSS does not read, so they are first given
+the separators of YYYY-MM-DD and HH:MM:SS; the copy written by -iso-dates has them already. */
+func DateTypes(f io.StringWriter, d *Variables) error {
 	for _, v := range d.Variable {
-		if v.Type == "single" {
-			_, err = f.WriteString(fmt.Sprintf("\t%s\n", v.Name))
+		format := v.DateFormat()
+		if format == "" {
+			continue
+		}
+		if v.Type == "date" && v.Width() == len(SssDate) {
+			_, err := f.WriteString(fmt.Sprintf("ALTER TYPE %[1]s (A10).\nIF (%[1]s <> '') %[1]s = CONCAT(CHAR.SUBSTR(%[1]s,1,4),'-',CHAR.SUBSTR(%[1]s,5,2),'-',CHAR.SUBSTR(%[1]s,7,2)).\n", v.Name))
 			if err != nil {
 				return err
 			}
-			for _, vs := range v.Vals {
-				_, err = f.WriteString(fmt.Sprintf("\t\t%d \"%s\"\n", vs.Value, vs.Name))
-				if err != nil {
-					return err
-				}
-			}
-			_, err = f.WriteString(fmt.Sprint("/"))
+		} else if v.Type == "time" && v.Width() == len(SssTime) {
+			_, err := f.WriteString(fmt.Sprintf("ALTER TYPE %[1]s (A8).\nIF (%[1]s <> '') %[1]s = CONCAT(CHAR.SUBSTR(%[1]s,1,2),':',CHAR.SUBSTR(%[1]s,3,2),':',CHAR.SUBSTR(%[1]s,5,2)).\n", v.Name))
 			if err != nil {
 				return err
 			}
+		}
+		_, err := f.WriteString(fmt.Sprintf("ALTER TYPE %s (%s).\n\n", v.Name, format))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Writes the FORMATS statement so widths and decimals of numeric variables are explicit, and with dates
+the SDATE10 and TIME8 formats of the date and time variables DateTypes made. FORMATS does not apply to
+strings; character fields keep the width read by DATA LIST. Without any variable to give a format the
+statement is left out, as SPSS rejects an empty one. */
+func Formats(f io.StringWriter, d *Variables, dates bool) error {
+	var lines []string
+	for _, v := range d.Variable {
+		format := v.Format()
+		if dates && v.DateFormat() != "" {
+			format = v.DateFormat()
+		}
+		if format == "" {
+			continue
+		}
+		if v.CountCoded() {
+			for i := 0; i < v.Spread.Subfields; i++ {
+				lines = append(lines, fmt.Sprintf("\t%s#%d\t(%s)\n", v.Name, i+1, format))
+			}
 		} else if v.Type == "multiple" {
 			for _, mult := range v.Vals {
-				_, err = f.WriteString(fmt.Sprintf("\t%s#%d\n", v.Name, mult.Value))
+				lines = append(lines, fmt.Sprintf("\t%s#%d\t(%s)\n", v.Name, mult.Value, format))
+			}
+		} else {
+			lines = append(lines, "\t"+v.Name+"\t("+format+")\n")
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	_, err := f.WriteString("FORMATS\n" + strings.Join(lines, "") + ".\n\n")
+	return err
+}
+
+
+/* Writes the VARIABLE LABELS statement to the SPS-syntax. */
+func VariableLabels(f io.StringWriter, d *Variables) error {
+	st := &Statement{f: f, cmd: "VARIABLE LABELS"}
+	for _, v := range d.Variable {
+		for _, n := range v.SpssNames() {
+			err := st.Entry("\t"+n+"\t"+Quote(v.Label)+"\n", 1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err := st.End()
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(fmt.Sprint("EXECUTE.\n\n\n"))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+
+/* Writes the VALUE LABELS statement to the SPS-syntax, or ADD VALUE LABELS when add is set. */
+func ValueLabels(add bool, f io.StringWriter, d *Variables) error {
+	st := &Statement{f: f, cmd: "VALUE LABELS", sep: "/"}
+	if add {
+		st.cmd = "ADD VALUE LABELS"
+	}
+	var err error
+	for _, v := range d.Variable {
+		if v.Type == "single" || v.CountCoded() {
+			var labels strings.Builder
+			for _, vs := range v.Vals {
+				labels.WriteString("\t\t" + strconv.Itoa(vs.Value) + " " + Quote(vs.Name) + "\n")
+			}
+			for _, n := range v.SpssNames() {
+				err = st.Entry("\t"+n+"\n"+labels.String(), len(v.Vals))
 				if err != nil {
 					return err
 				}
-				_, err = f.WriteString(fmt.Sprintf("\t\t0\"No\"\n\t\t1 \"%s\"\n/", mult.Name))
+			}
+		} else if v.Type == "multiple" {
+			for _, mult := range v.Vals {
+				err = st.Entry(fmt.Sprintf("\t%s#%d\n\t\t0\"No\"\n\t\t1 %s\n", v.Name, mult.Value, Quote(mult.Name)), 2)
 				if err != nil {
 					return err
 				}
 			}
 		} else if v.Type == "logical" {
-			_, err = f.WriteString(fmt.Sprintf("\t%s\n", v.Name))
-			if err != nil {
-				return err
-			}
-			_, err = f.WriteString(fmt.Sprint("\t\t0\"False\"\n\t\t1 \"True\"\n/"))
+			err = st.Entry(fmt.Sprintf("\t%s\n\t\t0\"False\"\n\t\t1 \"True\"\n", v.Name), 2)
 			if err != nil {
 				return err
 			}
 		}
 	}
+	err = st.End()
+	if err != nil {
+		return err
+	}
 	_, err = f.WriteString(fmt.Sprint("EXECUTE.\n\n"))
 	if err != nil {
 		return err
@@ -180,47 +955,1156 @@ func ValueLabels(f *os.File, d *Variables) error {
 }
 
 
-/* Creates a line to save the SPSS file as a *.sav */
-func SaveToSPSS(p string, fn string, f *os.File) error {
-	_, err := f.WriteString(fmt.Sprintf("SAVE OUTFILE='%s/%s.sav'\n/COMPRESSED.", p, fn))
+/* Writes the MRSETS statement so every multiple is available as a multiple-response set.
+Flag multiples become dichotomy groups counting the value 1, count-coded multiples become category groups. */
+func MultipleResponseSets(f io.StringWriter, d *Variables) error {
+	var sets []string
+	for _, v := range d.Variable {
+		if v.CountCoded() && v.Spread.Subfields > 0 {
+			sets = append(sets, fmt.Sprintf("/MCGROUP NAME=$%s LABEL=%s VARIABLES=%s#1 TO %s#%d\n",
+				v.Name, Quote(v.Label), v.Name, v.Name, v.Spread.Subfields))
+		} else if v.Type == "multiple" && !v.CountCoded() && len(v.Vals) > 0 {
+			sets = append(sets, fmt.Sprintf("/MDGROUP NAME=$%s LABEL=%s VARIABLES=%s#%d TO %s#%d VALUE=1\n",
+				v.Name, Quote(v.Label), v.Name, v.Vals[0].Value, v.Name, v.Vals[len(v.Vals)-1].Value))
+		}
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+	_, err := f.WriteString(fmt.Sprint("MRSETS\n"))
+	if err != nil {
+		return err
+	}
+	for _, set := range sets {
+		_, err = f.WriteString(set)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = f.WriteString(fmt.Sprint(".\n\n"))
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 	return nil
 }
 
 
-func main() {
-	if len(os.Args) < 3 {
-		log.Fatalln("Usage: XMLtoSPS <XML:filepath> <ASC:filepath>")
-	} // Makes sure we have enough arguments to run the program
-	input := os.Args[1]
-	xmlFile, err := os.Open(input) // Opens the XML file
-	if err != nil {
-		log.Fatalln(err)
+/* Writes the VARIABLE ROLE statement from the Triple-S use attributes.
+SPSS has no identifier or weight role, so serial and weight variables are kept out of models with NONE. */
+func VariableRoles(f io.StringWriter, d *Variables) error {
+	var none []string
+	input := make([]string, 0, len(d.Variable))
+	for _, v := range d.Variable {
+		if v.Use == "serial" || v.Use == "weight" {
+			none = append(none, v.SpssNames()...)
+		} else {
+			input = append(input, v.SpssNames()...)
+		}
 	}
-	defer xmlFile.Close()
-
-	b, _ := ioutil.ReadAll(xmlFile)
-	data := new(Variables)
-	xml.Unmarshal(b, &data) // Unmarshals the XML file
-
-	fn := fmt.Sprint(strings.Trim(path.Base(input), path.Ext(input)))
-	file, err := os.Create(fmt.Sprintf("%s/%s.sps", path.Dir(input), fn)) // Creates the SPS file
+	_, err := f.WriteString(fmt.Sprint("VARIABLE ROLE\n"))
 	if err != nil {
-		log.Fatalf("Please use forward slash in file path. As an example C:/Users/...\n%v", err)
+		return err
+	}
+	for _, role := range []struct {
+		name  string
+		names []string
+	}{{"NONE", none}, {"INPUT", input}} {
+		if len(role.names) == 0 {
+			continue
+		}
+		_, err = f.WriteString(fmt.Sprintf("/%s\n", role.name))
+		if err != nil {
+			return err
+		}
+		for _, n := range role.names {
+			_, err = f.WriteString("\t" + n + "\n")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = f.WriteString(fmt.Sprint(".\n\n"))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+
+/* Writes VARIABLE ATTRIBUTE statements carrying the original Triple-S metadata in to the .sav file. */
+func VariableAttributes(f io.StringWriter, d *Variables) error {
+	_, err := f.WriteString(fmt.Sprint("VARIABLE ATTRIBUTE\n"))
+	if err != nil {
+		return err
+	}
+	for i, v := range d.Variable {
+		sep := "/"
+		if i == 0 {
+			sep = " "
+		}
+		_, err = f.WriteString(sep + "VARIABLES=" + strings.Join(v.SpssNames(), " ") + "\n\tATTRIBUTE=sssIdent(" +
+			Quote(v.Ident) + ") sssType(" + Quote(v.Type) + ") sssName(" + Quote(v.SssName()) + ")")
+		if err != nil {
+			return err
+		}
+		if v.Filter != "" {
+			_, err = f.WriteString(fmt.Sprintf(" sssFilter(%s)", Quote(v.Filter)))
+			if err != nil {
+				return err
+			}
+		}
+		_, err = f.WriteString("\n")
+		if err != nil {
+			return err
+		}
+	}
+	_, err = f.WriteString(fmt.Sprint(".\n\n"))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+
+/* Returns the lines documenting the survey and where the converted file came from.
+The time of conversion is only included when stamp is not empty, so output stays reproducible by default. */
+func DocumentLines(in string, stamp string, d *Variables) []string {
+	lines := []string{
+		fmt.Sprintf("Survey: %s", d.Name),
+		fmt.Sprintf("Version: %s", d.Version),
+		fmt.Sprintf("Date: %s %s", d.Date, d.Time),
+		fmt.Sprintf("Origin: %s", d.Origin),
+		fmt.Sprintf("Converted from Triple-S file %s by xmltosps", in),
+	}
+	if stamp != "" {
+		lines = append(lines, fmt.Sprintf("Converted on %s", stamp))
+	}
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return lines
+}
+
+/* Writes FILE LABEL from the survey title and an ADD DOCUMENT block describing where the file came from. */
+func FileDocument(in string, stamp string, f io.StringWriter, d *Variables) error {
+	if d.Title != "" {
+		_, err := f.WriteString(fmt.Sprintf("FILE LABEL %s.\n\n", Quote(d.Title)))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := f.WriteString(fmt.Sprint("ADD DOCUMENT\n"))
+	if err != nil {
+		return err
+	}
+	for _, l := range DocumentLines(in, stamp, d) {
+		_, err = f.WriteString(fmt.Sprintf("\t%s\n", Quote(l)))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = f.WriteString(fmt.Sprint(".\n\n"))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+
+/* Writes SORT CASES by the serial variable followed by a block flagging duplicate serials.
+DuplicateCount holds the number of cases with the same serial and PrimaryCase marks the first of them. */
+func Deduplicate(f io.StringWriter, d *Variables) error {
+	serial := ""
+	for _, v := range d.Variable {
+		if v.Use == "serial" {
+			serial = v.Name
+			break
+		}
+	}
+	if serial == "" {
+		return fmt.Errorf("no variable is marked use=\"serial\", cannot flag duplicates")
+	}
+	_, err := f.WriteString(fmt.Sprintf("SORT CASES BY %s(A).\n"+
+		"AGGREGATE OUTFILE=* MODE=ADDVARIABLES\n/BREAK=%s\n/DuplicateCount=N.\n"+
+		"MATCH FILES FILE=*\n/BY %s\n/FIRST=PrimaryCase.\n"+
+		"VARIABLE LABELS\n\tDuplicateCount\t\"Number of cases with this %s\"\n\tPrimaryCase\t\"First case with this %s\"\n.\n"+
+		"EXECUTE.\n\n", serial, serial, serial, serial, serial))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+
+/* Returns the named weight variable, or the variable marked use="weight" when no name is given.
+An empty name is returned when the survey is not weighted. */
+func WeightVariable(name string, d *Variables) (string, error) {
+	for _, v := range d.Variable {
+		if (name == "" && v.Use == "weight") || (name != "" && v.Name == name) {
+			return v.Name, nil
+		}
+	}
+	if name != "" {
+		return "", fmt.Errorf("weight variable %s does not exist", name)
+	}
+	return "", nil
+}
+
+/* Writes WEIGHT BY for the named variable, or for the variable marked use="weight" when no name is given. */
+func WeightBy(name string, f io.StringWriter, d *Variables) error {
+	name, err := WeightVariable(name, d)
+	if err != nil || name == "" {
+		return err
+	}
+	_, err = f.WriteString(fmt.Sprintf("WEIGHT BY %s.\n\n", name))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+
+/* Writes ALTER TYPE shrinking strings wider than MaxShortString to the longest value actually read.
+Open-ends are declared generously and would otherwise bloat the .sav. */
+func AlterLongStrings(f io.StringWriter, d *Variables) error {
+	var long []string
+	for _, v := range d.Variable {
+		if v.Format() == "" && v.DateFormat() == "" && v.Width() > MaxShortString {
+			long = append(long, v.Name)
+		}
+	}
+	if len(long) == 0 {
+		return nil
+	}
+	_, err := f.WriteString(fmt.Sprintf("ALTER TYPE %s (AMIN).\n\n", strings.Join(long, " ")))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+
+/* Creates a line to save the SPSS file as a *.sav */
+func SaveToSPSS(p string, fn string, f io.StringWriter) error {
+	_, err := f.WriteString(fmt.Sprintf("SAVE OUTFILE=%s\n/COMPRESSED.", Quote(p+"/"+fn+".sav")))
+	return err
+}
+
+
+/* Reads the Triple-S file, or standard input when input is -. */
+func ReadMetadata(input string) (*Variables, error) {
+	xmlFile, err := OpenInput(input)
+	if err != nil {
+		return nil, err
+	}
+	defer xmlFile.Close()
+	src, p := ProgressReader(input, xmlFile)
+	defer p.Finish()
+	d, err := ParseMetadata(input, src)
+	if d != nil {
+		d.repaired, d.dropped = Repair(d)
+	} // Every command reads the variables with a position they can be read from
+	return d, err
+}
+
+/* Standard input read as the Triple-S file -, kept to read it again */
+var stdinCopy []byte
+var stdinRead bool
+
+/* Opens the file input, or standard input when input is -, which can be opened more than once. */
+func OpenInput(input string) (*os.File, error) {
+	if input != "-" {
+		file, err := os.Open(input) // Opens the XML file
+		if err != nil {
+			return nil, err
+		}
+		return gunzipInput(file)
+	}
+	if !stdinRead {
+		var err error
+		stdinCopy, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		stdinRead = true
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		w.Write(stdinCopy)
+		w.Close()
+	}()
+	if bytes.HasPrefix(stdinCopy, gzipMagic) {
+		return gunzipPipe(r)
+	}
+	return r, nil
+}
+
+/* Returns the metadata of the Triple-S file input read from r. The file is read as a stream of
+elements, decoding one variable at a time, so only the variables are held in memory however large the
+file is. Types, uses and value labels repeat across variables and are kept once each. */
+func ParseMetadata(input string, r io.Reader) (*Variables, error) {
+	data := new(Variables)
+	dec := xml.NewDecoder(r)
+	strs := make(map[string]string)
+	intern := func(s *string) {
+		if k, ok := strs[*s]; ok {
+			*s = k
+		} else {
+			strs[*s] = *s
+		}
+	}
+	fail := func(err error, variable string) (*Variables, error) {
+		line, column := dec.InputPos()
+		e := &ParseError{File: input, Line: line, Column: column, Variable: variable, Err: err}
+		var syntax *xml.SyntaxError
+		if errors.As(err, &syntax) || *strict {
+			return nil, e
+		}
+		Warn(fmt.Sprintf("Only part of the metadata was read, reading stopped at %v", e))
+		return data, nil
+	} // Errors outside the variables stop the reading, what was read before is kept
+
+	var path []string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fail(err, "")
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name, parent := t.Name.Local, strings.Join(path, ">")
+			var text *string
+			switch parent + ">" + name {
+			case ">sss":
+				data.SssVersion = attr(t, "version")
+			case ">" + name:
+				return fail(fmt.Errorf("expected element type <sss> but have <%s>", name), "")
+			case "sss>date":
+				text = &data.Date
+			case "sss>time":
+				text = &data.Time
+			case "sss>origin":
+				text = &data.Origin
+			case "sss>user":
+				text = &data.User
+			case "sss>survey>name":
+				text = &data.Name
+			case "sss>survey>version":
+				text = &data.Version
+			case "sss>survey>title":
+				text = &data.Title
+			case "sss>survey>record":
+				data.Href = attr(t, "href")
+			case "sss>survey>record>variable":
+				toks, err := elementTokens(dec, t)
+				if err != nil {
+					return fail(err, attr(t, "ident"))
+				}
+				v, err := decodeVariable(toks)
+				if err != nil && !*strict {
+					var left []string
+					toks, left = readableTokens(toks)
+					v, err = decodeVariable(toks)
+					for _, l := range left {
+						Warn(fmt.Sprintf("Left out %s of variable %s, it is not a whole number", l, variableName(v, t)))
+					}
+				} // A value that cannot be read is left out, not the variables after it
+				if err != nil && *strict {
+					return fail(err, variableName(v, t))
+				} else if err != nil {
+					line, column := dec.InputPos()
+					Warn(fmt.Sprintf("Left out %v", &ParseError{File: input, Line: line, Column: column, Variable: variableName(v, t), Err: err}))
+					continue
+				}
+				intern(&v.Type)
+				intern(&v.Use)
+				for i := range v.Vals {
+					intern(&v.Vals[i].Name)
+				}
+				data.Variable = append(data.Variable, v)
+				continue
+			}
+			if text != nil {
+				err = dec.DecodeElement(text, &t)
+				if err != nil {
+					return fail(err, "")
+				}
+				continue
+			}
+			path = append(path, name)
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		}
+	}
+	slog.Debug(fmt.Sprintf("Read %d variables from %s", len(data.Variable), input))
+	return data, nil
+}
+
+/* Returns the tokens of the element start has been read for, up to its end element, copied as the
+decoder reuses them. */
+func elementTokens(dec *xml.Decoder, start xml.StartElement) ([]xml.Token, error) {
+	toks := []xml.Token{start.Copy()}
+	for depth := 1; depth > 0; {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+		toks = append(toks, xml.CopyToken(t))
+	}
+	return toks, nil
+}
+
+/* Reads the tokens one after the other for decoding what was read before again */
+type tokenList []xml.Token
+
+func (l *tokenList) Token() (xml.Token, error) {
+	if len(*l) == 0 {
+		return nil, io.EOF
+	}
+	t := (*l)[0]
+	*l = (*l)[1:]
+	return t, nil
+}
+
+func decodeVariable(toks []xml.Token) (Variable, error) {
+	var v Variable
+	list := tokenList(toks)
+	err := xml.NewTokenDecoder(&list).Decode(&v)
+	return v, err
+}
+
+/* Attributes of the elements of a variable read as whole numbers */
+var numberAttributes = map[string][]string{"value": {"code"}, "position": {"start", "finish"},
+	"spread": {"subfields", "width"}}
+
+/* Returns the tokens of a variable without the elements that have an attribute which is no whole
+number, and those elements as they were written. A variable without its position is left out by Repair. */
+func readableTokens(toks []xml.Token) ([]xml.Token, []string) {
+	var kept []xml.Token
+	var left []string
+	skip := 0
+	for _, t := range toks {
+		if skip > 0 {
+			switch t.(type) {
+			case xml.StartElement:
+				skip++
+			case xml.EndElement:
+				skip--
+			}
+			continue
+		}
+		if e, ok := t.(xml.StartElement); ok {
+			for _, name := range numberAttributes[e.Name.Local] {
+				value := strings.TrimSpace(attr(e, name))
+				if _, err := strconv.ParseInt(value, 10, 64); value != "" && err != nil {
+					left = append(left, fmt.Sprintf("<%s %s=%q>", e.Name.Local, name, attr(e, name)))
+					skip = 1
+					break
+				}
+			}
+			if skip > 0 {
+				continue
+			}
+		}
+		kept = append(kept, t)
+	}
+	return kept, left
+}
+
+/* Returns the name of a variable for messages, its ident when it has none. */
+func variableName(v Variable, start xml.StartElement) string {
+	if v.Name != "" {
+		return v.Name
+	}
+	return attr(start, "ident")
+}
+
+/* Returns the value of the attribute name of the element, or an empty string. */
+func attr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+/* Returns the directory and the name without extension of the files written for the file input: those
+of input itself, or what output gives. An output that is a directory, ends in a slash or has no extension
+is a directory the files keep the name of input in. Metadata read from
+standard input is named survey in the working directory. */
+func OutputName(input string, output string) (string, string, error) {
+	dir, fn := path.Dir(input), strings.TrimSuffix(path.Base(input), path.Ext(input))
+	if input == "-" {
+		dir, fn = ".", "survey"
+	}
+	if output == "" || output == "-" {
+		return dir, fn, nil
+	}
+	info, err := os.Stat(output)
+	if err == nil && info.IsDir() || strings.HasSuffix(output, "/") || path.Ext(output) == "" {
+		return path.Clean(output), fn, nil
+	}
+	return path.Dir(output), strings.TrimSuffix(path.Base(output), path.Ext(output)), nil
+}
+
+/* Returns the name without extension of the files written for the survey d read from input, made
+from the template by replacing {input} with the name of input without extension, {survey}, {version},
+{title}, {date} and {time} with those of the metadata. Characters file systems do not allow in names
+are replaced with _. */
+func NameFromTemplate(template string, input string, d *Variables) (string, error) {
+	r := strings.NewReplacer("{input}", strings.TrimSuffix(path.Base(input), path.Ext(input)), "{survey}", d.Name,
+		"{version}", d.Version, "{title}", d.Title, "{date}", d.Date, "{time}", d.Time)
+	name := r.Replace(strings.TrimSuffix(template, path.Ext(template)))
+	if strings.ContainsAny(name, "{}") {
+		return "", UsageError(fmt.Sprintf("unknown field in -name-template %s, use {input}, {survey}, {version}, {title}, {date} or {time}", template))
+	}
+	name = strings.Map(func(c rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, c) || unicode.IsSpace(c) || unicode.IsControl(c) {
+			return '_'
+		}
+		return c
+	}, strings.TrimSpace(name))
+	if name == "" {
+		return "", UsageError(fmt.Sprintf("-name-template %s gives an empty name for %s", template, input))
+	}
+	return name, nil
+}
+
+/* Outputs -to can write */
+var Outputs = []string{"sps", "sav", "stata", "sas", "r", "python", "csv", "json", "parquet", "xlsx", "sqlite",
+	"postgres", "sss", "cspro", "mplus", "redcap", "limesurvey"}
+
+/* Returns the set of outputs listed in to, separated by commas. */
+func OutputTargets(to string) (map[string]bool, error) {
+	targets := make(map[string]bool)
+	for _, t := range strings.Split(to, ",") {
+		if !contains(Outputs, t) {
+			return nil, UsageError(fmt.Sprintf("Unknown output %s, use %s", t, strings.Join(Outputs, ", ")))
+		}
+		targets[t] = true
+	}
+	return targets, nil
+}
+
+/* Returns the path of the data file the href of the record points to, resolved relative to the
+Triple-S file. Only local files and file: URLs can be read, and with -confine-href only files in the
+folder of the Triple-S file or below it. */
+func DataPath(input string, href string) (string, error) {
+	if href == "" {
+		return "", fmt.Errorf("no data file is given and the metadata does not name one")
+	}
+	if strings.HasPrefix(href, "file://") {
+		href = strings.TrimPrefix(href, "file://")
+	} else if strings.Contains(href, "://") {
+		return "", fmt.Errorf("the data file %s named by the metadata is not a local file", href)
+	}
+	if *confineHref && !filepath.IsLocal(filepath.FromSlash(href)) {
+		return "", fmt.Errorf("the data file %s named by the metadata is outside the folder of the Triple-S file: %w", href, ErrSchema)
+	}
+	if path.IsAbs(href) || len(href) > 1 && href[1] == ':' {
+		return href, nil
+	}
+	return path.Join(path.Dir(input), href), nil
+}
+
+
+func main() {
+	if len(os.Args) > 1 && Commands[os.Args[1]] != nil {
+		err := Commands[os.Args[1]](os.Args[2:])
+		if err != nil {Exit(err)}
+		RemoveTempFolder()
+		return
+	} // Commands other than convert do their work alone
+
+	var args []string
+	if len(os.Args) > 1 && (os.Args[1] == "convert" || os.Args[1] == "watch") {
+		args = parseArgs(flag.CommandLine, os.Args[2:])
+	} else {
+		flag.Parse()
+		args = flag.Args()
+	} // Without a command the arguments are those of convert
+
+	logTo := *logFile
+	if *dryRun {
+		logTo = ""
+	} // A dry run writes no log file, ReportDryRun lists it
+	err := SetupLogging(*verbose, *quiet, *logFormat, logTo)
+	if err != nil {Exit(err)}
+	defer func() {
+		if *exitWarnings && len(Warnings) > 0 {
+			os.Exit(ExitWarnings)
+		}
+	}() // Runs last, after the files are written
+	defer RemoveTempFolder() // After the summary has the checksums of downloaded and unpacked inputs
+	if *glob != "" || len(os.Args) > 1 && os.Args[1] == "watch" {
+		err := CheckBatchFlags()
+		if err != nil {Exit(err)}
+	} // Before anything is written
+	if *summary != "" && !*dryRun {
+		atExit = append(atExit, func(err error) {
+			WriteSummary(*summary, err)
+		})
+		defer func() {
+			err := WriteSummary(*summary, nil)
+			if err != nil {
+				atExit = nil
+				Exit(err)
+			}
+		}()
+	} // Also written when the run fails, but not on a dry run
+	if *pprofAddr != "" || *cpuProfile != "" || *memProfile != "" {
+		stop, err := StartProfiling(*pprofAddr, *cpuProfile, *memProfile)
+		if err != nil {Exit(err)}
+		defer stop()
+	} // Profiles the whole run, ended after the benchmark is reported
+	if *bench {
+		benchmark = NewBench()
+		defer func() {
+			err := benchmark.Report(os.Stderr, runBytes(), runVariables)
+			if err != nil {Exit(err)}
+		}()
+	} // Reported once every output is written
+
+	if *cache != "" {
+		surveyCache, err = LoadCache(*cache)
+		if err != nil {Exit(err)}
+	} // Shared by the runs over many surveys
+
+	if len(os.Args) > 1 && os.Args[1] == "watch" {
+		if len(args) != 1 {
+			Exit(UsageError("Usage: XMLtoSPS watch [-interval d] [-done-dir dir] [-error-dir dir] [options] <folder>"))
+		}
+		done, failed := *doneDir, *errorDir
+		if done == "" {
+			done = path.Join(args[0], "done")
+		}
+		if failed == "" {
+			failed = path.Join(args[0], "error")
+		}
+		muteWarnings = true
+		err := Watch(args[0], *interval, done, failed)
+		if err != nil {Exit(err)}
+		return
+	} // Converts the surveys dropped in to a folder until stopped
+
+	if *glob != "" {
+		muteWarnings = true
+		err := Batch(*glob, *jobs)
+		if err != nil {Exit(err)}
+		return
+	} // Converts many surveys, each in a run of its own
+
+	if *from != "" && *from != "sav" {
+		Exit(UsageError(fmt.Sprintf("Unknown -from %s, use sav", *from)))
+	} else if *from == "sav" && len(args) != 1 {
+		Exit(UsageError("Usage: XMLtoSPS -from sav [-csv-data] [-sss-version v] <SAV:filepath>"))
+	}
+	if *from == "sav" {
+		in := args[0]
+		dir, fn, err := OutputName(in, *output)
+		if err != nil {Exit(err)}
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {Exit(err)}
+		out := fmt.Sprintf("%s/%s", dir, fn)
+		data, err := SavToSss(in, out, *sssVersion)
+		if err != nil {Exit(err)}
+		if *csvData {
+			err = WriteCsv(out+".asc", out+".csv", data)
+			if err != nil {Exit(err)}
+		}
+		return
+	} // Converts the other way: a system file to Triple-S
+
+	if len(args) < 1 {
+		Exit(UsageError(usage))
+	} // Makes sure we have enough arguments to run the program
+	if n := len(args); n > 1 && args[n-1] == "-" && (*output == "" || *output == "-") {
+		*output, args = "-", args[:n-1]
+	} // A last - is the syntax written to standard output, as with -o -
+	if contains(args[1:], "-") {
+		Exit(UsageError("Data files cannot be read from standard input, give - last for the syntax to go to standard output or -o - instead"))
+	}
+	if IsRemote(*output) {
+		local, prefix, err := RemoteOutput(*output)
+		if err != nil {Exit(err)}
+		*output = local
+		defer func() {
+			err := UploadOutputs(prefix)
+			if err != nil {Exit(err)}
+		}()
+	} // Written to a temporary folder and uploaded once every output is closed
+	if *output == "" && IsRemote(args[0]) {
+		*output = "."
+	} else if *output == "" && IsZip(args[0]) {
+		*output = path.Dir(args[0])
+	} // Not next to the input, which is in the temporary folder
+	args, err = FetchInputs(args)
+	if err != nil {Exit(err)}
+	args, err = UnpackDelivery(args)
+	if err != nil {Exit(err)}
+	input := args[0]
+	runMetadata = input
+	data, err := ReadMetadata(input)
+	if err != nil {
+		Exit(err)
+	}
+	err = CheckParse(input, data, *strict)
+	if err != nil {Exit(err)}
+	benchmark.Step("parse metadata")
+	for _, w := range MissingLabels(data, *placeholderLabels) {
+		Warn(w)
+	}
+	if duplicates := DuplicateCodes(data, *duplicateLabels); len(duplicates) > 0 {
+		Warn(fmt.Sprintf("Duplicate values, of a code given twice SPSS keeps the last label: %s", strings.Join(duplicates, "; ")))
+	}
+	runVariables = len(data.Variable)
+	targets, err := OutputTargets(*to)
+	if err != nil {Exit(err)}
+	sss := *data
+	sss.Variable = append([]Variable(nil), data.Variable...)
+	// The Triple-S output keeps the names the other outputs change
+	var renames []Rename
+	if len(targets) > 1 || !targets["sss"] {
+		renames = SanitizeNames(data)
+		if len(renames) > 0 {
+			Warn(fmt.Sprintf("%d variables renamed as SPSS does not accept their names", len(renames)))
+			for _, line := range RenameTable(renames) {
+				slog.Info(line)
+			}
+		}
+		if taken := RenameDuplicates(data); len(taken) > 0 {
+			Warn(fmt.Sprintf("%d variables renamed as their names are taken", len(taken)))
+			for _, line := range RenameTable(taken) {
+				slog.Info(line)
+			}
+			renames = append(renames, taken...)
+		} // The syntax would define the same variable twice
+	}
+	if overlaps := Overlaps(data); len(overlaps) > 0 {
+		Warn(fmt.Sprintf("Overlapping positions are read twice: %s", strings.Join(overlaps, "; ")))
+	}
+	if gaps := Gaps(data); len(gaps) > 0 {
+		Warn(fmt.Sprintf("Columns without a variable: %s", strings.Join(gaps, "; ")))
+	}
+	if len(args) < 2 && *to != "sss" && data.Href == "" {
+		Exit(UsageError(usage))
+	} // The data file may be left out when the metadata names it
+
+	dl, ok := Dialects[*dialect]
+	if !ok {
+		Exit(UsageError(fmt.Sprintf("Unknown dialect %s, use spss or pspp", *dialect)))
+	}
+
+	dir, fn, err := OutputName(input, *output)
+	if err != nil {Exit(err)}
+	if *nameTemplate != "" {
+		fn, err = NameFromTemplate(*nameTemplate, input, data)
+		if err != nil {Exit(err)}
+	} // The template names the files in the directory of -o
+	for _, t := range []string{"sps", "sav"} {
+		if targets[t] && !(t == "sps" && *output == "-") {
+			err = CheckOverwrite(fmt.Sprintf("%s/%s.%s", dir, fn, t))
+			if err != nil {Exit(err)}
+		}
+	} // Fails before anything is written
+	if *dryRun {
+		tmp, err := ioutil.TempDir("", "xmltosps")
+		if err != nil {Exit(err)}
+		moved := map[string]string{tmp: dir}
+		if *reportFile != "" {
+			moved[path.Join(tmp, "report", path.Base(*reportFile))] = *reportFile
+			*reportFile = path.Join(tmp, "report", path.Base(*reportFile))
+			err = os.Mkdir(path.Dir(*reportFile), 0755)
+			if err != nil {Exit(err)}
+		}
+		defer func() {
+			err := ReportDryRun(tmp, moved, data)
+			if err != nil {Exit(err)}
+		}()
+		dir, *output = tmp, ""
+	} else {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {Exit(err)}
+	} // A dry run writes to a temporary folder and lists what it would have written
+	if len(renames) > 0 {
+		err = WriteNameMap(fmt.Sprintf("%s/%s_names.csv", dir, fn), renames)
+		if err != nil {Exit(err)}
+	}
+
+	if targets["sss"] {
+		if data.SssVersion != *sssVersion {
+			slog.Info(fmt.Sprintf("Rewriting Triple-S %s as %s", data.SssVersion, *sssVersion))
+		}
+		if lost := SssLosses(*sssVersion, &sss); len(lost) > 0 {
+			Warn(fmt.Sprintf("Triple-S %s has no %s", *sssVersion, strings.Join(lost, ", ")))
+		}
+		err = WriteSss(fmt.Sprintf("%s/%s.sss.xml", dir, fn), *sssVersion, &sss)
+		if err != nil {Exit(err)}
+		benchmark.Step("write sss")
+		if len(targets) == 1 {
+			return
+		}
+	} // Written before labels are cut to the SPSS limits
+
+	if cut := TruncateLabels(data, dl, *ellipsis); len(cut) > 0 {
+		Warn(fmt.Sprintf("Labels truncated to the SPSS limits for %s", strings.Join(cut, ", ")))
+	}
+
+	parts := args[1:]
+	asc := ""
+	if len(parts) > 0 {
+		asc = parts[0]
+	} else if source, ok := remoteSources[input]; ok && data.Href != "" {
+		location, err := RemoteReference(source, data.Href)
+		if err != nil {Exit(err)}
+		asc, err = Fetch(location)
+		if err != nil {Exit(err)}
+		parts = []string{asc}
+		slog.Info(fmt.Sprintf("Reading the data from %s named by the metadata", RedactURL(location)))
+	} else {
+		asc, err = DataPath(input, data.Href)
+		if err != nil {Exit(err)}
+		parts = []string{asc}
+		slog.Info(fmt.Sprintf("Reading the data from %s named by the metadata", deliveryNames(parts)[0]))
+	}
+	runData, runConverted = parts, parts
+
+	if *manifest {
+		defer func() {
+			err := WriteManifest(fmt.Sprintf("%s/%s_manifest.json", dir, fn), input, runData)
+			if err != nil {Exit(err)}
+		}()
+	} // Written once every output is closed
+	if len(parts) == 1 || *addFiles {
+		parts, err = SyntaxData(parts, dir, fn)
+		if err != nil {Exit(err)}
+		asc = parts[0]
+		for i, p := range parts {
+			if !fileExists(runData[i]) {
+				runData[i] = p
+			}
+		} // Data moved out of the temporary folder is summarized where it went
+	} // SPSS reads no compressed data, the syntax names a decompressed copy kept with the outputs
+	if len(parts) > 1 {
+		copies := *dataEncoding != "" || *dateLayout != "" || *isoDates || *clean || *dropDuplicates ||
+			*anonymizeSerial == "hash" || *anonymizeText == "blank" || *verbatims
+		if *addFiles && copies {
+			Exit(UsageError("-add-files reads the data files as they are and cannot be combined with options writing a copy of the data"))
+		}
+		out := fmt.Sprintf("%s/%s_all.asc", dir, fn)
+		counts, err := StackData(parts, out)
+		if err != nil {Exit(err)}
+		slog.Info(fmt.Sprintf("%d data files with %v records were stacked in to %s", len(parts), counts, out))
+		asc = out
+	} // Converts the batches as one data file
+
+	if *dataEncoding != "" {
+		out := fmt.Sprintf("%s/%s_utf8.asc", dir, fn)
+		widened, err := TranscodeData(asc, out, *dataEncoding, data)
+		if err != nil {Exit(err)}
+		if len(widened) > 0 {
+			slog.Info(fmt.Sprintf("Character fields %s were widened to hold their UTF-8 text", strings.Join(widened, ", ")))
+		}
+		asc = out
+	} // Converts from the transcoded copy of the data
+
+	if *dateLayout != "" || *isoDates {
+		out := fmt.Sprintf("%s/%s_dates.asc", dir, fn)
+		widened, kept, err := NormalizeDates(asc, out, *dateLayout, *isoDates, data)
+		if err != nil {Exit(err)}
+		if len(widened) > 0 {
+			slog.Info(fmt.Sprintf("Date and time fields %s were widened to hold their new layout", strings.Join(widened, ", ")))
+		}
+		if kept > 0 {
+			Warn(fmt.Sprintf("%d dates and times that do not fit their layout were kept as they are", kept))
+		}
+		asc = out
+	} // Converts from the copy with the dates rewritten
+
+	if long := LongStrings(data); len(long) > 0 && *longText {
+		out := fmt.Sprintf("%s/%s_longtext.txt", dir, fn)
+		err = ExportLongText(asc, out, data)
+		if err != nil {Exit(err)}
+		data.Variable = DropVariables(data.Variable, long)
+		slog.Info(fmt.Sprintf("Character fields %s were written to %s", strings.Join(long, ", "), out))
+	} else if len(long) > 0 {
+		Warn(fmt.Sprintf("Character fields wider than %d bytes are cut for %s", MaxString, strings.Join(long, ", ")))
+	}
+
+	if text := TextVariables(data); len(text) > 0 && *verbatims {
+		out := fmt.Sprintf("%s/%s_verbatims.csv", dir, fn)
+		rows, err := ExportVerbatims(asc, out, data)
+		if err != nil {Exit(err)}
+		slog.Info(fmt.Sprintf("Character fields %s of the %d cases with text were written to %s", strings.Join(text, ", "), rows, out))
+		out = fmt.Sprintf("%s/%s_noverbatims.asc", dir, fn)
+		err = AnonymizeData(asc, out, false, "", text, data)
+		if err != nil {Exit(err)}
+		data.Variable = DropVariables(data.Variable, text)
+		asc = out
+	} // Converts from the copy without the verbatims
+
+	if *validate {
+		var report io.Writer
+		if *reportFile != "" {
+			file, err := Create(*reportFile)
+			if err != nil {Exit(err)}
+			defer file.Close()
+			buf := bufio.NewWriter(file)
+			defer buf.Flush()
+			report = buf
+		}
+		problems, err := ValidateData(asc, report, data)
+		if err != nil {Exit(err)}
+		for _, p := range problems {
+			Warn(p.String())
+		}
+	} // Reports data that does not fit the metadata before any output is written
+
+	if *clean {
+		out := fmt.Sprintf("%s/%s_clean.asc", dir, fn)
+		blanked, err := CleanData(asc, out, data)
+		if err != nil {Exit(err)}
+		if blanked > 0 {
+			slog.Info(fmt.Sprintf("%d values that do not fit their numeric variables were blanked in %s", blanked, out))
+		}
+		asc = out
+	} // Converts from the cleaned copy of the data
+
+	if *dropDuplicates {
+		out := fmt.Sprintf("%s/%s_dedup.asc", dir, fn)
+		dropped, err := DropDuplicates(asc, out, data)
+		if err != nil {Exit(err)}
+		slog.Info(fmt.Sprintf("%d records repeating an earlier serial were left out of %s", dropped, out))
+		asc = out
+	} // Converts from the copy without duplicate serials
+
+	if *anonymizeSerial != "" || *anonymizeText != "" {
+		var drop, blank []string
+		switch *anonymizeText {
+		case "":
+		case "blank":
+			blank = TextVariables(data)
+		case "drop":
+			drop = TextVariables(data)
+		default:
+			Exit(UsageError(fmt.Sprintf("Unknown -anonymize-text %s, use blank or drop", *anonymizeText)))
+		}
+		switch *anonymizeSerial {
+		case "", "hash":
+		case "drop":
+			if serial := Serial(data); serial != nil {
+				drop = append(drop, serial.Name)
+			}
+		default:
+			Exit(UsageError(fmt.Sprintf("Unknown -anonymize-serial %s, use drop or hash", *anonymizeSerial)))
+		}
+		if *anonymizeSerial == "hash" && *hashKey == "" {
+			Warn("Serials hashed without -hash-key can be recovered by hashing candidate serials")
+		}
+		if *anonymizeSerial == "hash" || len(blank) > 0 {
+			out := fmt.Sprintf("%s/%s_anon.asc", dir, fn)
+			err = AnonymizeData(asc, out, *anonymizeSerial == "hash", *hashKey, blank, data)
+			if err != nil {Exit(err)}
+			asc = out
+		}
+		if len(drop) > 0 {
+			data.Variable = DropVariables(data.Variable, drop)
+			slog.Info(fmt.Sprintf("Variables %s were left out", strings.Join(drop, ", ")))
+		}
+	} // Converts from the anonymized copy of the data
+
+	if *output == "-" && *to != "sps" {
+		Exit(UsageError("Only the syntax alone can be written to standard output, give -o a path for -to " + *to))
+	}
+
+	runVariables = len(data.Variable)
+	runConverted = parts
+	if !*addFiles || len(parts) == 1 {
+		runConverted = []string{asc}
+	} // The copy of the data converted, whose records the outputs count
+	benchmark.Step("prepare data")
+	for _, t := range strings.Split(*to, ",") {
+		switch t {
+		case "sps", "sss":
+		case "sav":
+			name, err := WeightVariable(*weight, data)
+			if err != nil {Exit(err)}
+			label, docs, created := "", []string(nil), time.Unix(0, 0).UTC()
+			if *timestamp {
+				created = time.Now()
+			}
+			if *document {
+				stamp := ""
+				if *timestamp {
+					stamp = created.UTC().Format(time.RFC3339)
+				}
+				label, docs = data.Title, DocumentLines(path.Base(input), stamp, data)
+			}
+			err = WriteSav(asc, fmt.Sprintf("%s/%s.sav", dir, fn), label, docs, created, name, data)
+			if err != nil {Exit(err)}
+		case "stata":
+			err = WriteStata(asc, fmt.Sprintf("%s/%s", dir, fn), data)
+			if err != nil {Exit(err)}
+		case "sas":
+			err = WriteSas(asc, fmt.Sprintf("%s/%s.sas", dir, fn), data)
+			if err != nil {Exit(err)}
+		case "r":
+			err = WriteR(asc, fmt.Sprintf("%s/%s.R", dir, fn), data)
+			if err != nil {Exit(err)}
+		case "python":
+			err = WritePython(asc, fmt.Sprintf("%s/%s.py", dir, fn), data)
+			if err != nil {Exit(err)}
+		case "csv":
+			err = WriteCsv(asc, fmt.Sprintf("%s/%s.csv", dir, fn), data)
+			if err != nil {Exit(err)}
+			if !targets["json"] {
+				err = WriteCodebook(fmt.Sprintf("%s/%s.json", dir, fn), data)
+				if err != nil {Exit(err)}
+			}
+		case "json":
+			err = WriteCodebook(fmt.Sprintf("%s/%s.json", dir, fn), data)
+			if err != nil {Exit(err)}
+		case "parquet":
+			err = WriteParquet(asc, fmt.Sprintf("%s/%s.parquet", dir, fn), data)
+			if err != nil {Exit(err)}
+		case "sqlite":
+			err = WriteSqlite(asc, fmt.Sprintf("%s/%s.sqlite", dir, fn), *cases, data)
+			if err != nil {Exit(err)}
+		case "postgres":
+			err = WritePostgres(asc, fmt.Sprintf("%s/%s", dir, fn), data)
+			if err != nil {Exit(err)}
+		case "cspro":
+			err = WriteCspro(fmt.Sprintf("%s/%s.dcf", dir, fn), data)
+			if err != nil {Exit(err)}
+		case "mplus":
+			err = WriteMplus(asc, fmt.Sprintf("%s/%s", dir, fn), data)
+			if err != nil {Exit(err)}
+		case "redcap":
+			err = WriteRedcap(fmt.Sprintf("%s/%s_redcap.csv", dir, fn), data)
+			if err != nil {Exit(err)}
+		case "limesurvey":
+			err = WriteLimeSurvey(fmt.Sprintf("%s/%s_limesurvey.txt", dir, fn), data)
+			if err != nil {Exit(err)}
+		case "xlsx":
+			err = WriteXlsx(fmt.Sprintf("%s/%s.xlsx", dir, fn), data)
+			if err != nil {Exit(err)}
+		}
+		if t != "sps" && t != "sss" {
+			benchmark.Step("write " + t)
+		}
+	} // Every output is written from the same metadata and data
+	if !targets["sps"] {
+		return
+	}
+
+	file := os.Stdout
+	if *output != "-" {
+		file, err = Create(fmt.Sprintf("%s/%s.sps", dir, fn)) // Creates the SPS file
+		if err != nil {
+			Exit(fmt.Errorf("Please use forward slash in file path. As an example C:/Users/...\n%w", err))
+		}
+		defer file.Close()
+	}
+	buf := bufio.NewWriterSize(file, 1<<16)
+	out := NewLineWriter(buf, MaxLine) // Lines reach the file in large blocks, not a write each
+
+	handle := ""
+	if dl.FileHandle {
+		handle = "longdata"
+	}
+	if *blanksSysmis {
+		err = SetBlanks(out)
+		if err != nil {Exit(err)}
+	}
+	if *addFiles && len(parts) > 1 {
+		err = AddFiles(parts, handle, *getData, *encoding, out, data)
+	} else if *getData {
+		err = GetData(asc, *encoding, out, data)
+	} else {
+		err = DataList(asc, handle, out, data)
+	}
+	if err != nil {Exit(err)}
+	ProgressStep("data definition", data)
+
+	if *sample > 0 {
+		_, err = out.WriteString(fmt.Sprintf("N OF CASES %d.\n\n", *sample))
+		if err != nil {Exit(err)}
+	} // The syntax reads the same sample
+
+	if *blanksSysmis {
+		err = RestoreBlanks(out)
+		if err != nil {Exit(err)}
+	}
+
+	if dl.AlterType {
+		err = DateTypes(out, data)
+		if err != nil {Exit(err)}
+	}
+
+	err = Formats(out, data, dl.AlterType)
+	if err != nil {Exit(err)}
+	ProgressStep("formats", data)
+
+	err = VariableLabels(out, data)
+	if err != nil {Exit(err)}
+	ProgressStep("variable labels", data)
+
+	err = ValueLabels(*addLabels, out, data)
+	if err != nil {Exit(err)}
+	ProgressStep("value labels", data)
+
+	if dl.MRSets {
+		err = MultipleResponseSets(out, data)
+		if err != nil {Exit(err)}
+	}
+
+	err = VariableRoles(out, data)
+	if err != nil {Exit(err)}
+
+	err = VariableAttributes(out, data)
+	if err != nil {Exit(err)}
+	ProgressStep("roles and attributes", data)
+
+	if *document {
+		stamp := ""
+		if *timestamp {
+			stamp = time.Now().UTC().Format(time.RFC3339)
+		}
+		err = FileDocument(path.Base(input), stamp, out, data)
+		if err != nil {Exit(err)}
+	}
+
+	if *dedup {
+		err = Deduplicate(out, data)
+		if err != nil {Exit(err)}
+	}
+
+	if dl.AlterType {
+		err = AlterLongStrings(out, data)
+		if err != nil {Exit(err)}
 	}
-	defer file.Close()
-
-	err = DataList(os.Args[2], file, data)
-	if err != nil {log.Fatalln(err)}
 
-	err = VariableLabels(file, data)
-	if err != nil {log.Fatalln(err)}
+	err = WeightBy(*weight, out, data)
+	if err != nil {Exit(err)}
 
-	err = ValueLabels(file, data)
-	if err != nil {log.Fatalln(err)}
+	err = SaveToSPSS(dir, fn, out)
+	if err != nil {Exit(err)}
 
-	err = SaveToSPSS(path.Dir(input), fn, file)
-	if err != nil {log.Fatalln(err)}
+	err = out.Flush()
+	if err != nil {Exit(err)}
+	err = buf.Flush()
+	if err != nil {Exit(err)}
+	benchmark.Step("write sps")
 }
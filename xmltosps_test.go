@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+
+/* A small survey with a serial, a single, a multiple, a weight with decimals and an open end. The third
+case leaves the single and the weight blank. */
+const testSurvey = `<?xml version="1.0" encoding="UTF-8"?>
+<sss version="2.0">
+  <survey>
+    <name>TEST</name>
+    <title>Test survey</title>
+    <record ident="A">
+      <variable ident="1" type="character" use="serial">
+        <name>ID</name>
+        <label>Respondent</label>
+        <position start="1" finish="4"/>
+      </variable>
+      <variable ident="2" type="single">
+        <name>Q1</name>
+        <label>Gender</label>
+        <position start="5" finish="5"/>
+        <values>
+          <value code="1">Male</value>
+          <value code="2">Female</value>
+        </values>
+      </variable>
+      <variable ident="3" type="multiple">
+        <name>Q2</name>
+        <label>Brands</label>
+        <position start="6" finish="8"/>
+        <values>
+          <value code="1">Brand A</value>
+          <value code="2">Brand B</value>
+          <value code="3">Brand C</value>
+        </values>
+      </variable>
+      <variable ident="4" type="quantity" use="weight">
+        <name>WT</name>
+        <label>Weight</label>
+        <position start="9" finish="14"/>
+        <values>
+          <range from="0" to="99.999"/>
+        </values>
+      </variable>
+      <variable ident="5" type="character">
+        <name>OE</name>
+        <label>Open end</label>
+        <position start="15" finish="24"/>
+      </variable>
+    </record>
+  </survey>
+</sss>
+`
+
+/* The records of testSurvey */
+var testRecords = []string{
+	"00011101 1.500hello     ",
+	"00022010 2.250          ",
+	"0003 000                ",
+}
+
+/* Returns the variables of testSurvey and the path of its data file in a folder of the test. */
+func testData(t *testing.T) (*Variables, string) {
+	t.Helper()
+	d, err := ParseMetadata("test.xml", strings.NewReader(testSurvey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asc := filepath.Join(t.TempDir(), "test.asc")
+	err = os.WriteFile(asc, []byte(strings.Join(testRecords, "\r\n")+"\r\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, asc
+}
+
+/* Returns the SPSS column names of the variables in order. */
+func testColumns(d *Variables) []string {
+	var names []string
+	for _, v := range d.Variable {
+		for _, c := range v.Columns() {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}